@@ -4,18 +4,31 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"os"
 	"path/filepath"
 	"strings"
 
 	"github.com/nir414/pc-setup/syncer/internal/config"
 	"github.com/nir414/pc-setup/syncer/internal/engine"
+	"github.com/nir414/pc-setup/syncer/internal/history"
+	"github.com/nir414/pc-setup/syncer/internal/journal"
+	"github.com/nir414/pc-setup/syncer/internal/objects"
 	"github.com/nir414/pc-setup/syncer/internal/state"
+	"github.com/nir414/pc-setup/syncer/internal/trash"
 )
 
 const (
 	defaultConfigName = "sync.toml"
 	stateDirName      = ".syncer"
 	stateFileName     = "state.json"
+	machinesDirName   = "state"
+	machineIDFileName = "machine-id"
+	objectsDirName    = "objects"
+	historyFileName   = "history.jsonl"
+	trashDirName      = "trash"
+	journalDirName    = "journal"
+	repoCacheFileName = "repo-cache.json"
+	commandList       = "apply, backup, diff, history, machines, plan, recover, resolve, restore, status, sync, trash"
 )
 
 // App coordinates command execution.
@@ -29,12 +42,20 @@ func New() *App {
 // Run executes the application using the provided arguments.
 func (a *App) Run(ctx context.Context, args []string) error {
 	opts, rest, err := parseGlobalOptions(args)
-	if err != nil {
-		return err
+	if err == nil {
+		err = a.run(ctx, opts, rest)
 	}
+	if err != nil && opts.Output != outputText {
+		if writeErr := writeErrorJSON(os.Stdout, opts.Output, err); writeErr != nil {
+			return errors.Join(err, writeErr)
+		}
+	}
+	return err
+}
 
+func (a *App) run(ctx context.Context, opts globalOptions, rest []string) error {
 	if len(rest) == 0 {
-		return errors.New("no command provided; expected one of: backup, status, sync")
+		return usageErrorf("no command provided; expected one of: %s", commandList)
 	}
 
 	command := rest[0]
@@ -47,47 +68,110 @@ func (a *App) Run(ctx context.Context, args []string) error {
 
 	cfg, err := config.Load(configPath)
 	if err != nil {
-		return fmt.Errorf("load config: %w", err)
+		return &configError{err: err}
+	}
+	cfg, profile, err := selectMachine(cfg, opts.Machine)
+	if err != nil {
+		return err
 	}
 
-	snapshotPath := filepath.Join(root, stateDirName, stateFileName)
-	store := state.NewFileStore(snapshotPath)
+	observer := newObserver(opts.Events)
+	if bar, ok := observer.(*progressBar); ok {
+		defer bar.Close()
+	}
+
+	host, _ := os.Hostname()
+	machineID, idFile, err := localMachineID(host)
+	if err != nil {
+		return &engine.OpError{Op: engine.OpLoadSnapshot, Err: fmt.Errorf("machine id: %w", err)}
+	}
+	machinesDir := filepath.Join(root, stateDirName, machinesDirName)
+	store := state.NewMachineStore(machinesDir, machineID, host, filepath.Join(root, stateDirName, stateFileName), idFile)
+	objectStore := objects.NewStore(filepath.Join(root, stateDirName, objectsDirName))
 
 	eng := engine.New(engine.Options{
 		Root:          root,
 		Config:        cfg,
+		Profile:       profile,
 		SnapshotStore: store,
+		Objects:       objectStore,
+		History:       history.NewLog(filepath.Join(root, stateDirName, historyFileName)),
+		Trash:         trash.NewBin(filepath.Join(root, stateDirName, trashDirName), objectStore),
+		Journal:       journal.New(filepath.Join(root, stateDirName, journalDirName)),
+		RepoCache:     state.NewHashCache(filepath.Join(root, stateDirName, repoCacheFileName)),
+		Rehash:        opts.Rehash,
+		Jobs:          opts.Jobs,
+		Observer:      observer,
 		Logger:        opts.Logger,
 	})
 
 	switch strings.ToLower(command) {
+	case "apply":
+		return a.runApply(ctx, eng, commandArgs, opts)
 	case "backup":
-		return a.runBackup(ctx, eng, commandArgs)
+		return a.runBackup(ctx, eng, commandArgs, opts)
+	case "diff":
+		return a.runDiff(ctx, eng, commandArgs, opts)
+	case "history":
+		return a.runHistory(ctx, eng, commandArgs, opts)
+	case "machines":
+		return a.runMachines(commandArgs, opts, machinesDir, machineID)
+	case "plan":
+		return a.runPlan(ctx, eng, commandArgs, opts)
+	case "recover":
+		return a.runRecover(ctx, eng, commandArgs, opts)
+	case "resolve":
+		return a.runResolve(ctx, eng, commandArgs, opts)
+	case "restore":
+		return a.runRestore(ctx, eng, commandArgs, opts)
 	case "status":
 		return a.runStatus(ctx, eng, commandArgs, opts)
 	case "sync":
 		return a.runSync(ctx, eng, commandArgs, opts)
+	case "trash":
+		return a.runTrash(ctx, eng, commandArgs, opts)
 	case "help", "-h", "--help":
 		fmt.Print(helpText)
 		return nil
 	default:
-		return fmt.Errorf("unknown command %q; expected one of: backup, status, sync", command)
+		return usageErrorf("unknown command %q; expected one of: %s", command, commandList)
 	}
 }
 
-func (a *App) runBackup(ctx context.Context, eng *engine.Engine, args []string) error {
-	if len(args) != 0 {
-		return fmt.Errorf("backup command does not accept additional arguments: %v", args)
+func (a *App) runBackup(ctx context.Context, eng *engine.Engine, args []string, opts globalOptions) error {
+	flags := newCommandFlags("backup")
+	dryRun := flags.Bool("--dry-run", "-n")
+	allowDeletes := flags.Bool("--allow-deletes", "--force")
+	rest, err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 0 {
+		return usageErrorf("backup command does not accept additional arguments: %v", rest)
+	}
+
+	if *dryRun {
+		plan, err := eng.Plan(ctx, engine.DirectionBackup)
+		if err != nil {
+			return err
+		}
+		return a.emitPlan(plan, opts)
 	}
 
-	result, err := eng.Backup(ctx)
+	result, err := eng.Backup(ctx, engine.RunOptions{AllowDeletes: *allowDeletes})
 	if err != nil {
-		return err
+		return reportGuard(err, opts)
+	}
+
+	if opts.Output != outputText {
+		return writeBackupJSON(os.Stdout, opts.Output, result)
 	}
 
-	fmt.Printf("Backup completed: %d files copied, %d skipped, %.2f MiB moved\n",
+	fmt.Printf("Backup completed: %d files copied, %d merged, %d skipped, %d pending sync, %.2f MiB moved\n",
 		result.CopiedFiles,
+		result.MergedFiles,
 		result.SkippedFiles,
+		result.PendingFiles,
 		float64(result.CopiedBytes)/1024/1024,
 	)
 
@@ -96,7 +180,7 @@ func (a *App) runBackup(ctx context.Context, eng *engine.Engine, args []string)
 
 func (a *App) runStatus(ctx context.Context, eng *engine.Engine, args []string, opts globalOptions) error {
 	if len(args) != 0 {
-		return fmt.Errorf("status command does not accept additional arguments: %v", args)
+		return usageErrorf("status command does not accept additional arguments: %v", args)
 	}
 
 	report, err := eng.Status(ctx)
@@ -104,23 +188,62 @@ func (a *App) runStatus(ctx context.Context, eng *engine.Engine, args []string,
 		return err
 	}
 
+	if opts.Output != outputText {
+		return writeStatusJSON(os.Stdout, opts.Output, report)
+	}
+
 	printStatusReport(report)
 	return nil
 }
 
-func (a *App) runSync(ctx context.Context, eng *engine.Engine, args []string, opts globalOptions) error {
-	if len(args) != 0 {
-		return fmt.Errorf("sync command does not accept additional arguments: %v", args)
+func (a *App) runDiff(ctx context.Context, eng *engine.Engine, args []string, opts globalOptions) error {
+	diffs, err := eng.Diff(ctx, args)
+	if err != nil {
+		return err
 	}
 
-	result, err := eng.Sync(ctx)
+	if opts.Output != outputText {
+		return writeDiffsJSON(os.Stdout, opts.Output, diffs)
+	}
+
+	printDiffs(diffs)
+	return nil
+}
+
+func (a *App) runSync(ctx context.Context, eng *engine.Engine, args []string, opts globalOptions) error {
+	flags := newCommandFlags("sync")
+	dryRun := flags.Bool("--dry-run", "-n")
+	allowDeletes := flags.Bool("--allow-deletes", "--force")
+	rest, err := flags.Parse(args)
 	if err != nil {
 		return err
 	}
+	if len(rest) != 0 {
+		return usageErrorf("sync command does not accept additional arguments: %v", rest)
+	}
+
+	if *dryRun {
+		plan, err := eng.Plan(ctx, engine.DirectionSync)
+		if err != nil {
+			return err
+		}
+		return a.emitPlan(plan, opts)
+	}
+
+	result, err := eng.Sync(ctx, engine.RunOptions{AllowDeletes: *allowDeletes})
+	if err != nil {
+		return reportGuard(err, opts)
+	}
+
+	if opts.Output != outputText {
+		return writeSyncJSON(os.Stdout, opts.Output, result)
+	}
 
-	fmt.Printf("Sync completed: %d files updated, %d skipped, %d removals, %.2f MiB moved\n",
+	fmt.Printf("Sync completed: %d files updated, %d merged, %d skipped, %d pending backup, %d removals, %.2f MiB moved\n",
 		result.UpdatedFiles,
+		result.MergedFiles,
 		result.SkippedFiles,
+		result.PendingFiles,
 		result.RemovedFiles,
 		float64(result.UpdatedBytes)/1024/1024,
 	)
@@ -128,6 +251,139 @@ func (a *App) runSync(ctx context.Context, eng *engine.Engine, args []string, op
 	return nil
 }
 
+func (a *App) runPlan(ctx context.Context, eng *engine.Engine, args []string, opts globalOptions) error {
+	flags := newCommandFlags("plan")
+	outPath := flags.String("-", "--out", "-o")
+	rest, err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 1 {
+		return usageErrorf("plan command expects exactly one direction (backup or sync)")
+	}
+
+	dir := engine.Direction(strings.ToLower(rest[0]))
+	if dir != engine.DirectionBackup && dir != engine.DirectionSync {
+		return usageErrorf("unknown plan direction %q; expected backup or sync", rest[0])
+	}
+
+	plan, err := eng.Plan(ctx, dir)
+	if err != nil {
+		return err
+	}
+
+	if *outPath == "-" {
+		return engine.WritePlan(os.Stdout, plan)
+	}
+	if err := writePlanFile(*outPath, plan); err != nil {
+		return fmt.Errorf("write plan: %w", err)
+	}
+	if opts.Output != outputText {
+		return writePlanJSON(os.Stdout, opts.Output, plan)
+	}
+	printPlan(plan)
+	fmt.Printf("\nPlan written to %s\n", *outPath)
+	return nil
+}
+
+func (a *App) runApply(ctx context.Context, eng *engine.Engine, args []string, opts globalOptions) error {
+	flags := newCommandFlags("apply")
+	skipStale := flags.Bool("--skip-stale")
+	allowDeletes := flags.Bool("--allow-deletes", "--force")
+	rest, err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 1 {
+		return usageErrorf("apply command expects exactly one plan file")
+	}
+
+	plan, err := readPlanFile(rest[0])
+	if err != nil {
+		return err
+	}
+
+	result, err := eng.Apply(ctx, plan, engine.ApplyOptions{SkipStale: *skipStale, AllowDeletes: *allowDeletes})
+	if err != nil {
+		return reportGuard(err, opts)
+	}
+
+	if opts.Output != outputText {
+		return writeApplyJSON(os.Stdout, opts.Output, result)
+	}
+
+	fmt.Printf("Apply (%s) completed: %d files copied, %d merged, %d removals, %d skipped, %d pending, %.2f MiB moved\n",
+		result.Direction,
+		result.CopiedFiles,
+		result.MergedFiles,
+		result.RemovedFiles,
+		result.SkippedFiles,
+		result.PendingFiles,
+		float64(result.CopiedBytes)/1024/1024,
+	)
+	for _, path := range result.StalePaths {
+		fmt.Printf("  skipped (changed since planning): %s\n", path)
+	}
+	return nil
+}
+
+// reportGuard lists the folders that tripped the deletion guard before the
+// error itself is reported.
+func reportGuard(err error, opts globalOptions) error {
+	var guard *engine.DeletionGuardError
+	if opts.Output == outputText && errors.As(err, &guard) {
+		printGuard(guard.Triggers)
+	}
+	return err
+}
+
+func writePlanFile(path string, plan *engine.Plan) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := engine.WritePlan(f, plan); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func readPlanFile(path string) (*engine.Plan, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("read plan: %w", err)
+	}
+	defer f.Close()
+	return engine.ReadPlan(f)
+}
+
+func (a *App) emitPlan(plan *engine.Plan, opts globalOptions) error {
+	if opts.Output != outputText {
+		return writePlanJSON(os.Stdout, opts.Output, plan)
+	}
+	printPlan(plan)
+	return nil
+}
+
+// selectMachine applies the profile named by --machine or, without the
+// option, the profile matching this computer's hostname.
+func selectMachine(cfg *config.Config, machine string) (*config.Config, *config.Profile, error) {
+	if machine != "" {
+		name, ok := cfg.MachineFor(machine)
+		if !ok {
+			return nil, nil, usageErrorf("unknown machine profile %q", machine)
+		}
+		return cfg.ForMachine(name)
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		return cfg, nil, nil
+	}
+	name, _ := cfg.MachineFor(host)
+	return cfg.ForMachine(name)
+}
+
 func resolvePaths(opts globalOptions) (string, string, error) {
 	cfgPath := opts.ConfigPath
 	if cfgPath == "" {
@@ -154,15 +410,66 @@ func resolvePaths(opts globalOptions) (string, string, error) {
 const helpText = `syncer - Windows 설정 백업/동기화 도우미
 
 사용법:
-  syncer [전역 옵션] <command>
+  syncer [전역 옵션] <command> [인자]
 
 전역 옵션:
   --config <path>   사용할 TOML 설정 파일 경로 (기본: sync.toml)
   --root <path>     SyncData가 위치한 프로젝트 루트 (기본: 설정 파일 위치)
+  --output <format> 출력 형식: text, json, jsonl (기본: text)
+  --machine <name>  적용할 [machines.<name>] 프로필 (기본: 호스트 이름과 같은 프로필)
+  --rehash          크기·수정 시각이 같아도 캐시된 해시를 쓰지 않고 모든 파일을 다시 읽음
+  --jobs <N>        동시에 해시를 계산할 파일 수 (기본: CPU 개수)
+  --events <mode>   진행 상황 출력: progress(진행 막대), jsonl(이벤트 스트림), none
+                    (기본: 표준 에러가 터미널이면 progress, 아니면 none; 출력은 표준 에러)
 
 명령:
-  backup            시스템 -> 저장소로 백업 실행
+  backup [--dry-run] [--allow-deletes]
+                    시스템 -> 저장소로 백업 실행 (--dry-run: 계획만 출력)
+  machines          이 저장소를 함께 쓰는 PC 목록과 마지막 동기화 시각 출력 (*: 현재 PC)
+  plan <backup|sync> [-o 파일]
+                    실행 계획을 파일(기본: 표준 출력)로 저장
+  apply [--skip-stale] [--allow-deletes] <계획 파일>
+                    저장된 계획 실행 (계획 이후 바뀐 파일이 있으면 거부)
+  diff [경로...]     변경된 파일의 내용 차이 출력 (경로 접두사로 필터)
+  history <경로>     덮어쓰거나 삭제하기 전에 보관한 파일 버전 목록 출력
+  recover [--rollback|--finish]
+                    중단된 backup/sync 실행 확인 (--rollback: 실행 전 상태로 되돌림,
+                    --finish: 중단된 작업을 되돌린 뒤 남은 계획 실행)
+  resolve [--take system|repo|skip] [경로...]
+                    충돌 해결 (--take 없으면 대화형: 시스템/저장소/건너뛰기/편집)
+  restore <경로> --at <시각|해시> [--to system|repo]
+                    보관된 버전 복원 (--to 없으면 보관 당시의 쪽으로 복원)
   status            현재 차이점 요약 출력
-  sync              저장소 -> 시스템 동기화 실행
+  sync [--dry-run] [--allow-deletes]
+                    저장소 -> 시스템 동기화 실행 (--dry-run: 계획만 출력)
+  trash list        삭제 대신 .syncer/trash/<날짜>/로 옮겨 둔 파일 목록 출력
+  trash restore [--force] <id...>
+                    휴지통 항목을 원래 위치로 복원 (--force: 기존 파일 덮어쓰기)
+  trash purge --older-than <기간>
+                    지정 기간(예: 30d, 2w, 12h)보다 오래된 휴지통 항목 영구 삭제
+                    (이력·기준 스냅샷이 참조하지 않는 내용은 .syncer/objects/에서도 제거)
   help              이 도움말 출력
+
+삭제 보호:
+  한 폴더나 섹션에서 삭제될 파일이 max_deletes(기본 20개) 또는
+  max_delete_percent(기본 50%, 파일 10개 이상일 때)를 넘거나, 설정된 폴더가
+  원본 쪽에 아예 없으면 실행을 중단합니다. --allow-deletes(--force)로 무시합니다.
+
+파일 크기·형식 제한:
+  섹션이나 폴더(limits)의 max_file_size보다 크거나, skip_binary일 때 바이너리인
+  파일은 해시하지 않고 양쪽 모두 동기화하지 않습니다. status의 "Skipped by
+  policy" 목록에 적용된 규칙과 함께 표시됩니다.
+
+중단된 실행 복구:
+  backup/sync는 파일을 바꾸기 전에 원래 내용과 할 일을 .syncer/journal/에
+  기록합니다. 실행이 중간에 멈추면(잠긴 파일, 디스크 부족, Ctrl+C 등) 파일을
+  바꾸는 명령(backup, sync, apply, resolve, restore, trash restore)은 거부되며,
+  syncer recover로 되돌리거나 마저 실행해야 합니다. status, diff, history 등
+  조회 명령은 그대로 쓸 수 있습니다.
+
+PC별 기준 스냅샷:
+  마지막 동기화 시점의 기준 상태는 PC마다 .syncer/state/<PC ID>.json에 따로
+  저장되며 저장소에 커밋합니다. PC ID는 처음 실행할 때 사용자 설정 폴더의
+  syncer/machine-id에 만들어지고, SYNCER_MACHINE_ID 환경 변수로 바꿀 수 있습니다.
+  예전의 .syncer/state.json은 처음 실행한 PC가 자기 스냅샷으로 가져갑니다.
 `
@@ -0,0 +1,78 @@
+package app
+
+import "strings"
+
+// commandFlags parses the options of a single command. Options may appear
+// anywhere among the positional arguments, as "--name value", "--name=value"
+// or, for boolean options, a bare "--name".
+type commandFlags struct {
+	command string
+	bools   map[string]*bool
+	values  map[string]*string
+}
+
+func newCommandFlags(command string) *commandFlags {
+	return &commandFlags{
+		command: command,
+		bools:   make(map[string]*bool),
+		values:  make(map[string]*string),
+	}
+}
+
+// Bool registers a boolean option under one or more names.
+func (f *commandFlags) Bool(names ...string) *bool {
+	value := new(bool)
+	for _, name := range names {
+		f.bools[name] = value
+	}
+	return value
+}
+
+// String registers a string option with a default value under one or more names.
+func (f *commandFlags) String(def string, names ...string) *string {
+	value := new(string)
+	*value = def
+	for _, name := range names {
+		f.values[name] = value
+	}
+	return value
+}
+
+// Parse consumes options from args and returns the remaining positional
+// arguments.
+func (f *commandFlags) Parse(args []string) ([]string, error) {
+	var positional []string
+	for idx := 0; idx < len(args); idx++ {
+		token := args[idx]
+		if token == "--" {
+			positional = append(positional, args[idx+1:]...)
+			break
+		}
+		if !strings.HasPrefix(token, "-") || token == "-" {
+			positional = append(positional, token)
+			continue
+		}
+
+		name, value, hasValue := strings.Cut(token, "=")
+		if target, ok := f.bools[name]; ok {
+			if hasValue {
+				return nil, usageErrorf("%s: option %s does not take a value", f.command, name)
+			}
+			*target = true
+			continue
+		}
+		target, ok := f.values[name]
+		if !ok {
+			return nil, usageErrorf("%s: unknown option %s", f.command, name)
+		}
+		if !hasValue {
+			if idx+1 >= len(args) {
+				return nil, usageErrorf("%s: option %s requires a value", f.command, name)
+			}
+			idx++
+			value = args[idx]
+		}
+		*target = value
+	}
+	return positional, nil
+}
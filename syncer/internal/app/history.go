@@ -0,0 +1,128 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/nir414/pc-setup/syncer/internal/engine"
+)
+
+// restoreTimeLayouts are the accepted --at formats, interpreted in local time
+// unless they carry a zone.
+var restoreTimeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+func (a *App) runHistory(ctx context.Context, eng *engine.Engine, args []string, opts globalOptions) error {
+	_ = ctx
+	if len(args) != 1 {
+		return usageErrorf("history command expects exactly one path")
+	}
+
+	versions, err := eng.History(args[0])
+	if err != nil {
+		return err
+	}
+
+	if opts.Output != outputText {
+		return writeHistoryJSON(os.Stdout, opts.Output, args[0], versions)
+	}
+
+	if len(versions) == 0 {
+		fmt.Printf("No saved versions of %s.\n", args[0])
+		return nil
+	}
+	fmt.Printf("Saved versions of %s (newest first):\n", args[0])
+	for _, version := range versions {
+		fmt.Printf("  %s  %-12s  %-12s %-6s %-11s %s  %d bytes\n",
+			version.Time.Local().Format("2006-01-02 15:04:05"),
+			version.Hash[:12],
+			version.Host,
+			version.Side,
+			version.Reason,
+			version.Direction,
+			version.Size,
+		)
+	}
+	return nil
+}
+
+func (a *App) runRestore(ctx context.Context, eng *engine.Engine, args []string, opts globalOptions) error {
+	flags := newCommandFlags("restore")
+	at := flags.String("", "--at")
+	to := flags.String("", "--to")
+	rest, err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 1 {
+		return usageErrorf("restore command expects exactly one path")
+	}
+	if *at == "" {
+		return usageErrorf("restore: --at <time|hash> is required")
+	}
+
+	var restoreOpts engine.RestoreOptions
+	switch *to {
+	case "":
+	case string(engine.ResolveSystem), string(engine.ResolveRepo):
+		restoreOpts.To = engine.Resolution(*to)
+	default:
+		return usageErrorf("restore: --to must be one of: system, repo")
+	}
+	if isHashPrefix(*at) {
+		restoreOpts.Hash = *at
+	} else if restoreOpts.At, err = parseRestoreTime(*at); err != nil {
+		return err
+	}
+
+	result, err := eng.Restore(ctx, rest[0], restoreOpts)
+	if err != nil {
+		return err
+	}
+
+	if opts.Output != outputText {
+		return writeRestoreJSON(os.Stdout, opts.Output, result)
+	}
+
+	fmt.Printf("Restored %s to %s from version %s saved %s on %s.\n",
+		result.Path,
+		result.Side,
+		result.Version.Hash[:12],
+		result.Version.Time.Local().Format("2006-01-02 15:04:05"),
+		result.Version.Host,
+	)
+	fmt.Printf("  written: %s\n", result.Target)
+	return nil
+}
+
+// isHashPrefix reports whether value looks like an abbreviated content hash
+// rather than a time.
+func isHashPrefix(value string) bool {
+	if len(value) < 6 || len(value) > 64 {
+		return false
+	}
+	for _, r := range strings.ToLower(value) {
+		if (r < '0' || r > '9') && (r < 'a' || r > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func parseRestoreTime(value string) (time.Time, error) {
+	for _, layout := range restoreTimeLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, usageErrorf("restore: cannot parse --at %q; expected a hash prefix or a time such as 2006-01-02 15:04", value)
+}
@@ -0,0 +1,63 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/nir414/pc-setup/syncer/internal/state"
+)
+
+// localMachineID returns the ID naming this machine's snapshot and the file
+// to record a new ID in once a snapshot is saved.
+func localMachineID(host string) (string, string, error) {
+	if id := os.Getenv("SYNCER_MACHINE_ID"); id != "" {
+		if !state.ValidMachineID(id) {
+			return "", "", fmt.Errorf("invalid SYNCER_MACHINE_ID %q", id)
+		}
+		return id, "", nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return state.HostMachineID(host), "", nil
+	}
+	path := filepath.Join(dir, "syncer", machineIDFileName)
+	id, stored, err := state.MachineID(path, host)
+	if err != nil || stored {
+		return id, "", err
+	}
+	return id, path, nil
+}
+
+func (a *App) runMachines(args []string, opts globalOptions, dir, current string) error {
+	if len(args) != 0 {
+		return usageErrorf("machines command does not accept additional arguments: %v", args)
+	}
+
+	machines, err := state.ListMachines(dir)
+	if err != nil {
+		return err
+	}
+	if opts.Output != outputText {
+		return writeMachinesJSON(os.Stdout, opts.Output, machines, current)
+	}
+
+	if len(machines) == 0 {
+		fmt.Printf("No machine has synced yet; this machine is %s.\n", current)
+		return nil
+	}
+	for _, machine := range machines {
+		marker := " "
+		if machine.ID == current {
+			marker = "*"
+		}
+		fmt.Printf("%s %-24s %-16s last sync %s  (%d files)\n",
+			marker,
+			machine.ID,
+			machine.Host,
+			machine.LastSync.Local().Format("2006-01-02 15:04:05"),
+			machine.Files,
+		)
+	}
+	return nil
+}
@@ -5,20 +5,27 @@ import (
 	"io"
 	"log"
 	"os"
+	"strconv"
 	"strings"
 
+	"github.com/nir414/pc-setup/syncer/internal/config"
 	"github.com/nir414/pc-setup/syncer/internal/engine"
 )
 
 type globalOptions struct {
 	ConfigPath string
 	RootPath   string
+	Machine    string
 	Verbose    bool
+	Rehash     bool
+	Jobs       int
+	Events     string
+	Output     outputFormat
 	Logger     *log.Logger
 }
 
 func parseGlobalOptions(args []string) (globalOptions, []string, error) {
-	opts := globalOptions{}
+	opts := globalOptions{Output: outputText}
 	idx := 0
 	for idx < len(args) {
 		token := args[idx]
@@ -34,7 +41,7 @@ func parseGlobalOptions(args []string) (globalOptions, []string, error) {
 		switch {
 		case token == "--config" || token == "-c":
 			if idx+1 >= len(args) {
-				return opts, nil, fmt.Errorf("option %s requires a value", token)
+				return opts, nil, usageErrorf("option %s requires a value", token)
 			}
 			value = args[idx+1]
 			idx += 2
@@ -47,7 +54,7 @@ func parseGlobalOptions(args []string) (globalOptions, []string, error) {
 			continue
 		case token == "--root":
 			if idx+1 >= len(args) {
-				return opts, nil, fmt.Errorf("option %s requires a value", token)
+				return opts, nil, usageErrorf("option %s requires a value", token)
 			}
 			value = args[idx+1]
 			idx += 2
@@ -58,12 +65,84 @@ func parseGlobalOptions(args []string) (globalOptions, []string, error) {
 			opts.RootPath = value
 			idx++
 			continue
+		case token == "--machine":
+			if idx+1 >= len(args) {
+				return opts, nil, usageErrorf("option %s requires a value", token)
+			}
+			opts.Machine = args[idx+1]
+			idx += 2
+			continue
+		case strings.HasPrefix(token, "--machine="):
+			opts.Machine = strings.TrimPrefix(token, "--machine=")
+			idx++
+			continue
+		case token == "--output":
+			if idx+1 >= len(args) {
+				return opts, nil, usageErrorf("option %s requires a value", token)
+			}
+			format, err := parseOutputFormat(args[idx+1])
+			if err != nil {
+				return opts, nil, err
+			}
+			opts.Output = format
+			idx += 2
+			continue
+		case strings.HasPrefix(token, "--output="):
+			format, err := parseOutputFormat(strings.TrimPrefix(token, "--output="))
+			if err != nil {
+				return opts, nil, err
+			}
+			opts.Output = format
+			idx++
+			continue
 		case token == "--verbose" || token == "-v":
 			opts.Verbose = true
 			idx++
 			continue
+		case token == "--jobs" || token == "-j":
+			if idx+1 >= len(args) {
+				return opts, nil, usageErrorf("option %s requires a value", token)
+			}
+			jobs, err := parseJobs(args[idx+1])
+			if err != nil {
+				return opts, nil, err
+			}
+			opts.Jobs = jobs
+			idx += 2
+			continue
+		case strings.HasPrefix(token, "--jobs="):
+			jobs, err := parseJobs(strings.TrimPrefix(token, "--jobs="))
+			if err != nil {
+				return opts, nil, err
+			}
+			opts.Jobs = jobs
+			idx++
+			continue
+		case token == "--events":
+			if idx+1 >= len(args) {
+				return opts, nil, usageErrorf("option %s requires a value", token)
+			}
+			events, err := parseEvents(args[idx+1])
+			if err != nil {
+				return opts, nil, err
+			}
+			opts.Events = events
+			idx += 2
+			continue
+		case strings.HasPrefix(token, "--events="):
+			events, err := parseEvents(strings.TrimPrefix(token, "--events="))
+			if err != nil {
+				return opts, nil, err
+			}
+			opts.Events = events
+			idx++
+			continue
+		case token == "--rehash":
+			opts.Rehash = true
+			idx++
+			continue
 		default:
-			return opts, nil, fmt.Errorf("unknown option %s", token)
+			return opts, nil, usageErrorf("unknown option %s", token)
 		}
 	}
 
@@ -89,18 +168,59 @@ func parseGlobalOptions(args []string) (globalOptions, []string, error) {
 	return opts, args[idx:], nil
 }
 
+func parseJobs(value string) (int, error) {
+	jobs, err := strconv.Atoi(value)
+	if err != nil || jobs < 1 {
+		return 0, usageErrorf("--jobs expects a positive number, got %q", value)
+	}
+	return jobs, nil
+}
+
+func parseEvents(value string) (string, error) {
+	switch value {
+	case eventsJSONL, eventsProgress, eventsNone:
+		return value, nil
+	}
+	return "", usageErrorf("--events expects one of: jsonl, progress, none; got %q", value)
+}
+
 func printStatusReport(report *engine.StatusReport) {
 	if report == nil {
 		fmt.Println("(no status information)")
 		return
 	}
 
+	if profile := report.Profile; profile != nil {
+		fmt.Printf("Machine profile: %s\n", profile.Name)
+		if len(profile.Disabled) > 0 {
+			fmt.Printf("  Disabled sections : %s\n", strings.Join(profile.Disabled, ", "))
+		}
+		for _, folder := range profile.Suppressed {
+			fmt.Printf("  Suppressed folder : %s\n", folder)
+		}
+		fmt.Println()
+	}
+
 	fmt.Println("Status Summary:")
 	fmt.Printf("  Up-to-date    : %d\n", report.Summary.UpToDate)
 	fmt.Printf("  Needs backup  : %d\n", report.Summary.NeedsBackup)
 	fmt.Printf("  Needs sync    : %d\n", report.Summary.NeedsSync)
 	fmt.Printf("  Conflicts     : %d\n", report.Summary.Conflicts)
 
+	if len(report.Collisions) > 0 {
+		fmt.Println("\nCase collisions (skipped until only one spelling is left):")
+		for _, collision := range report.Collisions {
+			fmt.Printf("  [%s] %s\n", collision.Side, strings.Join(collision.Paths, ", "))
+		}
+	}
+
+	if len(report.PolicySkips) > 0 {
+		fmt.Println("\nSkipped by policy:")
+		for _, skip := range report.PolicySkips {
+			fmt.Printf("  [%s] %s (%s, %s)\n", skip.Side, skip.Path, config.Size(skip.Size), skip.Rule)
+		}
+	}
+
 	if len(report.Entries) == 0 {
 		fmt.Println("\nEverything is up-to-date.")
 		return
@@ -109,6 +229,12 @@ func printStatusReport(report *engine.StatusReport) {
 	fmt.Println("\nDetails:")
 	for _, entry := range report.Entries {
 		fmt.Printf("  [%s] %s\n", entry.Status, entry.Path)
+		switch entry.Renamed {
+		case engine.SideSystem:
+			fmt.Printf("    renamed on system: %s -> %s\n", entry.RepoPath, entry.SystemPath)
+		case engine.SideRepo:
+			fmt.Printf("    renamed in repo: %s -> %s\n", entry.SystemPath, entry.RepoPath)
+		}
 		if entry.System != nil && entry.Repo != nil && entry.System.Hash != entry.Repo.Hash {
 			fmt.Printf("    system hash: %s\n", entry.System.Hash)
 			fmt.Printf("    repo   hash: %s\n", entry.Repo.Hash)
@@ -121,3 +247,69 @@ func printStatusReport(report *engine.StatusReport) {
 		}
 	}
 }
+
+func printDiffs(diffs []engine.FileDiff) {
+	if len(diffs) == 0 {
+		fmt.Println("No differences.")
+		return
+	}
+
+	for i, fd := range diffs {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("[%s] %s (%s -> %s)\n", fd.Entry.Status, fd.Entry.Path, fd.NewLabel, fd.OldLabel)
+		if fd.Binary || fd.TooLarge {
+			if fd.Binary {
+				fmt.Println("  binary files differ")
+			} else {
+				fmt.Println("  files too large to diff")
+			}
+			printSideSummary(fd.OldLabel, fd.Old)
+			printSideSummary(fd.NewLabel, fd.New)
+			continue
+		}
+		fmt.Print(fd.Unified)
+	}
+}
+
+func printSideSummary(label string, info *engine.FileInfo) {
+	if info == nil {
+		fmt.Printf("  %-6s: (missing)\n", label)
+		return
+	}
+	fmt.Printf("  %-6s: %d bytes, sha256 %s\n", label, info.Size, info.Hash)
+}
+
+func printPlan(plan *engine.Plan) {
+	fmt.Printf("Plan (%s): %d to copy, %d to merge, %d to delete, %d skipped\n",
+		plan.Direction,
+		plan.Count(engine.ActionCopy),
+		plan.Count(engine.ActionMerge),
+		plan.Count(engine.ActionDelete),
+		plan.Count(engine.ActionSkip),
+	)
+	if len(plan.Actions) == 0 {
+		fmt.Println("\nNothing to do.")
+		return
+	}
+	if len(plan.Guard) > 0 {
+		fmt.Println()
+		printGuard(plan.Guard)
+	}
+
+	fmt.Println("\nActions:")
+	for _, action := range plan.Actions {
+		fmt.Printf("  %-6s %s (%s)\n", action.Kind, action.Path, action.Reason)
+		if action.RenameFrom != "" {
+			fmt.Printf("         rename %s -> %s\n", action.RenameFrom, action.Target)
+		}
+	}
+}
+
+func printGuard(triggers []engine.GuardTrigger) {
+	fmt.Println("Deletion guard tripped (run with --allow-deletes to proceed):")
+	for _, trigger := range triggers {
+		fmt.Printf("  %-7s %s: %s\n", trigger.Scope, trigger.Path, trigger.Reason)
+	}
+}
@@ -0,0 +1,892 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/nir414/pc-setup/syncer/internal/config"
+	"github.com/nir414/pc-setup/syncer/internal/engine"
+	"github.com/nir414/pc-setup/syncer/internal/history"
+	"github.com/nir414/pc-setup/syncer/internal/state"
+	"github.com/nir414/pc-setup/syncer/internal/trash"
+)
+
+type outputFormat string
+
+const (
+	outputText  outputFormat = "text"
+	outputJSON  outputFormat = "json"
+	outputJSONL outputFormat = "jsonl"
+)
+
+// schemaVersion is bumped whenever a machine-readable record changes in a
+// way that is not backwards compatible.
+const schemaVersion = 1
+
+// Stable error codes emitted in machine-readable error records.
+const (
+	errCodeUsage          = "usage"
+	errCodeConfigNotFound = "config_not_found"
+	errCodeConfigInvalid  = "config_invalid"
+	errCodeSnapshot       = "snapshot_failed"
+	errCodeScan           = "scan_failed"
+	errCodeCopy           = "copy_failed"
+	errCodeRemove         = "remove_failed"
+	errCodePlanInvalid    = "plan_invalid"
+	errCodePlanStale      = "plan_stale"
+	errCodeHistory        = "history_failed"
+	errCodeUnknownPath    = "unknown_path"
+	errCodeNoVersion      = "version_not_found"
+	errCodeTrash          = "trash_failed"
+	errCodeTrashNotFound  = "trash_item_not_found"
+	errCodeDeletionGuard  = "deletion_guard"
+	errCodeJournal        = "journal_failed"
+	errCodeInterrupted    = "interrupted_run"
+	errCodeCanceled       = "canceled"
+	errCodeInternal       = "internal"
+)
+
+func parseOutputFormat(value string) (outputFormat, error) {
+	switch outputFormat(value) {
+	case outputText, outputJSON, outputJSONL:
+		return outputFormat(value), nil
+	default:
+		return "", usageErrorf("unsupported output format %q; expected one of: text, json, jsonl", value)
+	}
+}
+
+// usageError marks errors caused by invalid command line input.
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string {
+	return e.msg
+}
+
+func usageErrorf(format string, args ...any) error {
+	return &usageError{msg: fmt.Sprintf(format, args...)}
+}
+
+// configError marks errors raised while reading the configuration file.
+type configError struct {
+	err error
+}
+
+func (e *configError) Error() string {
+	return fmt.Sprintf("load config: %v", e.err)
+}
+
+func (e *configError) Unwrap() error {
+	return e.err
+}
+
+func errorCode(err error) string {
+	var usage *usageError
+	var cfgErr *configError
+	var opErr *engine.OpError
+	var stale *engine.StalePlanError
+	var guard *engine.DeletionGuardError
+	var interrupted *engine.InterruptedRunError
+	switch {
+	case errors.As(err, &usage):
+		return errCodeUsage
+	case errors.As(err, &cfgErr):
+		if errors.Is(cfgErr.err, os.ErrNotExist) {
+			return errCodeConfigNotFound
+		}
+		return errCodeConfigInvalid
+	case errors.As(err, &stale):
+		return errCodePlanStale
+	case errors.As(err, &guard):
+		return errCodeDeletionGuard
+	case errors.As(err, &interrupted):
+		return errCodeInterrupted
+	case errors.Is(err, engine.ErrInvalidPlan):
+		return errCodePlanInvalid
+	case errors.Is(err, engine.ErrUnknownPath):
+		return errCodeUnknownPath
+	case errors.Is(err, engine.ErrVersionNotFound):
+		return errCodeNoVersion
+	case errors.Is(err, trash.ErrNotFound):
+		return errCodeTrashNotFound
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return errCodeCanceled
+	case errors.As(err, &opErr):
+		switch opErr.Op {
+		case engine.OpCopy:
+			return errCodeCopy
+		case engine.OpRemove:
+			return errCodeRemove
+		case engine.OpScan:
+			return errCodeScan
+		case engine.OpLoadSnapshot, engine.OpSaveSnapshot:
+			return errCodeSnapshot
+		case engine.OpHistory:
+			return errCodeHistory
+		case engine.OpTrash:
+			return errCodeTrash
+		case engine.OpJournal:
+			return errCodeJournal
+		}
+	}
+	return errCodeInternal
+}
+
+type jsonHeader struct {
+	SchemaVersion int    `json:"schema_version"`
+	Kind          string `json:"kind"`
+}
+
+func newHeader(kind string) jsonHeader {
+	return jsonHeader{SchemaVersion: schemaVersion, Kind: kind}
+}
+
+type jsonFile struct {
+	Hash    string    `json:"hash"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+type jsonEntry struct {
+	Path       string    `json:"path"`
+	Status     string    `json:"status"`
+	System     *jsonFile `json:"system"`
+	Repo       *jsonFile `json:"repo"`
+	BaseHash   string    `json:"base_hash,omitempty"`
+	Renamed    string    `json:"renamed,omitempty"`
+	SystemPath string    `json:"system_path"`
+	RepoPath   string    `json:"repo_path"`
+}
+
+type jsonSummary struct {
+	UpToDate    int `json:"up_to_date"`
+	NeedsBackup int `json:"needs_backup"`
+	NeedsSync   int `json:"needs_sync"`
+	Conflicts   int `json:"conflicts"`
+}
+
+type jsonProfile struct {
+	Name              string   `json:"name"`
+	DisabledSections  []string `json:"disabled_sections"`
+	SuppressedFolders []string `json:"suppressed_folders"`
+}
+
+type jsonCollision struct {
+	Side  string   `json:"side"`
+	Paths []string `json:"paths"`
+}
+
+type jsonPolicySkip struct {
+	Path string `json:"path"`
+	Side string `json:"side"`
+	Rule string `json:"rule"`
+	Size int64  `json:"size"`
+}
+
+type jsonStatus struct {
+	jsonHeader
+	GeneratedAt time.Time        `json:"generated_at"`
+	Profile     *jsonProfile     `json:"profile,omitempty"`
+	Summary     jsonSummary      `json:"summary"`
+	Collisions  []jsonCollision  `json:"collisions"`
+	PolicySkips []jsonPolicySkip `json:"skipped_by_policy"`
+	Entries     []jsonEntry      `json:"entries"`
+}
+
+type jsonStatusEntry struct {
+	jsonHeader
+	jsonEntry
+}
+
+type jsonStatusSummary struct {
+	jsonHeader
+	GeneratedAt time.Time        `json:"generated_at"`
+	Profile     *jsonProfile     `json:"profile,omitempty"`
+	Summary     jsonSummary      `json:"summary"`
+	Collisions  []jsonCollision  `json:"collisions"`
+	PolicySkips []jsonPolicySkip `json:"skipped_by_policy"`
+}
+
+type jsonBackupResult struct {
+	jsonHeader
+	CopiedFiles  int   `json:"copied_files"`
+	SkippedFiles int   `json:"skipped_files"`
+	CopiedBytes  int64 `json:"copied_bytes"`
+	MergedFiles  int   `json:"merged_files"`
+	RemovedFiles int   `json:"removed_files"`
+	PendingFiles int   `json:"pending_files"`
+}
+
+type jsonSyncResult struct {
+	jsonHeader
+	UpdatedFiles int   `json:"updated_files"`
+	UpdatedBytes int64 `json:"updated_bytes"`
+	MergedFiles  int   `json:"merged_files"`
+	RemovedFiles int   `json:"removed_files"`
+	SkippedFiles int   `json:"skipped_files"`
+	PendingFiles int   `json:"pending_files"`
+}
+
+type jsonApplyResult struct {
+	jsonHeader
+	Direction    string   `json:"direction"`
+	CopiedFiles  int      `json:"copied_files"`
+	CopiedBytes  int64    `json:"copied_bytes"`
+	MergedFiles  int      `json:"merged_files"`
+	RemovedFiles int      `json:"removed_files"`
+	SkippedFiles int      `json:"skipped_files"`
+	PendingFiles int      `json:"pending_files"`
+	StalePaths   []string `json:"stale_paths"`
+}
+
+type jsonResolution struct {
+	jsonHeader
+	Path   string `json:"path"`
+	Choice string `json:"choice"`
+}
+
+type jsonVersion struct {
+	Hash      string    `json:"hash"`
+	Size      int64     `json:"size"`
+	Time      time.Time `json:"time"`
+	Host      string    `json:"host"`
+	Direction string    `json:"direction"`
+	Side      string    `json:"side"`
+	Reason    string    `json:"reason"`
+}
+
+type jsonHistory struct {
+	jsonHeader
+	Path     string        `json:"path"`
+	Versions []jsonVersion `json:"versions"`
+}
+
+type jsonHistoryVersion struct {
+	jsonHeader
+	Path string `json:"path"`
+	jsonVersion
+}
+
+type jsonRestoreResult struct {
+	jsonHeader
+	Path    string      `json:"path"`
+	Side    string      `json:"side"`
+	Target  string      `json:"target"`
+	Version jsonVersion `json:"version"`
+}
+
+type jsonTrashItem struct {
+	ID        string    `json:"id"`
+	Path      string    `json:"path"`
+	Original  string    `json:"original"`
+	Side      string    `json:"side"`
+	Direction string    `json:"direction"`
+	Reason    string    `json:"reason"`
+	Host      string    `json:"host"`
+	DeletedAt time.Time `json:"deleted_at"`
+	Hash      string    `json:"hash"`
+	Size      int64     `json:"size"`
+}
+
+type jsonTrashRecord struct {
+	jsonHeader
+	jsonTrashItem
+}
+
+type jsonTrash struct {
+	jsonHeader
+	Items []jsonTrashItem `json:"items"`
+}
+
+type jsonInterruptedRun struct {
+	Direction string    `json:"direction"`
+	Host      string    `json:"host"`
+	StartedAt time.Time `json:"started_at"`
+	Actions   int       `json:"actions"`
+	Completed int       `json:"completed"`
+	Pending   []string  `json:"pending"`
+}
+
+type jsonRecovery struct {
+	jsonHeader
+	Interrupted *jsonInterruptedRun `json:"interrupted"`
+}
+
+type jsonRecoverResult struct {
+	jsonHeader
+	Mode         string             `json:"mode"`
+	Run          jsonInterruptedRun `json:"run"`
+	Reverted     []string           `json:"reverted"`
+	CopiedFiles  int                `json:"copied_files"`
+	CopiedBytes  int64              `json:"copied_bytes"`
+	MergedFiles  int                `json:"merged_files"`
+	RemovedFiles int                `json:"removed_files"`
+	SkippedFiles int                `json:"skipped_files"`
+	PendingFiles int                `json:"pending_files"`
+}
+
+type jsonEvent struct {
+	jsonHeader
+	Event      string    `json:"event"`
+	Time       time.Time `json:"time"`
+	Side       string    `json:"side,omitempty"`
+	Direction  string    `json:"direction,omitempty"`
+	Path       string    `json:"path,omitempty"`
+	Action     string    `json:"action,omitempty"`
+	Bytes      int64     `json:"bytes,omitempty"`
+	Cached     bool      `json:"cached,omitempty"`
+	Done       int       `json:"done"`
+	Total      int       `json:"total"`
+	DoneBytes  int64     `json:"done_bytes"`
+	TotalBytes int64     `json:"total_bytes"`
+}
+
+type jsonFileDiff struct {
+	jsonHeader
+	jsonEntry
+	OldSide  string `json:"old_side"`
+	NewSide  string `json:"new_side"`
+	Binary   bool   `json:"binary"`
+	TooLarge bool   `json:"too_large"`
+	Unified  string `json:"unified,omitempty"`
+}
+
+type jsonAction struct {
+	Kind         string `json:"kind"`
+	Path         string `json:"path"`
+	Status       string `json:"status"`
+	Source       string `json:"source,omitempty"`
+	Target       string `json:"target,omitempty"`
+	RenameFrom   string `json:"rename_from,omitempty"`
+	SourceHash   string `json:"source_hash,omitempty"`
+	TargetHash   string `json:"target_hash,omitempty"`
+	ConflictCopy string `json:"conflict_copy,omitempty"`
+	BaseHash     string `json:"base_hash,omitempty"`
+	ResultHash   string `json:"result_hash,omitempty"`
+	Bytes        int64  `json:"bytes"`
+	Reason       string `json:"reason"`
+}
+
+type jsonPlanSummary struct {
+	Copy   int `json:"copy"`
+	Merge  int `json:"merge"`
+	Delete int `json:"delete"`
+	Skip   int `json:"skip"`
+}
+
+type jsonGuardTrigger struct {
+	Scope   string `json:"scope"`
+	Path    string `json:"path"`
+	Deletes int    `json:"deletes"`
+	Files   int    `json:"files"`
+	Reason  string `json:"reason"`
+}
+
+type jsonPlan struct {
+	jsonHeader
+	Direction   string             `json:"direction"`
+	GeneratedAt time.Time          `json:"generated_at"`
+	Summary     jsonPlanSummary    `json:"summary"`
+	Guard       []jsonGuardTrigger `json:"guard"`
+	Actions     []jsonAction       `json:"actions"`
+}
+
+type jsonPlanTotals struct {
+	jsonHeader
+	Direction   string             `json:"direction"`
+	GeneratedAt time.Time          `json:"generated_at"`
+	Summary     jsonPlanSummary    `json:"summary"`
+	Guard       []jsonGuardTrigger `json:"guard"`
+}
+
+type jsonPlanAction struct {
+	jsonHeader
+	Action jsonAction `json:"action"`
+}
+
+type jsonError struct {
+	jsonHeader
+	Error jsonErrorBody `json:"error"`
+}
+
+type jsonErrorBody struct {
+	Code        string              `json:"code"`
+	Message     string              `json:"message"`
+	Guard       []jsonGuardTrigger  `json:"guard,omitempty"`
+	Interrupted *jsonInterruptedRun `json:"interrupted,omitempty"`
+}
+
+func toJSONFile(info *engine.FileInfo) *jsonFile {
+	if info == nil {
+		return nil
+	}
+	return &jsonFile{Hash: info.Hash, Size: info.Size, ModTime: info.ModTime}
+}
+
+func toJSONEntry(entry engine.DiffEntry) jsonEntry {
+	return jsonEntry{
+		Path:       entry.Path,
+		Status:     string(entry.Status),
+		System:     toJSONFile(entry.System),
+		Repo:       toJSONFile(entry.Repo),
+		BaseHash:   entry.BaseHash,
+		Renamed:    string(entry.Renamed),
+		SystemPath: entry.SystemPath,
+		RepoPath:   entry.RepoPath,
+	}
+}
+
+func toJSONSummary(summary engine.StatusSummary) jsonSummary {
+	return jsonSummary{
+		UpToDate:    summary.UpToDate,
+		NeedsBackup: summary.NeedsBackup,
+		NeedsSync:   summary.NeedsSync,
+		Conflicts:   summary.Conflicts,
+	}
+}
+
+func toJSONProfile(profile *config.Profile) *jsonProfile {
+	if profile == nil {
+		return nil
+	}
+	out := &jsonProfile{
+		Name:              profile.Name,
+		DisabledSections:  profile.Disabled,
+		SuppressedFolders: profile.Suppressed,
+	}
+	if out.DisabledSections == nil {
+		out.DisabledSections = []string{}
+	}
+	if out.SuppressedFolders == nil {
+		out.SuppressedFolders = []string{}
+	}
+	return out
+}
+
+func toJSONCollisions(collisions []engine.CaseCollision) []jsonCollision {
+	out := make([]jsonCollision, 0, len(collisions))
+	for _, collision := range collisions {
+		out = append(out, jsonCollision{Side: string(collision.Side), Paths: collision.Paths})
+	}
+	return out
+}
+
+func toJSONPolicySkips(skipped []engine.PolicySkip) []jsonPolicySkip {
+	out := make([]jsonPolicySkip, 0, len(skipped))
+	for _, skip := range skipped {
+		out = append(out, jsonPolicySkip{Path: skip.Path, Side: string(skip.Side), Rule: skip.Rule, Size: skip.Size})
+	}
+	return out
+}
+
+// writeRecords encodes records as a single JSON document (json) or as one
+// compact record per line (jsonl).
+func writeRecords(w io.Writer, format outputFormat, records ...any) error {
+	enc := json.NewEncoder(w)
+	if format == outputJSON {
+		enc.SetIndent("", "  ")
+	}
+	for _, record := range records {
+		if err := enc.Encode(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeStatusJSON(w io.Writer, format outputFormat, report *engine.StatusReport) error {
+	if report == nil {
+		report = &engine.StatusReport{}
+	}
+	if format == outputJSONL {
+		records := make([]any, 0, len(report.Entries)+1)
+		for _, entry := range report.Entries {
+			records = append(records, jsonStatusEntry{jsonHeader: newHeader("status_entry"), jsonEntry: toJSONEntry(entry)})
+		}
+		records = append(records, jsonStatusSummary{
+			jsonHeader:  newHeader("status_summary"),
+			GeneratedAt: report.GeneratedAt,
+			Profile:     toJSONProfile(report.Profile),
+			Summary:     toJSONSummary(report.Summary),
+			Collisions:  toJSONCollisions(report.Collisions),
+			PolicySkips: toJSONPolicySkips(report.PolicySkips),
+		})
+		return writeRecords(w, format, records...)
+	}
+
+	entries := make([]jsonEntry, 0, len(report.Entries))
+	for _, entry := range report.Entries {
+		entries = append(entries, toJSONEntry(entry))
+	}
+	return writeRecords(w, format, jsonStatus{
+		jsonHeader:  newHeader("status"),
+		GeneratedAt: report.GeneratedAt,
+		Profile:     toJSONProfile(report.Profile),
+		Summary:     toJSONSummary(report.Summary),
+		Collisions:  toJSONCollisions(report.Collisions),
+		PolicySkips: toJSONPolicySkips(report.PolicySkips),
+		Entries:     entries,
+	})
+}
+
+func writeBackupJSON(w io.Writer, format outputFormat, result *engine.BackupResult) error {
+	return writeRecords(w, format, jsonBackupResult{
+		jsonHeader:   newHeader("backup_result"),
+		CopiedFiles:  result.CopiedFiles,
+		SkippedFiles: result.SkippedFiles,
+		CopiedBytes:  result.CopiedBytes,
+		MergedFiles:  result.MergedFiles,
+		RemovedFiles: result.RemovedFiles,
+		PendingFiles: result.PendingFiles,
+	})
+}
+
+func writeSyncJSON(w io.Writer, format outputFormat, result *engine.SyncResult) error {
+	return writeRecords(w, format, jsonSyncResult{
+		jsonHeader:   newHeader("sync_result"),
+		UpdatedFiles: result.UpdatedFiles,
+		UpdatedBytes: result.UpdatedBytes,
+		MergedFiles:  result.MergedFiles,
+		RemovedFiles: result.RemovedFiles,
+		SkippedFiles: result.SkippedFiles,
+		PendingFiles: result.PendingFiles,
+	})
+}
+
+func writeApplyJSON(w io.Writer, format outputFormat, result *engine.ApplyResult) error {
+	stale := result.StalePaths
+	if stale == nil {
+		stale = []string{}
+	}
+	return writeRecords(w, format, jsonApplyResult{
+		jsonHeader:   newHeader("apply_result"),
+		Direction:    string(result.Direction),
+		CopiedFiles:  result.CopiedFiles,
+		CopiedBytes:  result.CopiedBytes,
+		MergedFiles:  result.MergedFiles,
+		RemovedFiles: result.RemovedFiles,
+		SkippedFiles: result.SkippedFiles,
+		PendingFiles: result.PendingFiles,
+		StalePaths:   stale,
+	})
+}
+
+func writeResolveJSON(w io.Writer, format outputFormat, outcomes []resolveOutcome) error {
+	records := make([]jsonResolution, 0, len(outcomes))
+	for _, outcome := range outcomes {
+		records = append(records, jsonResolution{
+			jsonHeader: newHeader("resolution"),
+			Path:       outcome.Path,
+			Choice:     outcome.Choice,
+		})
+	}
+	if format == outputJSONL {
+		items := make([]any, 0, len(records))
+		for _, record := range records {
+			items = append(items, record)
+		}
+		return writeRecords(w, format, items...)
+	}
+	return writeRecords(w, format, struct {
+		jsonHeader
+		Resolutions []jsonResolution `json:"resolutions"`
+	}{jsonHeader: newHeader("resolve"), Resolutions: records})
+}
+
+func writeDiffsJSON(w io.Writer, format outputFormat, diffs []engine.FileDiff) error {
+	records := make([]jsonFileDiff, 0, len(diffs))
+	for _, fd := range diffs {
+		records = append(records, jsonFileDiff{
+			jsonHeader: newHeader("file_diff"),
+			jsonEntry:  toJSONEntry(fd.Entry),
+			OldSide:    fd.OldLabel,
+			NewSide:    fd.NewLabel,
+			Binary:     fd.Binary,
+			TooLarge:   fd.TooLarge,
+			Unified:    fd.Unified,
+		})
+	}
+	if format == outputJSONL {
+		items := make([]any, 0, len(records))
+		for _, record := range records {
+			items = append(items, record)
+		}
+		return writeRecords(w, format, items...)
+	}
+	return writeRecords(w, format, struct {
+		jsonHeader
+		Files []jsonFileDiff `json:"files"`
+	}{jsonHeader: newHeader("diff"), Files: records})
+}
+
+func writePlanJSON(w io.Writer, format outputFormat, plan *engine.Plan) error {
+	actions := make([]jsonAction, 0, len(plan.Actions))
+	for _, action := range plan.Actions {
+		actions = append(actions, jsonAction{
+			Kind:         string(action.Kind),
+			Path:         action.Path,
+			Status:       string(action.Status),
+			Source:       action.Source,
+			Target:       action.Target,
+			RenameFrom:   action.RenameFrom,
+			SourceHash:   action.SourceHash,
+			TargetHash:   action.TargetHash,
+			ConflictCopy: action.ConflictCopy,
+			BaseHash:     action.BaseHash,
+			ResultHash:   action.ResultHash,
+			Bytes:        action.Bytes,
+			Reason:       action.Reason,
+		})
+	}
+	summary := jsonPlanSummary{
+		Copy:   plan.Count(engine.ActionCopy),
+		Merge:  plan.Count(engine.ActionMerge),
+		Delete: plan.Count(engine.ActionDelete),
+		Skip:   plan.Count(engine.ActionSkip),
+	}
+
+	if format == outputJSONL {
+		records := make([]any, 0, len(actions)+1)
+		for _, action := range actions {
+			records = append(records, jsonPlanAction{jsonHeader: newHeader("plan_action"), Action: action})
+		}
+		records = append(records, jsonPlanTotals{
+			jsonHeader:  newHeader("plan_summary"),
+			Direction:   string(plan.Direction),
+			GeneratedAt: plan.GeneratedAt,
+			Summary:     summary,
+			Guard:       toJSONGuard(plan.Guard),
+		})
+		return writeRecords(w, format, records...)
+	}
+
+	return writeRecords(w, format, jsonPlan{
+		jsonHeader:  newHeader("plan"),
+		Direction:   string(plan.Direction),
+		GeneratedAt: plan.GeneratedAt,
+		Summary:     summary,
+		Guard:       toJSONGuard(plan.Guard),
+		Actions:     actions,
+	})
+}
+
+func toJSONGuard(triggers []engine.GuardTrigger) []jsonGuardTrigger {
+	converted := make([]jsonGuardTrigger, 0, len(triggers))
+	for _, trigger := range triggers {
+		converted = append(converted, jsonGuardTrigger{
+			Scope:   trigger.Scope,
+			Path:    trigger.Path,
+			Deletes: trigger.Deletes,
+			Files:   trigger.Files,
+			Reason:  trigger.Reason,
+		})
+	}
+	return converted
+}
+
+func writeErrorJSON(w io.Writer, format outputFormat, err error) error {
+	body := jsonErrorBody{
+		Code:    errorCode(err),
+		Message: err.Error(),
+	}
+	var guard *engine.DeletionGuardError
+	if errors.As(err, &guard) {
+		body.Guard = toJSONGuard(guard.Triggers)
+	}
+	var interrupted *engine.InterruptedRunError
+	if errors.As(err, &interrupted) {
+		run := toJSONInterruptedRun(interrupted.Run)
+		body.Interrupted = &run
+	}
+	return writeRecords(w, format, jsonError{
+		jsonHeader: newHeader("error"),
+		Error:      body,
+	})
+}
+
+func toJSONVersion(entry history.Entry) jsonVersion {
+	return jsonVersion{
+		Hash:      entry.Hash,
+		Size:      entry.Size,
+		Time:      entry.Time,
+		Host:      entry.Host,
+		Direction: entry.Direction,
+		Side:      entry.Side,
+		Reason:    entry.Reason,
+	}
+}
+
+func writeHistoryJSON(w io.Writer, format outputFormat, path string, versions []history.Entry) error {
+	if format == outputJSONL {
+		records := make([]any, 0, len(versions))
+		for _, version := range versions {
+			records = append(records, jsonHistoryVersion{
+				jsonHeader:  newHeader("history_version"),
+				Path:        path,
+				jsonVersion: toJSONVersion(version),
+			})
+		}
+		return writeRecords(w, format, records...)
+	}
+
+	items := make([]jsonVersion, 0, len(versions))
+	for _, version := range versions {
+		items = append(items, toJSONVersion(version))
+	}
+	return writeRecords(w, format, jsonHistory{jsonHeader: newHeader("history"), Path: path, Versions: items})
+}
+
+func writeRestoreJSON(w io.Writer, format outputFormat, result *engine.RestoreResult) error {
+	return writeRecords(w, format, jsonRestoreResult{
+		jsonHeader: newHeader("restore_result"),
+		Path:       result.Path,
+		Side:       string(result.Side),
+		Target:     result.Target,
+		Version:    toJSONVersion(result.Version),
+	})
+}
+
+func toJSONTrashItem(item trash.Item) jsonTrashItem {
+	return jsonTrashItem{
+		ID:        item.ID,
+		Path:      item.Key,
+		Original:  item.Original,
+		Side:      item.Side,
+		Direction: item.Direction,
+		Reason:    item.Reason,
+		Host:      item.Host,
+		DeletedAt: item.DeletedAt,
+		Hash:      item.Hash,
+		Size:      item.Size,
+	}
+}
+
+// writeTrashJSON writes items as one kind document (json) or as one
+// itemKind record per item (jsonl).
+func writeTrashJSON(w io.Writer, format outputFormat, kind, itemKind string, items []trash.Item) error {
+	if format == outputJSONL {
+		records := make([]any, 0, len(items))
+		for _, item := range items {
+			records = append(records, jsonTrashRecord{jsonHeader: newHeader(itemKind), jsonTrashItem: toJSONTrashItem(item)})
+		}
+		return writeRecords(w, format, records...)
+	}
+
+	converted := make([]jsonTrashItem, 0, len(items))
+	for _, item := range items {
+		converted = append(converted, toJSONTrashItem(item))
+	}
+	return writeRecords(w, format, jsonTrash{jsonHeader: newHeader(kind), Items: converted})
+}
+
+type jsonMachine struct {
+	ID       string    `json:"id"`
+	Host     string    `json:"host"`
+	LastSync time.Time `json:"last_sync"`
+	Files    int       `json:"files"`
+	Current  bool      `json:"current"`
+}
+
+type jsonMachineRecord struct {
+	jsonHeader
+	jsonMachine
+}
+
+type jsonMachines struct {
+	jsonHeader
+	Current  string        `json:"current"`
+	Machines []jsonMachine `json:"machines"`
+}
+
+// writeMachinesJSON writes machines as one document (json) or as one record
+// per machine (jsonl).
+func writeMachinesJSON(w io.Writer, format outputFormat, machines []state.MachineInfo, current string) error {
+	converted := make([]jsonMachine, 0, len(machines))
+	for _, machine := range machines {
+		converted = append(converted, jsonMachine{
+			ID:       machine.ID,
+			Host:     machine.Host,
+			LastSync: machine.LastSync,
+			Files:    machine.Files,
+			Current:  machine.ID == current,
+		})
+	}
+	if format == outputJSONL {
+		records := make([]any, 0, len(converted))
+		for _, machine := range converted {
+			records = append(records, jsonMachineRecord{jsonHeader: newHeader("machine"), jsonMachine: machine})
+		}
+		return writeRecords(w, format, records...)
+	}
+	return writeRecords(w, format, jsonMachines{jsonHeader: newHeader("machines"), Current: current, Machines: converted})
+}
+
+func toJSONInterruptedRun(run *engine.InterruptedRun) jsonInterruptedRun {
+	pending := run.Pending
+	if pending == nil {
+		pending = []string{}
+	}
+	return jsonInterruptedRun{
+		Direction: string(run.Direction),
+		Host:      run.Host,
+		StartedAt: run.StartedAt,
+		Actions:   run.Actions,
+		Completed: run.Completed,
+		Pending:   pending,
+	}
+}
+
+func writeRecoveryJSON(w io.Writer, format outputFormat, run *engine.InterruptedRun) error {
+	record := jsonRecovery{jsonHeader: newHeader("recovery_status")}
+	if run != nil {
+		converted := toJSONInterruptedRun(run)
+		record.Interrupted = &converted
+	}
+	return writeRecords(w, format, record)
+}
+
+func writeRecoverJSON(w io.Writer, format outputFormat, result *engine.RecoverResult) error {
+	reverted := result.Reverted
+	if reverted == nil {
+		reverted = []string{}
+	}
+	record := jsonRecoverResult{
+		jsonHeader: newHeader("recover_result"),
+		Mode:       string(result.Mode),
+		Run:        toJSONInterruptedRun(result.Run),
+		Reverted:   reverted,
+	}
+	if applied := result.Applied; applied != nil {
+		record.CopiedFiles = applied.CopiedFiles
+		record.CopiedBytes = applied.CopiedBytes
+		record.MergedFiles = applied.MergedFiles
+		record.RemovedFiles = applied.RemovedFiles
+		record.SkippedFiles = applied.SkippedFiles
+		record.PendingFiles = applied.PendingFiles
+	}
+	return writeRecords(w, format, record)
+}
+
+func toJSONEvent(event engine.Event) jsonEvent {
+	return jsonEvent{
+		jsonHeader: newHeader("event"),
+		Event:      string(event.Kind),
+		Time:       event.Time,
+		Side:       string(event.Side),
+		Direction:  string(event.Direction),
+		Path:       event.Path,
+		Action:     string(event.Action),
+		Bytes:      event.Bytes,
+		Cached:     event.Cached,
+		Done:       event.Done,
+		Total:      event.Total,
+		DoneBytes:  event.DoneBytes,
+		TotalBytes: event.TotalBytes,
+	}
+}
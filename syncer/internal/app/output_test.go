@@ -0,0 +1,168 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nir414/pc-setup/syncer/internal/config"
+	"github.com/nir414/pc-setup/syncer/internal/engine"
+	"github.com/nir414/pc-setup/syncer/internal/history"
+	"github.com/nir414/pc-setup/syncer/internal/state"
+	"github.com/nir414/pc-setup/syncer/internal/trash"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+func TestJSONGolden(t *testing.T) {
+	at := time.Date(2024, 3, 5, 9, 4, 5, 0, time.UTC)
+	file := &engine.FileInfo{Path: "APPDATA/app/a.ini", Size: 4, ModTime: at, Hash: "aa"}
+	entry := engine.DiffEntry{
+		Path:       "APPDATA/app/a.ini",
+		Status:     engine.DiffStatusSystemModified,
+		System:     file,
+		Repo:       &engine.FileInfo{Path: "APPDATA/app/a.ini", Size: 3, ModTime: at, Hash: "bb"},
+		BaseHash:   "bb",
+		SystemPath: "/sys/app/a.ini",
+		RepoPath:   "/repo/SyncData/APPDATA/app/a.ini",
+	}
+	report := &engine.StatusReport{
+		GeneratedAt: at,
+		Summary:     engine.StatusSummary{UpToDate: 2, NeedsBackup: 1},
+		Entries:     []engine.DiffEntry{entry},
+		Profile:     &config.Profile{Name: "laptop", Disabled: []string{"LOCALAPPDATA"}, Suppressed: []string{"APPDATA/cache"}},
+		Collisions:  []engine.CaseCollision{{Side: engine.SideSystem, Paths: []string{"APPDATA/app/A.ini", "APPDATA/app/a.ini"}}},
+		PolicySkips: []engine.PolicySkip{{Path: "APPDATA/app/big.bin", Side: engine.SideSystem, Rule: "max_file_size = 1MB", Size: 2 << 20}},
+	}
+	guard := []engine.GuardTrigger{{Scope: "folder", Path: "APPDATA/app", Deletes: 3, Files: 4, Reason: "75% of files"}}
+	plan := &engine.Plan{
+		Direction:   engine.DirectionBackup,
+		GeneratedAt: at,
+		Actions: []engine.Action{
+			{Kind: engine.ActionCopy, Path: entry.Path, Status: entry.Status, Source: entry.SystemPath, Target: entry.RepoPath,
+				SourceHash: "aa", TargetHash: "bb", Bytes: 4, Reason: "system copy changed"},
+			{Kind: engine.ActionSkip, Path: "APPDATA/app/b.ini", Status: engine.DiffStatusConflict, Reason: "both sides changed"},
+		},
+		Guard: guard,
+	}
+	run := &engine.InterruptedRun{Direction: engine.DirectionBackup, Host: "desk", StartedAt: at, Actions: 2, Completed: 1,
+		Pending: []string{"APPDATA/app/b.ini"}}
+	version := history.Entry{Key: entry.Path, Hash: "bb", Size: 3, Time: at, Host: "desk", Direction: "backup", Side: "repo", Reason: "overwritten"}
+	item := trash.Item{ID: "20240305/1", Key: entry.Path, Original: entry.RepoPath, Side: "repo", Direction: "backup",
+		Reason: "deleted", Host: "desk", DeletedAt: at, Hash: "bb", Size: 3, ModTime: at}
+
+	tests := []struct {
+		name  string
+		write func(w io.Writer, format outputFormat) error
+	}{
+		{"status", func(w io.Writer, f outputFormat) error { return writeStatusJSON(w, f, report) }},
+		{"diff", func(w io.Writer, f outputFormat) error {
+			return writeDiffsJSON(w, f, []engine.FileDiff{{Entry: entry, OldLabel: "repo", NewLabel: "system",
+				Unified: "--- repo\n+++ system\n@@ -1 +1 @@\n-k=1\n+k=2\n"}})
+		}},
+		{"plan", func(w io.Writer, f outputFormat) error { return writePlanJSON(w, f, plan) }},
+		{"backup", func(w io.Writer, f outputFormat) error {
+			return writeBackupJSON(w, f, &engine.BackupResult{CopiedFiles: 1, CopiedBytes: 4, SkippedFiles: 1, PendingFiles: 1})
+		}},
+		{"sync", func(w io.Writer, f outputFormat) error {
+			return writeSyncJSON(w, f, &engine.SyncResult{UpdatedFiles: 1, UpdatedBytes: 3, RemovedFiles: 1})
+		}},
+		{"apply", func(w io.Writer, f outputFormat) error {
+			return writeApplyJSON(w, f, &engine.ApplyResult{Direction: engine.DirectionBackup, CopiedFiles: 1, CopiedBytes: 4,
+				StalePaths: []string{"APPDATA/app/b.ini"}})
+		}},
+		{"resolve", func(w io.Writer, f outputFormat) error {
+			return writeResolveJSON(w, f, []resolveOutcome{{Path: entry.Path, Choice: "system"}})
+		}},
+		{"history", func(w io.Writer, f outputFormat) error {
+			return writeHistoryJSON(w, f, entry.Path, []history.Entry{version})
+		}},
+		{"restore", func(w io.Writer, f outputFormat) error {
+			return writeRestoreJSON(w, f, &engine.RestoreResult{Path: entry.Path, Version: version, Side: engine.ResolveRepo, Target: entry.RepoPath})
+		}},
+		{"trash", func(w io.Writer, f outputFormat) error {
+			return writeTrashJSON(w, f, "trash", "trash_item", []trash.Item{item})
+		}},
+		{"machines", func(w io.Writer, f outputFormat) error {
+			return writeMachinesJSON(w, f, []state.MachineInfo{{ID: "desk-1a2b", Host: "desk", LastSync: at, Files: 12}}, "desk-1a2b")
+		}},
+		{"recovery", func(w io.Writer, f outputFormat) error { return writeRecoveryJSON(w, f, run) }},
+		{"recover", func(w io.Writer, f outputFormat) error {
+			return writeRecoverJSON(w, f, &engine.RecoverResult{Mode: engine.RecoverRollback, Run: run, Reverted: []string{entry.Path}})
+		}},
+		{"error", func(w io.Writer, f outputFormat) error {
+			return writeErrorJSON(w, f, &engine.DeletionGuardError{Triggers: guard})
+		}},
+	}
+	for _, tt := range tests {
+		for _, format := range []outputFormat{outputJSON, outputJSONL} {
+			name := tt.name + "." + string(format)
+			t.Run(name, func(t *testing.T) {
+				var buf bytes.Buffer
+				if err := tt.write(&buf, format); err != nil {
+					t.Fatal(err)
+				}
+				golden := filepath.Join("testdata", name)
+				if *update {
+					if err := os.MkdirAll("testdata", 0o755); err != nil {
+						t.Fatal(err)
+					}
+					if err := os.WriteFile(golden, buf.Bytes(), 0o644); err != nil {
+						t.Fatal(err)
+					}
+					return
+				}
+				want, err := os.ReadFile(golden)
+				if err != nil {
+					t.Fatalf("%v; run go test -update to create it", err)
+				}
+				if !bytes.Equal(buf.Bytes(), want) {
+					t.Errorf("output differs from %s:\n%s", golden, buf.String())
+				}
+			})
+		}
+	}
+}
+
+func TestErrorCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{usageErrorf("bad flag"), errCodeUsage},
+		{&configError{err: fs.ErrNotExist}, errCodeConfigNotFound},
+		{&configError{err: errors.New("bad toml")}, errCodeConfigInvalid},
+		{&engine.OpError{Op: engine.OpCopy, Err: errors.New("disk full")}, errCodeCopy},
+		{&engine.OpError{Op: engine.OpRemove, Err: fs.ErrPermission}, errCodeRemove},
+		{&engine.OpError{Op: engine.OpScan, Err: fs.ErrPermission}, errCodeScan},
+		{&engine.OpError{Op: engine.OpLoadSnapshot, Err: errors.New("bad json")}, errCodeSnapshot},
+		{&engine.OpError{Op: engine.OpSaveSnapshot, Err: errors.New("read-only")}, errCodeSnapshot},
+		{&engine.OpError{Op: engine.OpHistory, Err: errors.New("bad json")}, errCodeHistory},
+		{&engine.OpError{Op: engine.OpTrash, Err: errors.New("read-only")}, errCodeTrash},
+		{&engine.OpError{Op: engine.OpJournal, Err: errors.New("torn")}, errCodeJournal},
+		{&engine.OpError{Op: engine.OpCopy, Err: context.Canceled}, errCodeCanceled},
+		{&engine.DeletionGuardError{}, errCodeDeletionGuard},
+		{&engine.StalePlanError{Paths: []string{"APPDATA/app/a.ini"}}, errCodePlanStale},
+		{fmt.Errorf("apply: %w", &engine.StalePlanError{}), errCodePlanStale},
+		{&engine.InterruptedRunError{Run: &engine.InterruptedRun{}}, errCodeInterrupted},
+		{fmt.Errorf("read plan: %w", engine.ErrInvalidPlan), errCodePlanInvalid},
+		{fmt.Errorf("%w: APPDATA/x", engine.ErrUnknownPath), errCodeUnknownPath},
+		{engine.ErrVersionNotFound, errCodeNoVersion},
+		{trash.ErrNotFound, errCodeTrashNotFound},
+		{context.DeadlineExceeded, errCodeCanceled},
+		{errors.New("boom"), errCodeInternal},
+	}
+	for _, tt := range tests {
+		if got := errorCode(tt.err); got != tt.want {
+			t.Errorf("errorCode(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package app
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nir414/pc-setup/syncer/internal/engine"
+)
+
+const (
+	progressBarWidth = 30
+	progressInterval = 100 * time.Millisecond
+)
+
+// Values accepted by --events.
+const (
+	eventsAuto     = ""
+	eventsJSONL    = "jsonl"
+	eventsProgress = "progress"
+	eventsNone     = "none"
+)
+
+// newObserver returns the observer selected by --events, or nil. Without the
+// option a progress bar is drawn when stderr is a terminal.
+func newObserver(mode string) engine.Observer {
+	switch mode {
+	case eventsJSONL:
+		return &eventStream{w: os.Stderr}
+	case eventsProgress:
+		return newProgressBar(os.Stderr)
+	case eventsAuto:
+		if isTerminal(os.Stderr) {
+			return newProgressBar(os.Stderr)
+		}
+	}
+	return nil
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// eventStream writes every event as one JSON line.
+type eventStream struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *eventStream) Observe(event engine.Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	writeRecords(s.w, outputJSONL, toJSONEvent(event))
+}
+
+// progressBar renders scans and runs as a single, redrawn status line.
+type progressBar struct {
+	mu    sync.Mutex
+	w     io.Writer
+	scans map[engine.Side]engine.Event
+	drawn time.Time
+	shown bool
+}
+
+func newProgressBar(w io.Writer) *progressBar {
+	return &progressBar{w: w, scans: make(map[engine.Side]engine.Event)}
+}
+
+func (p *progressBar) Observe(event engine.Event) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch event.Kind {
+	case engine.EventScanStarted, engine.EventFileHashed:
+		p.scans[event.Side] = event
+		var done, total int
+		for _, scan := range p.scans {
+			done += scan.Done
+			total += scan.Total
+		}
+		p.draw(fmt.Sprintf("Scanning %d/%d files", done, total), done, total)
+	case engine.EventScanFinished:
+		p.scans[event.Side] = event
+		for _, scan := range p.scans {
+			if scan.Kind != engine.EventScanFinished {
+				return
+			}
+		}
+		clear(p.scans)
+		p.clear()
+	case engine.EventRunStarted, engine.EventFileCopied, engine.EventFileMerged,
+		engine.EventFileRemoved, engine.EventFileSkipped:
+		label := "Syncing"
+		if event.Direction == engine.DirectionBackup {
+			label = "Backing up"
+		}
+		p.draw(fmt.Sprintf("%s %d/%d files, %.1f/%.1f MiB", label, event.Done, event.Total,
+			float64(event.DoneBytes)/1024/1024, float64(event.TotalBytes)/1024/1024), event.Done, event.Total)
+	case engine.EventRunFinished:
+		p.clear()
+	}
+}
+
+// Close removes the status line, e.g. when a command fails mid-run.
+func (p *progressBar) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clear()
+}
+
+func (p *progressBar) draw(label string, done, total int) {
+	now := time.Now()
+	if done < total && now.Sub(p.drawn) < progressInterval {
+		return
+	}
+	p.drawn = now
+
+	filled := progressBarWidth
+	percent := 100
+	if total > 0 {
+		filled = progressBarWidth * done / total
+		percent = 100 * done / total
+	}
+	bar := strings.Repeat("#", filled) + strings.Repeat(".", progressBarWidth-filled)
+	fmt.Fprintf(p.w, "\r%-40s [%s] %3d%%\033[K", label, bar, percent)
+	p.shown = true
+}
+
+func (p *progressBar) clear() {
+	if p.shown {
+		fmt.Fprint(p.w, "\r\033[K")
+		p.shown = false
+	}
+}
@@ -0,0 +1,98 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/nir414/pc-setup/syncer/internal/engine"
+)
+
+func (a *App) runRecover(ctx context.Context, eng *engine.Engine, args []string, opts globalOptions) error {
+	flags := newCommandFlags("recover")
+	rollback := flags.Bool("--rollback")
+	finish := flags.Bool("--finish")
+	rest, err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 0 {
+		return usageErrorf("recover command does not accept additional arguments: %v", rest)
+	}
+	if *rollback && *finish {
+		return usageErrorf("recover: --rollback and --finish are mutually exclusive")
+	}
+
+	if !*rollback && !*finish {
+		run, err := eng.Interrupted()
+		if err != nil {
+			return err
+		}
+		if opts.Output != outputText {
+			return writeRecoveryJSON(os.Stdout, opts.Output, run)
+		}
+		if run == nil {
+			fmt.Println("No interrupted run to recover.")
+			return nil
+		}
+		printInterruptedRun(run)
+		fmt.Println("\nRun 'syncer recover --rollback' to undo it or 'syncer recover --finish' to complete it.")
+		return nil
+	}
+
+	mode := engine.RecoverRollback
+	if *finish {
+		mode = engine.RecoverFinish
+	}
+	result, err := eng.Recover(ctx, mode)
+	if err != nil {
+		return err
+	}
+	if result == nil {
+		if opts.Output != outputText {
+			return writeRecoveryJSON(os.Stdout, opts.Output, nil)
+		}
+		fmt.Println("No interrupted run to recover.")
+		return nil
+	}
+
+	if opts.Output != outputText {
+		return writeRecoverJSON(os.Stdout, opts.Output, result)
+	}
+
+	switch result.Mode {
+	case engine.RecoverRollback:
+		fmt.Printf("Rolled back interrupted %s run: %d file(s) reverted\n", result.Run.Direction, len(result.Reverted))
+	case engine.RecoverFinish:
+		applied := result.Applied
+		if applied == nil {
+			applied = &engine.ApplyResult{}
+		}
+		fmt.Printf("Finished interrupted %s run: %d files copied, %d merged, %d removals, %d skipped, %d pending, %.2f MiB moved\n",
+			result.Run.Direction,
+			applied.CopiedFiles,
+			applied.MergedFiles,
+			applied.RemovedFiles,
+			applied.SkippedFiles,
+			applied.PendingFiles,
+			float64(applied.CopiedBytes)/1024/1024,
+		)
+	}
+	for _, path := range result.Reverted {
+		fmt.Printf("  reverted: %s\n", path)
+	}
+	return nil
+}
+
+func printInterruptedRun(run *engine.InterruptedRun) {
+	fmt.Printf("Interrupted %s run started %s on %s: %d of %d action(s) completed\n",
+		run.Direction,
+		run.StartedAt.Local().Format("2006-01-02 15:04:05"),
+		run.Host,
+		run.Completed,
+		run.Actions,
+	)
+	for _, path := range run.Pending {
+		fmt.Printf("  interrupted: %s\n", path)
+	}
+}
@@ -0,0 +1,196 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/nir414/pc-setup/syncer/internal/engine"
+)
+
+const resolveSkip = "skip"
+
+type resolveOutcome struct {
+	Path   string
+	Choice string
+}
+
+func (a *App) runResolve(ctx context.Context, eng *engine.Engine, args []string, opts globalOptions) error {
+	flags := newCommandFlags("resolve")
+	take := flags.String("", "--take")
+	prefixes, err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	switch *take {
+	case "", string(engine.ResolveSystem), string(engine.ResolveRepo), resolveSkip:
+	default:
+		return usageErrorf("resolve: --take must be one of: system, repo, skip")
+	}
+	if *take == "" && opts.Output != outputText {
+		return usageErrorf("resolve: interactive mode requires text output; use --take")
+	}
+
+	conflicts, err := eng.Conflicts(ctx, prefixes)
+	if err != nil {
+		return err
+	}
+
+	var outcomes []resolveOutcome
+	if *take != "" {
+		for _, entry := range conflicts {
+			if *take != resolveSkip {
+				if err := eng.Resolve(ctx, entry, engine.Resolution(*take)); err != nil {
+					return err
+				}
+			}
+			outcomes = append(outcomes, resolveOutcome{Path: entry.Path, Choice: *take})
+		}
+	} else {
+		outcomes, err = resolveInteractively(ctx, eng, conflicts, os.Stdin)
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.Output != outputText {
+		return writeResolveJSON(os.Stdout, opts.Output, outcomes)
+	}
+	if len(conflicts) == 0 {
+		fmt.Println("No conflicts.")
+		return nil
+	}
+	fmt.Println("\nResolutions:")
+	for _, outcome := range outcomes {
+		fmt.Printf("  %-6s %s\n", outcome.Choice, outcome.Path)
+	}
+	return nil
+}
+
+func resolveInteractively(ctx context.Context, eng *engine.Engine, conflicts []engine.DiffEntry, in io.Reader) ([]resolveOutcome, error) {
+	reader := bufio.NewReader(in)
+	var outcomes []resolveOutcome
+
+	for i, entry := range conflicts {
+		fd, err := eng.ConflictDiff(entry)
+		if err != nil {
+			return outcomes, fmt.Errorf("diff %s: %w", entry.Path, err)
+		}
+		fmt.Printf("\nConflict %d/%d: %s\n", i+1, len(conflicts), entry.Path)
+		printDiffs([]engine.FileDiff{fd})
+		merged, regions, canMerge := eng.MergePreview(entry)
+		if canMerge {
+			fmt.Printf("Three-way merge left %d conflicting region(s); [e]dit starts from the merge result.\n", regions)
+		}
+
+	prompt:
+		for {
+			fmt.Print("Take [s]ystem, [r]epo, s[k]ip, [e]dit, [q]uit? ")
+			line, err := reader.ReadString('\n')
+			if err != nil && line == "" {
+				if err == io.EOF {
+					return outcomes, nil
+				}
+				return outcomes, err
+			}
+
+			switch strings.ToLower(strings.TrimSpace(line)) {
+			case "s", "system":
+				if err := eng.Resolve(ctx, entry, engine.ResolveSystem); err != nil {
+					return outcomes, err
+				}
+				outcomes = append(outcomes, resolveOutcome{Path: entry.Path, Choice: string(engine.ResolveSystem)})
+				break prompt
+			case "r", "repo":
+				if err := eng.Resolve(ctx, entry, engine.ResolveRepo); err != nil {
+					return outcomes, err
+				}
+				outcomes = append(outcomes, resolveOutcome{Path: entry.Path, Choice: string(engine.ResolveRepo)})
+				break prompt
+			case "k", "skip":
+				outcomes = append(outcomes, resolveOutcome{Path: entry.Path, Choice: resolveSkip})
+				break prompt
+			case "e", "edit":
+				content, err := editConflict(entry, merged, canMerge)
+				if err != nil {
+					fmt.Printf("edit failed: %v\n", err)
+					continue
+				}
+				if err := eng.ResolveContent(ctx, entry, content); err != nil {
+					return outcomes, err
+				}
+				outcomes = append(outcomes, resolveOutcome{Path: entry.Path, Choice: "edit"})
+				break prompt
+			case "q", "quit":
+				return outcomes, nil
+			}
+		}
+	}
+	return outcomes, nil
+}
+
+// editConflict opens the user's editor on a scratch copy of the conflicted
+// file and returns the saved content. The copy is seeded with the merge
+// result when one is available, otherwise with the system version (or the
+// repository version when the system copy is missing).
+func editConflict(entry engine.DiffEntry, merged []byte, useMerged bool) ([]byte, error) {
+	seed := merged
+	if !useMerged {
+		for _, info := range []*engine.FileInfo{entry.System, entry.Repo} {
+			if info == nil {
+				continue
+			}
+			content, err := os.ReadFile(info.AbsPath)
+			if err != nil {
+				return nil, err
+			}
+			seed = content
+			break
+		}
+	}
+
+	tmp, err := os.CreateTemp("", "syncer-resolve-*-"+filepath.Base(filepath.FromSlash(entry.Path)))
+	if err != nil {
+		return nil, err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	if _, err := tmp.Write(seed); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+
+	editor := editorCommand()
+	cmd := exec.Command(editor[0], append(editor[1:], tmpPath)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("run editor %s: %w", editor[0], err)
+	}
+
+	return os.ReadFile(tmpPath)
+}
+
+func editorCommand() []string {
+	for _, name := range []string{"SYNCER_EDITOR", "VISUAL", "EDITOR"} {
+		if fields := strings.Fields(os.Getenv(name)); len(fields) > 0 {
+			return fields
+		}
+	}
+	if runtime.GOOS == "windows" {
+		return []string{"notepad"}
+	}
+	return []string{"vi"}
+}
@@ -0,0 +1,124 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nir414/pc-setup/syncer/internal/engine"
+	"github.com/nir414/pc-setup/syncer/internal/trash"
+)
+
+func (a *App) runTrash(ctx context.Context, eng *engine.Engine, args []string, opts globalOptions) error {
+	if len(args) == 0 {
+		return usageErrorf("trash command expects one of: list, restore, purge")
+	}
+
+	switch args[0] {
+	case "list":
+		if len(args) != 1 {
+			return usageErrorf("trash list does not accept additional arguments: %v", args[1:])
+		}
+		items, err := eng.TrashItems()
+		if err != nil {
+			return err
+		}
+		if opts.Output != outputText {
+			return writeTrashJSON(os.Stdout, opts.Output, "trash", "trash_item", items)
+		}
+		if len(items) == 0 {
+			fmt.Println("Trash is empty.")
+			return nil
+		}
+		fmt.Printf("Trash (%d item(s), oldest first):\n", len(items))
+		printTrashItems(items)
+		return nil
+
+	case "restore":
+		flags := newCommandFlags("trash restore")
+		force := flags.Bool("--force", "-f")
+		ids, err := flags.Parse(args[1:])
+		if err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return usageErrorf("trash restore expects at least one trash id")
+		}
+		var restored []trash.Item
+		for _, id := range ids {
+			item, err := eng.RestoreTrash(ctx, id, *force)
+			if err != nil {
+				return err
+			}
+			restored = append(restored, item)
+		}
+		if opts.Output != outputText {
+			return writeTrashJSON(os.Stdout, opts.Output, "trash_restore", "trash_restored", restored)
+		}
+		fmt.Printf("Restored %d item(s):\n", len(restored))
+		printTrashItems(restored)
+		return nil
+
+	case "purge":
+		flags := newCommandFlags("trash purge")
+		olderThan := flags.String("", "--older-than")
+		rest, err := flags.Parse(args[1:])
+		if err != nil {
+			return err
+		}
+		if len(rest) != 0 {
+			return usageErrorf("trash purge does not accept additional arguments: %v", rest)
+		}
+		if *olderThan == "" {
+			return usageErrorf("trash purge: --older-than <age> is required (e.g. 30d; 0d purges everything)")
+		}
+		age, err := parseAge(*olderThan)
+		if err != nil {
+			return err
+		}
+		purged, err := eng.PurgeTrash(ctx, time.Now().Add(-age))
+		if err != nil {
+			return err
+		}
+		if opts.Output != outputText {
+			return writeTrashJSON(os.Stdout, opts.Output, "trash_purge", "trash_purged", purged)
+		}
+		fmt.Printf("Purged %d item(s) older than %s.\n", len(purged), *olderThan)
+		return nil
+	}
+	return usageErrorf("unknown trash command %q; expected one of: list, restore, purge", args[0])
+}
+
+func printTrashItems(items []trash.Item) {
+	for _, item := range items {
+		fmt.Printf("  %-28s %s  %-6s %s (%s)\n",
+			item.ID,
+			item.DeletedAt.Local().Format("2006-01-02 15:04:05"),
+			item.Side,
+			item.Key,
+			item.Reason,
+		)
+	}
+}
+
+// parseAge accepts a number of days (30d) or weeks (2w) as well as any
+// duration understood by time.ParseDuration.
+func parseAge(value string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if number, ok := strings.CutSuffix(value, suffix); ok {
+			n, err := strconv.Atoi(number)
+			if err != nil || n < 0 {
+				break
+			}
+			return time.Duration(n) * unit, nil
+		}
+	}
+	age, err := time.ParseDuration(value)
+	if err != nil || age < 0 {
+		return 0, usageErrorf("invalid age %q; expected e.g. 30d, 2w or 12h", value)
+	}
+	return age, nil
+}
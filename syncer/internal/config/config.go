@@ -1,12 +1,158 @@
 package config
 
+import (
+	"runtime"
+	"strings"
+)
+
 // Config represents the schema of sync.toml.
 type Config struct {
 	SyncData map[string]Section `toml:"SyncData"`
+	// Machines holds per-machine profiles keyed by hostname.
+	Machines map[string]Machine `toml:"machines"`
 }
 
-// Section describes folders belonging to an environment root.
+// Section describes folders belonging to a system root. The root is the
+// value of the environment variable Env, or Path, which is absolute or starts
+// with ~ for the home directory; with neither, the section name is used as
+// the variable name. RepoDir names the section's directory under SyncData
+// and defaults to the section name.
 type Section struct {
-	Folders  []string `toml:"folders"`
+	Env     string `toml:"env"`
+	Path    string `toml:"path"`
+	RepoDir string `toml:"repo_dir"`
+	// Roots maps an operating system, named as in GOOS, to the root used
+	// there, e.g. "%APPDATA%" or "$XDG_CONFIG_HOME". It takes precedence over
+	// Env and Path on the systems it lists.
+	Roots map[string]string `toml:"roots"`
+	// FolderNames maps an operating system to the directories folders use
+	// there when they differ from the name listed in Folders, which stays
+	// the folder's name under SyncData.
+	FolderNames map[string]map[string]string `toml:"folder_names"`
+	Folders     []string                     `toml:"folders"`
+	// Excludes follow .gitignore syntax relative to the section root.
 	Excludes []string `toml:"excludes"`
+	// CaseInsensitive compares paths without regard to case. It defaults to
+	// true for Windows-style sections, see FoldsCase.
+	CaseInsensitive *bool `toml:"case_insensitive"`
+	// Includes maps a folder to the patterns, relative to the folder, that
+	// its files must match to be synced.
+	Includes   map[string][]string `toml:"includes"`
+	OnConflict ConflictStrategy    `toml:"on_conflict"`
+	// Merge maps glob patterns to the merge driver used for matching files.
+	Merge map[string]MergeDriver `toml:"merge"`
+	// MaxDeletes and MaxDeletePercent bound how many files a single run may
+	// delete per folder or section. Zero keeps the default and a negative
+	// value disables the limit.
+	MaxDeletes       int `toml:"max_deletes"`
+	MaxDeletePercent int `toml:"max_delete_percent"`
+	// MaxFileSize, when not zero, and SkipBinary leave larger and binary
+	// files out of the scan. Limits overrides them per folder.
+	MaxFileSize Size                  `toml:"max_file_size"`
+	SkipBinary  bool                  `toml:"skip_binary"`
+	Limits      map[string]FileLimits `toml:"limits"`
+}
+
+// ConflictStrategy selects how entries changed on both sides are reconciled.
+type ConflictStrategy string
+
+// Conflict strategies accepted by on_conflict.
+const (
+	ConflictSkip     ConflictStrategy = "skip"
+	ConflictSystem   ConflictStrategy = "system"
+	ConflictRepo     ConflictStrategy = "repo"
+	ConflictNewest   ConflictStrategy = "newest"
+	ConflictKeepBoth ConflictStrategy = "keep-both"
+)
+
+// Valid reports whether s is a known strategy. The empty value is valid and
+// means ConflictSkip.
+func (s ConflictStrategy) Valid() bool {
+	switch s {
+	case "", ConflictSkip, ConflictSystem, ConflictRepo, ConflictNewest, ConflictKeepBoth:
+		return true
+	}
+	return false
+}
+
+// MergeDriver names a format-aware merge implementation.
+type MergeDriver string
+
+// Merge drivers accepted in merge tables.
+const (
+	MergeText    MergeDriver = "text"
+	MergeINI     MergeDriver = "ini"
+	MergeJSON    MergeDriver = "json"
+	MergeXML     MergeDriver = "xml"
+	MergeLineSet MergeDriver = "lineset"
+	MergeNone    MergeDriver = "none"
+)
+
+// Valid reports whether d is a known merge driver.
+func (d MergeDriver) Valid() bool {
+	switch d {
+	case MergeText, MergeINI, MergeJSON, MergeXML, MergeLineSet, MergeNone:
+		return true
+	}
+	return false
+}
+
+// RootEnv returns the environment variable holding the root of the section
+// called name, or "" when it is located by Path.
+func (s Section) RootEnv(name string) string {
+	switch {
+	case s.Path != "":
+		return ""
+	case s.Env != "":
+		return s.Env
+	default:
+		return name
+	}
+}
+
+// RepoDirName returns the directory under SyncData that holds the section
+// called name.
+func (s Section) RepoDirName(name string) string {
+	if s.RepoDir != "" {
+		return s.RepoDir
+	}
+	return name
+}
+
+// FolderPath returns the directory, relative to the section root, that
+// folder uses on the operating system goos.
+func (s Section) FolderPath(goos, folder string) string {
+	want := trimFolder(folder)
+	for name, path := range s.FolderNames[goos] {
+		if trimFolder(name) == want {
+			return path
+		}
+	}
+	return folder
 }
+
+func trimFolder(folder string) string {
+	return strings.Trim(strings.ReplaceAll(strings.TrimSpace(folder), "\\", "/"), "/")
+}
+
+// FoldsCase reports whether paths in the section called name are compared
+// without regard to case. Unless case_insensitive says otherwise, that holds
+// for Windows-style sections: those with a Windows root in roots, a drive
+// path, or a Windows environment variable root, and for every section on
+// Windows.
+func (s Section) FoldsCase(name string) bool {
+	switch {
+	case s.CaseInsensitive != nil:
+		return *s.CaseInsensitive
+	case runtime.GOOS == "windows":
+		return true
+	case len(s.Roots) > 0:
+		return s.Roots["windows"] != ""
+	case s.Path != "":
+		return len(s.Path) >= 2 && s.Path[1] == ':'
+	}
+	return windowsEnv[strings.ToUpper(s.RootEnv(name))]
+}
+
+// windowsEnv lists the environment variables that only name Windows roots.
+var windowsEnv = map[string]bool{"APPDATA": true, "LOCALAPPDATA": true, "USERPROFILE": true}
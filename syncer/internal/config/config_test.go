@@ -0,0 +1,34 @@
+package config
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestFoldsCase(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("every section folds case on Windows")
+	}
+	no := false
+	tests := []struct {
+		name    string
+		section Section
+		want    bool
+	}{
+		{"APPDATA", Section{}, true},
+		{"roaming", Section{Env: "AppData"}, true},
+		{"profile", Section{Env: "USERPROFILE"}, true},
+		{"APPDATA", Section{CaseInsensitive: &no}, false},
+		{"HOME", Section{}, false},
+		{"config", Section{Env: "XDG_CONFIG_HOME"}, false},
+		{"vscode", Section{Roots: map[string]string{"windows": "%APPDATA%", "linux": "$XDG_CONFIG_HOME"}}, true},
+		{"vscode", Section{Roots: map[string]string{"linux": "$XDG_CONFIG_HOME"}}, false},
+		{"games", Section{Path: `D:\Games`}, true},
+		{"dotfiles", Section{Path: "~/.config"}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.section.FoldsCase(tt.name); got != tt.want {
+			t.Errorf("FoldsCase(%q) of %+v = %v, want %v", tt.name, tt.section, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Size is a file size in bytes. In sync.toml it is an integer or a string
+// such as "512KB", "10MB" or "1.5GB"; units are powers of 1024.
+type Size int64
+
+var sizeUnits = []struct {
+	suffix string
+	bytes  int64
+}{
+	{"GIB", 1 << 30}, {"MIB", 1 << 20}, {"KIB", 1 << 10},
+	{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+	{"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10},
+	{"B", 1},
+}
+
+// UnmarshalText parses a size written as a string.
+func (s *Size) UnmarshalText(text []byte) error {
+	value := strings.ToUpper(strings.TrimSpace(string(text)))
+	unit := int64(1)
+	for _, u := range sizeUnits {
+		if number, ok := strings.CutSuffix(value, u.suffix); ok {
+			value, unit = strings.TrimSpace(number), u.bytes
+			break
+		}
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || number < 0 {
+		return fmt.Errorf("invalid size %q", text)
+	}
+	*s = Size(number * float64(unit))
+	return nil
+}
+
+// String formats s with the largest unit that keeps it readable.
+func (s Size) String() string {
+	for _, u := range sizeUnits[3:6] {
+		if int64(s) >= u.bytes {
+			return strconv.FormatFloat(math.Round(float64(s)*10/float64(u.bytes))/10, 'f', -1, 64) + u.suffix
+		}
+	}
+	return strconv.FormatInt(int64(s), 10) + "B"
+}
+
+// FileLimits overrides the section's file limits for one folder. A nil field
+// keeps the section's setting; a MaxFileSize of zero lifts the size limit.
+type FileLimits struct {
+	MaxFileSize *Size `toml:"max_file_size"`
+	SkipBinary  *bool `toml:"skip_binary"`
+}
+
+// FolderLimits returns the largest file size, zero for no limit, and whether
+// binary files are skipped in folder.
+func (s Section) FolderLimits(folder string) (Size, bool) {
+	maxSize, skipBinary := s.MaxFileSize, s.SkipBinary
+	want := trimFolder(folder)
+	for name, limits := range s.Limits {
+		if trimFolder(name) != want {
+			continue
+		}
+		if limits.MaxFileSize != nil {
+			maxSize = *limits.MaxFileSize
+		}
+		if limits.SkipBinary != nil {
+			skipBinary = *limits.SkipBinary
+		}
+	}
+	return maxSize, skipBinary
+}
+
+func validateLimits(name string, section Section) error {
+	if section.MaxFileSize < 0 {
+		return fmt.Errorf("section %s: max_file_size must not be negative", name)
+	}
+	folders := folderSet(section.Folders)
+	for folder, limits := range section.Limits {
+		if !folders[trimFolder(folder)] {
+			return fmt.Errorf("section %s: limits: %q is not in folders", name, folder)
+		}
+		if limits.MaxFileSize != nil && *limits.MaxFileSize < 0 {
+			return fmt.Errorf("section %s: limits.%q: max_file_size must not be negative", name, folder)
+		}
+	}
+	return nil
+}
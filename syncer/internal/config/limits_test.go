@@ -0,0 +1,66 @@
+package config
+
+import "testing"
+
+func TestSizeUnmarshalText(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Size
+	}{
+		{"512", 512},
+		{"512B", 512},
+		{"10KB", 10 << 10},
+		{"10 kib", 10 << 10},
+		{"1.5GB", 3 << 29},
+		{"2M", 2 << 20},
+	}
+	for _, tt := range tests {
+		var got Size
+		if err := got.UnmarshalText([]byte(tt.in)); err != nil || got != tt.want {
+			t.Errorf("UnmarshalText(%q) = %d, %v; want %d", tt.in, got, err, tt.want)
+		}
+	}
+	for _, bad := range []string{"", "MB", "-1KB", "10XB"} {
+		var got Size
+		if err := got.UnmarshalText([]byte(bad)); err == nil {
+			t.Errorf("UnmarshalText(%q) = %d; want an error", bad, got)
+		}
+	}
+	if got := Size(3 << 29).String(); got != "1.5GB" {
+		t.Errorf("String() = %q; want 1.5GB", got)
+	}
+}
+
+func TestFolderLimits(t *testing.T) {
+	unlimited := Size(0)
+	keepBinary := false
+	section := Section{
+		Folders:     []string{"Code/User/", "Dumps", "Notes"},
+		MaxFileSize: 10 << 20,
+		SkipBinary:  true,
+		Limits: map[string]FileLimits{
+			"Code/User": {SkipBinary: &keepBinary},
+			"Dumps/":    {MaxFileSize: &unlimited},
+		},
+	}
+	tests := []struct {
+		folder     string
+		maxSize    Size
+		skipBinary bool
+	}{
+		{"Code/User/", 10 << 20, false},
+		{"Dumps", 0, true},
+		{"Notes", 10 << 20, true},
+	}
+	for _, tt := range tests {
+		maxSize, skipBinary := section.FolderLimits(tt.folder)
+		if maxSize != tt.maxSize || skipBinary != tt.skipBinary {
+			t.Errorf("FolderLimits(%q) = %v, %v; want %v, %v", tt.folder, maxSize, skipBinary, tt.maxSize, tt.skipBinary)
+		}
+	}
+
+	section.Limits["Other/"] = FileLimits{}
+	if err := validateLimits("APPDATA", section); err == nil {
+		t.Error("validateLimits accepted limits for a folder that is not synced")
+	}
+}
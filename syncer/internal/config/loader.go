@@ -3,6 +3,9 @@ package config
 import (
 	"fmt"
 	"os"
+	"path"
+	"sort"
+	"strings"
 
 	toml "github.com/pelletier/go-toml/v2"
 )
@@ -23,5 +26,166 @@ func Load(path string) (*Config, error) {
 		cfg.SyncData = map[string]Section{}
 	}
 
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
 	return &cfg, nil
 }
+
+func (c *Config) validate() error {
+	names := make([]string, 0, len(c.SyncData))
+	for name := range c.SyncData {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	repoDirs := make(map[string]string)
+	for _, name := range names {
+		section := c.SyncData[name]
+		if err := validateSection(name, section); err != nil {
+			return err
+		}
+		repoDir := section.RepoDirName(name)
+		if repoDir == "." || repoDir == ".." || strings.ContainsAny(repoDir, `/\:`) {
+			return fmt.Errorf("section %s: repo_dir %q must be a single directory name", name, repoDir)
+		}
+		if other, ok := repoDirs[strings.ToLower(repoDir)]; ok {
+			return fmt.Errorf("sections %s and %s share repo_dir %q", other, name, repoDir)
+		}
+		repoDirs[strings.ToLower(repoDir)] = name
+	}
+	return c.validateMachines()
+}
+
+// validateSection checks the settings of the section called name on their
+// own, as configured or with a machine profile applied.
+func validateSection(name string, section Section) error {
+	if section.Env != "" && section.Path != "" {
+		return fmt.Errorf("section %s: env and path are mutually exclusive", name)
+	}
+	if section.Path != "" && !isRootPath(section.Path) {
+		return fmt.Errorf("section %s: path %q must be absolute or start with ~", name, section.Path)
+	}
+	for _, folder := range section.Folders {
+		if !isRelativeFolder(folder) {
+			return fmt.Errorf("section %s: folders: %q must be a relative path inside the root", name, folder)
+		}
+	}
+	if err := validateRoots(name, section); err != nil {
+		return err
+	}
+	if !section.OnConflict.Valid() {
+		return fmt.Errorf("section %s: unsupported on_conflict %q", name, section.OnConflict)
+	}
+	if err := validatePatterns(name, section); err != nil {
+		return err
+	}
+	if err := validateLimits(name, section); err != nil {
+		return err
+	}
+	for pattern, driver := range section.Merge {
+		if !driver.Valid() {
+			return fmt.Errorf("section %s: unsupported merge driver %q for %q", name, driver, pattern)
+		}
+	}
+	if section.MaxDeletePercent > 100 {
+		return fmt.Errorf("section %s: max_delete_percent must not exceed 100", name)
+	}
+	return nil
+}
+
+// isRootPath reports whether path can locate a section root: a path starting
+// with ~, or one that is absolute on Windows or Unix. Whether it exists on
+// this machine is only checked when the section is scanned.
+func isRootPath(path string) bool {
+	switch {
+	case path == "~", strings.HasPrefix(path, "~/"), strings.HasPrefix(path, `~\`):
+		return true
+	case strings.HasPrefix(path, "/"), strings.HasPrefix(path, `\\`):
+		return true
+	case len(path) >= 3 && path[1] == ':' && (path[2] == '/' || path[2] == '\\'):
+		return true
+	}
+	return false
+}
+
+func validatePatterns(name string, section Section) error {
+	for _, pattern := range section.Excludes {
+		if !validPattern(pattern) {
+			return fmt.Errorf("section %s: excludes: malformed pattern %q", name, pattern)
+		}
+	}
+	folders := folderSet(section.Folders)
+	for folder, patterns := range section.Includes {
+		if !folders[trimFolder(folder)] {
+			return fmt.Errorf("section %s: includes: %q is not in folders", name, folder)
+		}
+		for _, pattern := range patterns {
+			if !validPattern(pattern) {
+				return fmt.Errorf("section %s: includes.%q: malformed pattern %q", name, folder, pattern)
+			}
+		}
+	}
+	return nil
+}
+
+func validPattern(pattern string) bool {
+	pattern = strings.ReplaceAll(strings.TrimPrefix(strings.TrimSpace(pattern), "!"), "\\", "/")
+	_, err := path.Match(pattern, "")
+	return err == nil
+}
+
+// knownOS lists the operating systems roots and folder_names may name.
+var knownOS = map[string]bool{
+	"windows": true,
+	"linux":   true,
+	"darwin":  true,
+	"freebsd": true,
+	"openbsd": true,
+	"netbsd":  true,
+}
+
+func validateRoots(name string, section Section) error {
+	for goos, root := range section.Roots {
+		if !knownOS[goos] {
+			return fmt.Errorf("section %s: roots: unknown operating system %q", name, goos)
+		}
+		if !isRootPath(root) && !strings.HasPrefix(root, "%") && !strings.HasPrefix(root, "$") {
+			return fmt.Errorf("section %s: roots.%s %q must be absolute or start with ~ or an environment variable", name, goos, root)
+		}
+	}
+
+	folders := make(map[string]bool, len(section.Folders))
+	for _, folder := range section.Folders {
+		folders[trimFolder(folder)] = true
+	}
+	for goos, names := range section.FolderNames {
+		if !knownOS[goos] {
+			return fmt.Errorf("section %s: folder_names: unknown operating system %q", name, goos)
+		}
+		for folder, path := range names {
+			if !folders[trimFolder(folder)] {
+				return fmt.Errorf("section %s: folder_names.%s: %q is not in folders", name, goos, folder)
+			}
+			if !isRelativeFolder(path) {
+				return fmt.Errorf("section %s: folder_names.%s: %q must be a relative path inside the root", name, goos, path)
+			}
+		}
+	}
+	return nil
+}
+
+// isRelativeFolder reports whether path names a directory below a root.
+func isRelativeFolder(path string) bool {
+	trimmed := trimFolder(path)
+	if trimmed == "" || isRootPath(path) || strings.ContainsAny(path[:1], `/\`) || strings.Contains(path, ":") {
+		return false
+	}
+	for _, part := range strings.Split(trimmed, "/") {
+		if part == ".." {
+			return false
+		}
+	}
+	return true
+}
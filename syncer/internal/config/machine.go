@@ -0,0 +1,187 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Machine adjusts the configuration on one PC. A profile is selected by the
+// hostname, compared case-insensitively, or explicitly with --machine.
+type Machine struct {
+	// Disable lists sections that are not synced on the machine.
+	Disable  []string                   `toml:"disable"`
+	SyncData map[string]SectionOverride `toml:"SyncData"`
+}
+
+// SectionOverride changes one section on a machine. Removed folders and
+// excludes must be listed in the section; an empty OnConflict keeps the
+// section's strategy.
+type SectionOverride struct {
+	AddFolders     []string         `toml:"add_folders"`
+	RemoveFolders  []string         `toml:"remove_folders"`
+	AddExcludes    []string         `toml:"add_excludes"`
+	RemoveExcludes []string         `toml:"remove_excludes"`
+	OnConflict     ConflictStrategy `toml:"on_conflict"`
+}
+
+// Profile describes the machine profile applied to a configuration.
+type Profile struct {
+	Name string
+	// Disabled lists the disabled sections and Suppressed the folders that
+	// are configured but not synced, as "<section>/<folder>", both sorted.
+	Disabled   []string
+	Suppressed []string
+}
+
+// MachineFor returns the name of the profile for host, if there is one.
+func (c *Config) MachineFor(host string) (string, bool) {
+	for name := range c.Machines {
+		if strings.EqualFold(name, host) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// ForMachine returns a copy of c with the profile called name applied. An
+// empty name returns c unchanged and a nil profile.
+func (c *Config) ForMachine(name string) (*Config, *Profile, error) {
+	if name == "" {
+		return c, nil, nil
+	}
+	machine, ok := c.Machines[name]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown machine profile %q", name)
+	}
+
+	profile := &Profile{Name: name}
+	out := &Config{SyncData: make(map[string]Section, len(c.SyncData)), Machines: c.Machines}
+	disabled := make(map[string]bool, len(machine.Disable))
+	for _, section := range machine.Disable {
+		disabled[section] = true
+	}
+	for sectionName, section := range c.SyncData {
+		if disabled[sectionName] {
+			profile.Disabled = append(profile.Disabled, sectionName)
+			for _, folder := range section.Folders {
+				profile.Suppressed = append(profile.Suppressed, sectionName+"/"+trimFolder(folder))
+			}
+			continue
+		}
+		override, ok := machine.SyncData[sectionName]
+		if !ok {
+			out.SyncData[sectionName] = section
+			continue
+		}
+
+		removed := folderSet(override.RemoveFolders)
+		var folders []string
+		for _, folder := range section.Folders {
+			if removed[trimFolder(folder)] {
+				profile.Suppressed = append(profile.Suppressed, sectionName+"/"+trimFolder(folder))
+				continue
+			}
+			folders = append(folders, folder)
+		}
+		section.Folders = append(folders, override.AddFolders...)
+		section.Includes = withoutFolders(section.Includes, removed)
+		section.Limits = withoutFolders(section.Limits, removed)
+		if len(removed) > 0 && section.FolderNames != nil {
+			names := make(map[string]map[string]string, len(section.FolderNames))
+			for goos, perOS := range section.FolderNames {
+				names[goos] = withoutFolders(perOS, removed)
+			}
+			section.FolderNames = names
+		}
+
+		var excludes []string
+		for _, exclude := range section.Excludes {
+			if !containsTrimmed(override.RemoveExcludes, exclude) {
+				excludes = append(excludes, exclude)
+			}
+		}
+		section.Excludes = append(excludes, override.AddExcludes...)
+
+		if override.OnConflict != "" {
+			section.OnConflict = override.OnConflict
+		}
+		if err := validateSection(sectionName, section); err != nil {
+			return nil, nil, fmt.Errorf("machine %s: %w", name, err)
+		}
+		out.SyncData[sectionName] = section
+	}
+	sort.Strings(profile.Disabled)
+	sort.Strings(profile.Suppressed)
+	return out, profile, nil
+}
+
+func (c *Config) validateMachines() error {
+	names := make(map[string]string, len(c.Machines))
+	for name, machine := range c.Machines {
+		if other, ok := names[strings.ToLower(name)]; ok {
+			return fmt.Errorf("machines %s and %s differ only in case", other, name)
+		}
+		names[strings.ToLower(name)] = name
+		for _, section := range machine.Disable {
+			if _, ok := c.SyncData[section]; !ok {
+				return fmt.Errorf("machine %s: disable: unknown section %s", name, section)
+			}
+		}
+		for sectionName, override := range machine.SyncData {
+			section, ok := c.SyncData[sectionName]
+			if !ok {
+				return fmt.Errorf("machine %s: unknown section %s", name, sectionName)
+			}
+			folders := folderSet(section.Folders)
+			for _, folder := range override.RemoveFolders {
+				if !folders[trimFolder(folder)] {
+					return fmt.Errorf("machine %s: section %s: remove_folders: %q is not in folders", name, sectionName, folder)
+				}
+			}
+			for _, exclude := range override.RemoveExcludes {
+				if !containsTrimmed(section.Excludes, exclude) {
+					return fmt.Errorf("machine %s: section %s: remove_excludes: %q is not in excludes", name, sectionName, exclude)
+				}
+			}
+			if override.OnConflict != "" && !override.OnConflict.Valid() {
+				return fmt.Errorf("machine %s: section %s: unsupported on_conflict %q", name, sectionName, override.OnConflict)
+			}
+		}
+		if _, _, err := c.ForMachine(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func folderSet(folders []string) map[string]bool {
+	set := make(map[string]bool, len(folders))
+	for _, folder := range folders {
+		set[trimFolder(folder)] = true
+	}
+	return set
+}
+
+// withoutFolders returns m without the entries of the removed folders.
+func withoutFolders[V any](m map[string]V, removed map[string]bool) map[string]V {
+	if len(removed) == 0 || m == nil {
+		return m
+	}
+	out := make(map[string]V, len(m))
+	for folder, value := range m {
+		if !removed[trimFolder(folder)] {
+			out[folder] = value
+		}
+	}
+	return out
+}
+
+func containsTrimmed(values []string, value string) bool {
+	for _, v := range values {
+		if strings.TrimSpace(v) == strings.TrimSpace(value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestForMachine(t *testing.T) {
+	cfg := &Config{
+		SyncData: map[string]Section{
+			"APPDATA": {
+				Folders:  []string{"FileZilla/", "lghub/", "Notepad++/"},
+				Excludes: []string{"*.log", "*/cache/"},
+			},
+			"LOCALAPPDATA": {Folders: []string{"PowerToys/"}},
+		},
+		Machines: map[string]Machine{
+			"WORK-PC": {
+				Disable: []string{"LOCALAPPDATA"},
+				SyncData: map[string]SectionOverride{
+					"APPDATA": {
+						AddFolders:     []string{"Slack/"},
+						RemoveFolders:  []string{"FileZilla"},
+						RemoveExcludes: []string{"*.log"},
+						OnConflict:     ConflictNewest,
+					},
+				},
+			},
+		},
+	}
+	if err := cfg.validate(); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+
+	name, ok := cfg.MachineFor("work-pc")
+	if !ok || name != "WORK-PC" {
+		t.Fatalf("MachineFor = %q, %v", name, ok)
+	}
+	out, profile, err := cfg.ForMachine(name)
+	if err != nil {
+		t.Fatalf("ForMachine: %v", err)
+	}
+
+	if _, ok := out.SyncData["LOCALAPPDATA"]; ok {
+		t.Fatal("disabled section still configured")
+	}
+	section := out.SyncData["APPDATA"]
+	if want := []string{"lghub/", "Notepad++/", "Slack/"}; !reflect.DeepEqual(section.Folders, want) {
+		t.Fatalf("folders = %v, want %v", section.Folders, want)
+	}
+	if want := []string{"*/cache/"}; !reflect.DeepEqual(section.Excludes, want) {
+		t.Fatalf("excludes = %v, want %v", section.Excludes, want)
+	}
+	if section.OnConflict != ConflictNewest {
+		t.Fatalf("on_conflict = %q", section.OnConflict)
+	}
+	if want := []string{"LOCALAPPDATA"}; !reflect.DeepEqual(profile.Disabled, want) {
+		t.Fatalf("disabled = %v, want %v", profile.Disabled, want)
+	}
+	if want := []string{"APPDATA/FileZilla", "LOCALAPPDATA/PowerToys"}; !reflect.DeepEqual(profile.Suppressed, want) {
+		t.Fatalf("suppressed = %v, want %v", profile.Suppressed, want)
+	}
+	if got := cfg.SyncData["APPDATA"].Folders; len(got) != 3 {
+		t.Fatalf("base config modified: %v", got)
+	}
+
+	// A profile's changes get the checks of the section they change.
+	bad := map[string]SectionOverride{
+		"removing a folder that is not configured":   {RemoveFolders: []string{"Missing/"}},
+		"removing an exclude that is not configured": {RemoveExcludes: []string{"*.tmp"}},
+		"a malformed exclude":                        {AddExcludes: []string{"[cache"}},
+		"a folder outside the root":                  {AddFolders: []string{"../Secrets"}},
+		"an absolute folder":                         {AddFolders: []string{`C:\Secrets`}},
+	}
+	for what, override := range bad {
+		cfg.Machines["WORK-PC"].SyncData["APPDATA"] = override
+		if err := cfg.validate(); err == nil {
+			t.Errorf("validate accepted %s", what)
+		}
+	}
+}
+
+func TestForMachineRemovedFolderSettings(t *testing.T) {
+	cfg := &Config{
+		SyncData: map[string]Section{
+			"APPDATA": {
+				Folders:     []string{"Code/User/", "lghub/"},
+				Includes:    map[string][]string{"lghub/": {"settings.db"}},
+				FolderNames: map[string]map[string]string{"linux": {"Code/User": "Code - OSS/User"}},
+			},
+		},
+		Machines: map[string]Machine{
+			"WORK-PC": {SyncData: map[string]SectionOverride{
+				"APPDATA": {RemoveFolders: []string{"lghub", "Code/User"}},
+			}},
+		},
+	}
+	if err := cfg.validate(); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	out, _, err := cfg.ForMachine("WORK-PC")
+	if err != nil {
+		t.Fatalf("ForMachine: %v", err)
+	}
+	section := out.SyncData["APPDATA"]
+	if len(section.Includes) != 0 || len(section.FolderNames["linux"]) != 0 {
+		t.Fatalf("settings of removed folders kept: %+v", section)
+	}
+	if len(cfg.SyncData["APPDATA"].Includes) != 1 {
+		t.Fatal("base config modified")
+	}
+}
@@ -0,0 +1,149 @@
+package engine
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nir414/pc-setup/syncer/internal/config"
+	"github.com/nir414/pc-setup/syncer/internal/state"
+)
+
+// stubVerify makes fileHash verify cached hashes when verify is set, and
+// never otherwise, for the rest of the test.
+func stubVerify(t *testing.T, verify bool) {
+	t.Helper()
+	saved := verifyCached
+	verifyCached = func() bool { return verify }
+	t.Cleanup(func() { verifyCached = saved })
+}
+
+func TestCollectSystemFilesUsesSnapshotHashes(t *testing.T) {
+	stubVerify(t, false)
+	system := t.TempDir()
+	t.Setenv("APPDATA", system)
+	path := filepath.Join(system, "app", "a.ini")
+	writeFile(t, path, "a\n")
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := New(Options{Config: &config.Config{SyncData: map[string]config.Section{"APPDATA": {Folders: []string{"app"}}}}})
+	ctx := context.Background()
+	snapshot := &state.Snapshot{Files: map[string]state.FileRecord{
+		"APPDATA/app/a.ini": {Hash: "cached", Size: info.Size(), ModTime: info.ModTime().UTC()},
+	}}
+
+	// A record with the file's size and mtime is trusted without reading it.
+	files, _, err := e.collectSystemFiles(ctx, snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := files["APPDATA/app/a.ini"].Hash; got != "cached" {
+		t.Fatalf("hash = %q, want the cached one", got)
+	}
+
+	want, err := hashFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	later := info.ModTime().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	files, _, err = e.collectSystemFiles(ctx, snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := files["APPDATA/app/a.ini"].Hash; got != want {
+		t.Fatalf("hash after mtime change = %q, want %q", got, want)
+	}
+
+	writeFile(t, path, "a longer line\n")
+	snapshot.Files["APPDATA/app/a.ini"] = state.FileRecord{Hash: "cached", Size: 2, ModTime: info.ModTime().UTC()}
+	if err := os.Chtimes(path, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	files, _, err = e.collectSystemFiles(ctx, snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := files["APPDATA/app/a.ini"].Hash; got == "cached" {
+		t.Fatal("cached hash used after a size change")
+	}
+}
+
+func TestFileHash(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "a.ini")
+	writeFile(t, path, "a\n")
+	want, err := hashFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale := state.FileRecord{Hash: "stale"}
+	lookup := func(string, int64, time.Time) (state.FileRecord, bool) { return stale, true }
+	file := scannedFile{key: "S/a.ini", path: path}
+
+	t.Run("hit does not read the file", func(t *testing.T) {
+		stubVerify(t, false)
+		missing := scannedFile{key: "S/gone.ini", path: filepath.Join(t.TempDir(), "gone.ini")}
+		hash, cached, err := New(Options{}).fileHash(ctx, missing, stale, true)
+		if err != nil || !cached || hash != "stale" {
+			t.Fatalf("fileHash = %q, %v, %v; want the cached hash", hash, cached, err)
+		}
+	})
+
+	t.Run("rehash ignores the cache", func(t *testing.T) {
+		if _, ok := New(Options{Rehash: true}).cachedRecord(file, lookup); ok {
+			t.Fatal("cachedRecord returned a record with rehashing on")
+		}
+	})
+
+	t.Run("failed verification rehashes the rest", func(t *testing.T) {
+		e := New(Options{})
+		stubVerify(t, true)
+		hash, cached, err := e.fileHash(ctx, file, stale, true)
+		if err != nil || cached || hash != want {
+			t.Fatalf("verified fileHash = %q, %v, %v; want %q", hash, cached, err, want)
+		}
+		if _, ok := e.cachedRecord(file, lookup); ok {
+			t.Fatal("a failed verification did not turn on rehashing")
+		}
+	})
+}
+
+func TestSkipBinaryOnCachedFiles(t *testing.T) {
+	root := t.TempDir()
+	system := filepath.Join(root, "sys")
+	t.Setenv("APPDATA", system)
+	writeFile(t, filepath.Join(system, "app", "a.ini"), "a\n")
+	writeFile(t, filepath.Join(system, "app", "data.bin"), "\x00\x01")
+
+	engine := func(skipBinary bool) *Engine {
+		return New(Options{
+			Root:          root,
+			Config:        &config.Config{SyncData: map[string]config.Section{"APPDATA": {Folders: []string{"app"}, SkipBinary: skipBinary}}},
+			SnapshotStore: state.NewFileStore(filepath.Join(root, ".syncer", "state.json")),
+			RepoCache:     state.NewHashCache(filepath.Join(root, ".syncer", "repo-cache.json")),
+		})
+	}
+	ctx := context.Background()
+	if _, err := engine(false).Backup(ctx, RunOptions{}); err != nil {
+		t.Fatalf("Backup: %v", err)
+	}
+
+	// Both copies of data.bin have cached hashes now; turning skip_binary on
+	// must still leave them out without a rehash.
+	stubVerify(t, false)
+	report, err := engine(true).Status(ctx)
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	if len(report.PolicySkips) != 2 || report.PolicySkips[0].Path != "APPDATA/app/data.bin" || report.PolicySkips[1].Path != "APPDATA/app/data.bin" {
+		t.Fatalf("PolicySkips = %+v; want data.bin on both sides", report.PolicySkips)
+	}
+}
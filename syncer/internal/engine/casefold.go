@@ -0,0 +1,129 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/nir414/pc-setup/syncer/internal/state"
+)
+
+// CaseCollision lists files on one side whose paths differ only by case, in
+// a case-insensitive section.
+type CaseCollision struct {
+	Side  Side
+	Paths []string
+}
+
+// foldKey returns the key used to match key across the two sides and the
+// snapshot: key itself, or key with the path below the section folded to
+// lower case in case-insensitive sections.
+func (e *Engine) foldKey(key string) string {
+	name, rest, ok := strings.Cut(key, "/")
+	if !ok {
+		return key
+	}
+	if section := e.sectionFor(key); section != nil && section.FoldCase {
+		return name + "/" + strings.ToLower(rest)
+	}
+	return key
+}
+
+// foldFiles rekeys files by their folded keys. When several files fold to
+// the same key, the first in key order represents them and lists all of
+// them in Collides.
+func (e *Engine) foldFiles(files fileMap) fileMap {
+	keys := make([]string, 0, len(files))
+	for key := range files {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	folded := make(fileMap, len(files))
+	for _, key := range keys {
+		info := files[key]
+		fold := e.foldKey(key)
+		first, exists := folded[fold]
+		if !exists {
+			folded[fold] = info
+			continue
+		}
+		if len(first.Collides) == 0 {
+			first.Collides = []string{first.Path}
+		}
+		first.Collides = append(first.Collides, info.Path)
+	}
+	return folded
+}
+
+// collisions returns the case collisions among the entry's files.
+func (entry DiffEntry) collisions() []CaseCollision {
+	var out []CaseCollision
+	if entry.System != nil && len(entry.System.Collides) > 0 {
+		out = append(out, CaseCollision{Side: SideSystem, Paths: entry.System.Collides})
+	}
+	if entry.Repo != nil && len(entry.Repo.Collides) > 0 {
+		out = append(out, CaseCollision{Side: SideRepo, Paths: entry.Repo.Collides})
+	}
+	return out
+}
+
+// classifyRename settles an entry whose sides spell its path differently.
+// The side whose spelling no longer matches the snapshot key base was
+// renamed; without a base the system spelling is taken as the new one. An
+// otherwise identical file then needs the rename carried over, and a
+// rename on one side combined with a content change owned by the other side
+// is a conflict.
+func classifyRename(entry *DiffEntry, base string) {
+	if entry.System == nil || entry.Repo == nil || entry.System.Path == entry.Repo.Path {
+		return
+	}
+	entry.Renamed = SideSystem
+	if base == entry.System.Path {
+		entry.Renamed = SideRepo
+	}
+	if entry.Renamed == SideRepo {
+		entry.Path = entry.Repo.Path
+	} else {
+		entry.Path = entry.System.Path
+	}
+
+	switch entry.Status {
+	case DiffStatusUpToDate:
+		entry.Status = DiffStatusSystemModified
+		if entry.Renamed == SideRepo {
+			entry.Status = DiffStatusRepoModified
+		}
+	case DiffStatusSystemModified:
+		if entry.Renamed == SideRepo {
+			entry.Status = DiffStatusConflict
+		}
+	case DiffStatusRepoModified:
+		if entry.Renamed == SideSystem {
+			entry.Status = DiffStatusConflict
+		}
+	}
+}
+
+// pruneCaseVariants drops snapshot keys that differ from a key in keep only
+// by case, so a file renamed by case keeps a single base record.
+func (e *Engine) pruneCaseVariants(snapshot *state.Snapshot, keep map[string]bool) {
+	folded := make(map[string]bool, len(keep))
+	for key := range keep {
+		folded[e.foldKey(key)] = true
+	}
+	for key := range snapshot.Files {
+		if !keep[key] && folded[e.foldKey(key)] {
+			delete(snapshot.Files, key)
+		}
+	}
+}
+
+// renameCase renames the file at from to to, paths that differ only by case.
+func renameCase(from, to string) error {
+	if err := os.MkdirAll(filepath.Dir(to), 0o755); err != nil {
+		return err
+	}
+	return os.Rename(from, to)
+}
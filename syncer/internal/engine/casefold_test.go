@@ -0,0 +1,92 @@
+package engine
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/nir414/pc-setup/syncer/internal/config"
+	"github.com/nir414/pc-setup/syncer/internal/state"
+)
+
+func TestCaseOnlyRename(t *testing.T) {
+	root := t.TempDir()
+	system := filepath.Join(root, "sys")
+	t.Setenv("APPDATA", system)
+
+	exists := func(path string) bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}
+	repo := filepath.Join(root, "SyncData", "APPDATA")
+	writeFile(t, filepath.Join(system, "app", "Config.ini"), "a\n")
+
+	e := New(Options{
+		Root:          root,
+		Config:        &config.Config{SyncData: map[string]config.Section{"APPDATA": {Folders: []string{"app"}}}},
+		SnapshotStore: state.NewFileStore(filepath.Join(root, ".syncer", "state.json")),
+	})
+	ctx := context.Background()
+	if _, err := e.Backup(ctx, RunOptions{}); err != nil {
+		t.Fatalf("initial Backup: %v", err)
+	}
+
+	if err := os.Rename(filepath.Join(system, "app", "Config.ini"), filepath.Join(system, "app", "config.ini")); err != nil {
+		t.Fatal(err)
+	}
+	report, err := e.Status(ctx)
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	if len(report.Entries) != 1 {
+		t.Fatalf("Status entries = %+v; want the renamed file only", report.Entries)
+	}
+	entry := report.Entries[0]
+	if entry.Path != "APPDATA/app/config.ini" || entry.Status != DiffStatusSystemModified || entry.Renamed != SideSystem {
+		t.Fatalf("entry = %s %s renamed on %q; want APPDATA/app/config.ini system_modified renamed on system",
+			entry.Path, entry.Status, entry.Renamed)
+	}
+
+	if _, err := e.Backup(ctx, RunOptions{}); err != nil {
+		t.Fatalf("Backup: %v", err)
+	}
+	if !exists(filepath.Join(repo, "app", "config.ini")) || exists(filepath.Join(repo, "app", "Config.ini")) {
+		t.Fatal("Backup did not carry the rename into the repository")
+	}
+	if report, err = e.Status(ctx); err != nil || len(report.Entries) != 0 {
+		t.Fatalf("Status after Backup = %+v, %v; want up to date", report, err)
+	}
+
+	// Two spellings in the repository collide and are left alone.
+	writeFile(t, filepath.Join(repo, "app", "CONFIG.ini"), "b\n")
+	report, err = e.Status(ctx)
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	want := []CaseCollision{{Side: SideRepo, Paths: []string{"APPDATA/app/CONFIG.ini", "APPDATA/app/config.ini"}}}
+	if !reflect.DeepEqual(report.Collisions, want) {
+		t.Fatalf("Collisions = %+v; want %+v", report.Collisions, want)
+	}
+	plan, err := e.Plan(ctx, DirectionSync)
+	if err != nil {
+		t.Fatalf("Plan: %v", err)
+	}
+	for _, action := range plan.Actions {
+		if action.Kind != ActionSkip {
+			t.Fatalf("Plan action %s %s; want colliding files skipped", action.Kind, action.Path)
+		}
+	}
+}
+
+func TestCaseSensitiveSection(t *testing.T) {
+	t.Setenv("APPDATA", t.TempDir())
+	off := false
+	e := New(Options{Config: &config.Config{SyncData: map[string]config.Section{
+		"APPDATA": {Folders: []string{"app"}, CaseInsensitive: &off},
+	}}})
+	if got := e.foldKey("APPDATA/app/Config.ini"); got != "APPDATA/app/Config.ini" {
+		t.Fatalf("foldKey = %q; want the key unchanged", got)
+	}
+}
@@ -2,68 +2,152 @@ package engine
 
 import (
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
-	"io"
 	"io/fs"
+	"math/rand"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/nir414/pc-setup/syncer/internal/config"
+	"github.com/nir414/pc-setup/syncer/internal/objects"
 	"github.com/nir414/pc-setup/syncer/internal/state"
 )
 
 type fileMap map[string]*FileInfo
 
-func (e *Engine) collectSystemFiles(ctx context.Context) (fileMap, error) {
-	result := make(fileMap)
+// cacheVerifyOneIn is the share of cached hashes, one in this many, that are
+// recomputed anyway to catch files changed without a new size or mtime.
+const cacheVerifyOneIn = 50
+
+// verifyCached picks the cached hashes that are verified; tests replace it.
+var verifyCached = func() bool { return rand.Intn(cacheVerifyOneIn) == 0 }
+
+// hashLookup returns the cached record of key for a file with the given size
+// and modification time.
+type hashLookup func(key string, size int64, modTime time.Time) (state.FileRecord, bool)
+
+// scannedFile is a file found by a scan whose hash is not known yet.
+type scannedFile struct {
+	key        string
+	path       string
+	size       int64
+	modTime    time.Time
+	rule       string
+	skipBinary bool
+}
+
+// collectFiles scans both sides concurrently. The maps are keyed by folded
+// key, see foldKey, and leave out the keys of the files skipped by policy.
+func (e *Engine) collectFiles(ctx context.Context, snapshot *state.Snapshot) (fileMap, fileMap, []PolicySkip, error) {
+	var repoFiles fileMap
+	var repoSkipped []PolicySkip
+	var repoErr error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		repoFiles, repoSkipped, repoErr = e.collectRepoFiles(ctx)
+	}()
+
+	systemFiles, skipped, systemErr := e.collectSystemFiles(ctx, snapshot)
+	<-done
+	if systemErr != nil {
+		return nil, nil, nil, &OpError{Op: OpScan, Path: "system files", Err: systemErr}
+	}
+	if repoErr != nil {
+		return nil, nil, nil, &OpError{Op: OpScan, Path: "repo files", Err: repoErr}
+	}
+	systemFiles, repoFiles = e.foldFiles(systemFiles), e.foldFiles(repoFiles)
+	skipped = append(skipped, repoSkipped...)
+	e.dropPolicySkips(skipped, systemFiles, repoFiles)
+	return systemFiles, repoFiles, skipped, nil
+}
+
+// collectSystemFiles scans the system side, reusing the hashes recorded in
+// snapshot for files whose size and modification time are unchanged.
+func (e *Engine) collectSystemFiles(ctx context.Context, snapshot *state.Snapshot) (fileMap, []PolicySkip, error) {
+	lookup := func(key string, size int64, modTime time.Time) (state.FileRecord, bool) {
+		record, ok := snapshotLookup(snapshot, key)
+		if !ok || record.Size != size || !record.ModTime.Equal(modTime) {
+			return state.FileRecord{}, false
+		}
+		return record, true
+	}
+
+	var files []scannedFile
 	for _, section := range e.targets {
 		for _, folder := range section.Folders {
-			if err := e.collectFolder(ctx, section, folder, folder.SourcePath, result); err != nil {
-				return nil, err
+			found, err := collectFolder(ctx, section, folder, folder.SourcePath)
+			if err != nil {
+				return nil, nil, err
 			}
+			files = append(files, found...)
 		}
 	}
-	return result, nil
+	files, skipped := splitPolicySkips(files, SideSystem)
+	result, binary, err := e.hashFiles(ctx, SideSystem, files, lookup)
+	return result, append(skipped, binary...), err
 }
 
-func (e *Engine) collectRepoFiles(ctx context.Context) (fileMap, error) {
-	result := make(fileMap)
+// collectRepoFiles scans the repository side, reusing and refreshing the
+// repository hash cache.
+func (e *Engine) collectRepoFiles(ctx context.Context) (fileMap, []PolicySkip, error) {
+	var files []scannedFile
 	for _, section := range e.targets {
 		for _, folder := range section.Folders {
-			if err := e.collectFolder(ctx, section, folder, folder.DestPath, result); err != nil {
-				return nil, err
+			found, err := collectFolder(ctx, section, folder, folder.DestPath)
+			if err != nil {
+				return nil, nil, err
 			}
+			files = append(files, found...)
 		}
 	}
-	return result, nil
+	files, skipped := splitPolicySkips(files, SideRepo)
+	result, binary, err := e.hashFiles(ctx, SideRepo, files, e.repoCache.Lookup)
+	if err != nil {
+		return nil, nil, err
+	}
+	skipped = append(skipped, binary...)
+
+	if e.repoCache != nil {
+		for key, info := range result {
+			binary := info.binary
+			e.repoCache.Record(key, state.FileRecord{Hash: info.Hash, Size: info.Size, ModTime: info.ModTime, Binary: &binary})
+		}
+		if err := e.repoCache.Save(); err != nil {
+			e.logger.Printf("warning: save repository hash cache: %v", err)
+		}
+	}
+	return result, skipped, nil
 }
 
-func (e *Engine) collectFolder(ctx context.Context, section sectionSpec, folder folderSpec, base string, dest fileMap) error {
+// collectFolder walks one configured folder and lists the files it tracks,
+// in walk order, including those the folder's file limits leave out.
+func collectFolder(ctx context.Context, section sectionSpec, folder folderSpec, base string) ([]scannedFile, error) {
 	if base == "" {
-		return nil
+		return nil, nil
 	}
 
 	info, err := os.Stat(base)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return nil
+			return nil, nil
 		}
-		return err
+		return nil, err
 	}
 	if !info.IsDir() {
-		return nil
+		return nil, nil
 	}
 
-	return filepath.WalkDir(base, func(path string, d fs.DirEntry, err error) error {
+	var files []scannedFile
+	ignores := newIgnoreFiles(folder)
+	err = filepath.WalkDir(base, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
+		if err := ctx.Err(); err != nil {
+			return err
 		}
 
 		rel, relErr := filepath.Rel(base, path)
@@ -71,7 +155,12 @@ func (e *Engine) collectFolder(ctx context.Context, section sectionSpec, folder
 			return relErr
 		}
 		sectionRelative := combineSectionPath(folder.ConfigPath, rel)
-		if section.Matcher != nil && section.Matcher.ShouldSkip(sectionRelative, d.IsDir()) {
+		skip := section.Matcher.skipEntry
+		if path == base {
+			skip = section.Matcher.ShouldSkip
+		}
+		folderRelative := toForwardSlashes(rel)
+		if skip(sectionRelative, d.IsDir()) || path != base && ignores.skip(folderRelative, d.IsDir()) {
 			if d.IsDir() {
 				return filepath.SkipDir
 			}
@@ -79,7 +168,10 @@ func (e *Engine) collectFolder(ctx context.Context, section sectionSpec, folder
 		}
 
 		if d.IsDir() {
-			return nil
+			if path == base {
+				folderRelative = ""
+			}
+			return ignores.load(folderRelative)
 		}
 
 		if d.Type()&os.ModeSymlink != 0 {
@@ -91,38 +183,152 @@ func (e *Engine) collectFolder(ctx context.Context, section sectionSpec, folder
 			return statErr
 		}
 
-		hash, err := hashFile(path)
-		if err != nil {
-			return err
+		files = append(files, scannedFile{
+			key:        makeKey(section.Name, sectionRelative),
+			path:       path,
+			size:       fileInfo.Size(),
+			modTime:    fileInfo.ModTime().UTC(),
+			rule:       folder.sizeRule(fileInfo.Size()),
+			skipBinary: folder.SkipBinary,
+		})
+		return nil
+	})
+	return files, err
+}
+
+// hashFiles hashes files on the engine's worker pool and lists those
+// skip_binary leaves out instead.
+func (e *Engine) hashFiles(ctx context.Context, side Side, files []scannedFile, lookup hashLookup) (fileMap, []PolicySkip, error) {
+	hashes := make([]string, len(files))
+	binaries := make([]bool, len(files))
+	rules := make([]string, len(files))
+	errs := make([]error, len(files))
+
+	var totalBytes int64
+	for _, file := range files {
+		totalBytes += file.size
+	}
+	e.emit(Event{Kind: EventScanStarted, Side: side, Total: len(files), TotalBytes: totalBytes})
+
+	var progress sync.Mutex
+	var done int
+	var doneBytes int64
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(e.jobs, len(files)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				if errs[i] = ctx.Err(); errs[i] != nil {
+					continue
+				}
+				e.slots <- struct{}{}
+				record, cached := e.cachedRecord(files[i], lookup)
+				binaries[i], errs[i] = sniffBinary(files[i], record, cached)
+				if errs[i] == nil && binaries[i] && files[i].skipBinary {
+					rules[i] = "skip_binary"
+				}
+				if errs[i] == nil && rules[i] == "" {
+					hashes[i], cached, errs[i] = e.fileHash(ctx, files[i], record, cached)
+				}
+				<-e.slots
+				if errs[i] != nil {
+					continue
+				}
+
+				progress.Lock()
+				done++
+				doneBytes += files[i].size
+				if rules[i] == "" {
+					e.emit(Event{Kind: EventFileHashed, Side: side, Path: files[i].key, Bytes: files[i].size, Cached: cached,
+						Done: done, Total: len(files), DoneBytes: doneBytes, TotalBytes: totalBytes})
+				}
+				progress.Unlock()
+			}
+		}()
+	}
+	for i := range files {
+		if ctx.Err() != nil {
+			break
 		}
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	e.emit(Event{Kind: EventScanFinished, Side: side, Done: done, Total: len(files), DoneBytes: doneBytes, TotalBytes: totalBytes})
 
-		key := makeKey(section.Name, sectionRelative)
-		dest[key] = &FileInfo{
-			Path:    key,
-			AbsPath: path,
-			Size:    fileInfo.Size(),
-			ModTime: fileInfo.ModTime().UTC(),
-			Hash:    hash,
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	result := make(fileMap, len(files))
+	var skipped []PolicySkip
+	for i, file := range files {
+		if errs[i] != nil {
+			return nil, nil, errs[i]
 		}
-		return nil
-	})
+		if rules[i] != "" {
+			skipped = append(skipped, PolicySkip{Path: file.key, Side: side, Rule: rules[i], Size: file.size})
+			continue
+		}
+		result[file.key] = &FileInfo{
+			Path:    file.key,
+			AbsPath: file.path,
+			Size:    file.size,
+			ModTime: file.modTime,
+			Hash:    hashes[i],
+			binary:  binaries[i],
+		}
+	}
+	return result, skipped, nil
+}
+
+// cachedRecord returns the record lookup holds for file, unless every file
+// is rehashed.
+func (e *Engine) cachedRecord(file scannedFile, lookup hashLookup) (state.FileRecord, bool) {
+	if e.rehash.Load() || lookup == nil {
+		return state.FileRecord{}, false
+	}
+	return lookup(file.key, file.size, file.modTime)
 }
 
-func (e *Engine) collectSystemSnapshot(ctx context.Context) (*state.Snapshot, error) {
-	files, err := e.collectSystemFiles(ctx)
+// fileHash returns the hash of file and whether it was taken from the cached
+// record. A sample of cached hashes is verified; a mismatch forces a full
+// rehash.
+func (e *Engine) fileHash(ctx context.Context, file scannedFile, record state.FileRecord, cached bool) (string, bool, error) {
+	if cached && !verifyCached() {
+		return record.Hash, true, nil
+	}
+
+	hash, err := objects.HashFile(ctx, file.path)
 	if err != nil {
-		return nil, err
+		return "", false, err
 	}
-	snapshot := state.NewSnapshot()
-	for key, info := range files {
-		snapshot.Files[key] = state.FileRecord{
-			Hash:    info.Hash,
-			Size:    info.Size,
-			ModTime: info.ModTime,
-		}
+	if cached && hash != record.Hash && !e.rehash.Swap(true) {
+		e.logger.Printf("warning: cached hash of %s is out of date; rehashing every file", file.key)
+	}
+	return hash, false, nil
+}
+
+// maxBaseSize is the largest file whose base content is kept for merging.
+const maxBaseSize = 8 << 20
+
+// storeBase keeps the content of key's new base for merging, unless the file
+// could not be merged anyway.
+func (e *Engine) storeBase(key, path, hash string, size int64) {
+	if e.objects == nil || e.objects.Has(hash) {
+		return
+	}
+	if size > maxBaseSize || e.mergeDriverFor(key) == config.MergeNone {
+		return
+	}
+	if binary, _ := isBinaryFile(path); binary {
+		return
+	}
+	if _, err := e.objects.PutFile(path); err != nil {
+		e.logger.Printf("warning: store base content of %s: %v", key, err)
 	}
-	snapshot.GeneratedAt = time.Now().UTC()
-	return snapshot, nil
 }
 
 func combineSectionPath(folderConfig, rel string) string {
@@ -153,15 +359,5 @@ func makeKey(sectionName, sectionRelative string) string {
 }
 
 func hashFile(path string) (string, error) {
-	f, err := os.Open(path)
-	if err != nil {
-		return "", err
-	}
-	defer f.Close()
-
-	hasher := sha256.New()
-	if _, err := io.Copy(hasher, f); err != nil {
-		return "", err
-	}
-	return hex.EncodeToString(hasher.Sum(nil)), nil
+	return objects.HashFile(context.Background(), path)
 }
@@ -0,0 +1,140 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nir414/pc-setup/syncer/internal/state"
+)
+
+func TestHashFilesJobs(t *testing.T) {
+	dir := t.TempDir()
+	var files []scannedFile
+	for i := 0; i < 40; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("f%02d.txt", i))
+		if err := os.WriteFile(path, []byte(fmt.Sprintf("content %d\n", i%7)), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, scannedFile{key: fmt.Sprintf("S/f%02d.txt", i), path: path})
+	}
+
+	serial, _, err := New(Options{Jobs: 1}).hashFiles(context.Background(), SideSystem, files, nil)
+	if err != nil {
+		t.Fatalf("hashFiles with 1 job: %v", err)
+	}
+	parallel, _, err := New(Options{Jobs: 8}).hashFiles(context.Background(), SideSystem, files, nil)
+	if err != nil {
+		t.Fatalf("hashFiles with 8 jobs: %v", err)
+	}
+	if len(serial) != len(files) || len(parallel) != len(files) {
+		t.Fatalf("got %d and %d files, want %d", len(serial), len(parallel), len(files))
+	}
+	for key, info := range serial {
+		if parallel[key] == nil || parallel[key].Hash != info.Hash {
+			t.Fatalf("%s: hashes differ between 1 and 8 jobs", key)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := New(Options{Jobs: 4}).hashFiles(ctx, SideSystem, files, nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("hashFiles after cancel = %v, want context.Canceled", err)
+	}
+}
+
+func TestCollectFolderSyncIgnore(t *testing.T) {
+	dir := t.TempDir()
+	folder := folderSpec{
+		ConfigPath: "App",
+		SourcePath: filepath.Join(dir, "system", "App"),
+		DestPath:   filepath.Join(dir, "repo", "App"),
+	}
+	writeFile(t, filepath.Join(folder.SourcePath, ".syncignore"), "# scratch files\n*.tmp\n")
+	writeFile(t, filepath.Join(folder.SourcePath, "a.tmp"), "x")
+	writeFile(t, filepath.Join(folder.SourcePath, "settings.ini"), "x")
+	writeFile(t, filepath.Join(folder.SourcePath, "sub", "cache", "c.dat"), "x")
+	writeFile(t, filepath.Join(folder.SourcePath, "sub", "old.bak"), "x")
+	writeFile(t, filepath.Join(folder.SourcePath, "sub", "keep.txt"), "x")
+	writeFile(t, filepath.Join(folder.SourcePath, "sub", "keep.tmp"), "x")
+	writeFile(t, filepath.Join(folder.SourcePath, "other", "old.bak"), "x")
+	// Only the repository has sub's rules yet; the system scan applies them
+	// too, and they override the parent's.
+	writeFile(t, filepath.Join(folder.DestPath, "sub", ".syncignore"), "cache/\n/*.bak\n!keep.tmp\n")
+	// A directory named .syncignore holds no rules.
+	if err := os.MkdirAll(filepath.Join(folder.SourcePath, "other", ".syncignore"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	section := sectionSpec{Name: "S", Matcher: newMatcher(nil, nil)}
+	files, err := collectFolder(context.Background(), section, folder, folder.SourcePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, file := range files {
+		keys = append(keys, file.key)
+	}
+	want := []string{"S/App/.syncignore", "S/App/other/old.bak", "S/App/settings.ini", "S/App/sub/keep.tmp", "S/App/sub/keep.txt"}
+	if fmt.Sprint(keys) != fmt.Sprint(want) {
+		t.Fatalf("collected %v, want %v", keys, want)
+	}
+}
+
+func TestCollectFolderPolicy(t *testing.T) {
+	dir := t.TempDir()
+	folder := folderSpec{ConfigPath: "App", SourcePath: dir, MaxFileSize: 16, SkipBinary: true}
+	files := map[string]string{
+		"big.log":    "more than sixteen bytes\n",
+		"cache.db":   "SQLite\x00\x00",
+		"config.ini": "[app]\n",
+		"known.dat":  "\x00\x01",
+		"old.dat":    "\x00\x02",
+	}
+	for name, content := range files {
+		writeFile(t, filepath.Join(dir, name), content)
+	}
+
+	section := sectionSpec{Name: "S", Matcher: newMatcher(nil, nil)}
+	found, err := collectFolder(context.Background(), section, folder, folder.SourcePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept, skipped := splitPolicySkips(found, SideSystem)
+	want := []PolicySkip{{Path: "S/App/big.log", Side: SideSystem, Rule: "max_file_size = 16B", Size: 24}}
+	if fmt.Sprint(skipped) != fmt.Sprint(want) {
+		t.Fatalf("skipped %+v, want %+v", skipped, want)
+	}
+
+	// Binary files are found while hashing. A cached verdict is trusted and
+	// files cached without one are read to tell.
+	stubVerify(t, false)
+	text := false
+	lookup := func(key string, size int64, modTime time.Time) (state.FileRecord, bool) {
+		switch key {
+		case "S/App/known.dat":
+			return state.FileRecord{Hash: "cached", Binary: &text}, true
+		case "S/App/old.dat":
+			return state.FileRecord{Hash: "cached"}, true
+		}
+		return state.FileRecord{}, false
+	}
+	hashed, binary, err := New(Options{}).hashFiles(context.Background(), SideSystem, kept, lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hashed) != 2 || hashed["S/App/config.ini"] == nil || hashed["S/App/known.dat"] == nil {
+		t.Fatalf("hashed %v, want config.ini and the cached known.dat", hashed)
+	}
+	want = []PolicySkip{
+		{Path: "S/App/cache.db", Side: SideSystem, Rule: "skip_binary", Size: 8},
+		{Path: "S/App/old.dat", Side: SideSystem, Rule: "skip_binary", Size: 2},
+	}
+	if fmt.Sprint(binary) != fmt.Sprint(want) {
+		t.Fatalf("skipped %+v, want %+v", binary, want)
+	}
+}
@@ -0,0 +1,152 @@
+package engine
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nir414/pc-setup/syncer/internal/config"
+	"github.com/nir414/pc-setup/syncer/internal/state"
+)
+
+func TestConflictStrategies(t *testing.T) {
+	older := time.Now().Add(-time.Hour)
+	newer := time.Now()
+	tests := []struct {
+		name     string
+		strategy config.ConflictStrategy
+		dir      Direction
+		// systemNewer sets which side was modified last.
+		systemNewer bool
+		// system and repo are the contents each side should end with.
+		system, repo string
+		keptCopy     string
+	}{
+		{name: "skip leaves both sides", strategy: config.ConflictSkip, dir: DirectionBackup, systemNewer: true, system: "k=2\n", repo: "k=3\n"},
+		{name: "system wins a backup", strategy: config.ConflictSystem, dir: DirectionBackup, system: "k=2\n", repo: "k=2\n"},
+		{name: "repo waits for a sync", strategy: config.ConflictRepo, dir: DirectionBackup, system: "k=2\n", repo: "k=3\n"},
+		{name: "repo wins a sync", strategy: config.ConflictRepo, dir: DirectionSync, systemNewer: true, system: "k=3\n", repo: "k=3\n"},
+		{name: "newest system wins", strategy: config.ConflictNewest, dir: DirectionBackup, systemNewer: true, system: "k=2\n", repo: "k=2\n"},
+		{name: "newest repo wins", strategy: config.ConflictNewest, dir: DirectionSync, system: "k=3\n", repo: "k=3\n"},
+		{name: "keep-both keeps the repo copy", strategy: config.ConflictKeepBoth, dir: DirectionBackup, systemNewer: true, system: "k=2\n", repo: "k=2\n", keptCopy: "k=3\n"},
+		{name: "keep-both keeps the system copy", strategy: config.ConflictKeepBoth, dir: DirectionSync, system: "k=3\n", repo: "k=3\n", keptCopy: "k=2\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			system := filepath.Join(root, "sys")
+			t.Setenv("APPDATA", system)
+			repo := filepath.Join(root, "SyncData", "APPDATA")
+			systemFile := filepath.Join(system, "app", "a.ini")
+			repoFile := filepath.Join(repo, "app", "a.ini")
+			writeFile(t, systemFile, "k=1\n")
+
+			e := New(Options{
+				Root: root,
+				Config: &config.Config{SyncData: map[string]config.Section{
+					"APPDATA": {Folders: []string{"app"}, OnConflict: tt.strategy},
+				}},
+				SnapshotStore: state.NewFileStore(filepath.Join(root, ".syncer", "state.json")),
+			})
+			ctx := context.Background()
+			if _, err := e.Backup(ctx, RunOptions{}); err != nil {
+				t.Fatalf("first Backup: %v", err)
+			}
+			writeFile(t, systemFile, "k=2\n")
+			writeFile(t, repoFile, "k=3\n")
+			systemTime, repoTime := older, newer
+			if tt.systemNewer {
+				systemTime, repoTime = newer, older
+			}
+			chtimes(t, systemFile, systemTime)
+			chtimes(t, repoFile, repoTime)
+
+			var err error
+			if tt.dir == DirectionSync {
+				_, err = e.Sync(ctx, RunOptions{})
+			} else {
+				_, err = e.Backup(ctx, RunOptions{})
+			}
+			if err != nil {
+				t.Fatalf("%s: %v", tt.dir, err)
+			}
+			systemWant := map[string]string{"a.ini": tt.system}
+			repoWant := map[string]string{"a.ini": tt.repo}
+			target, kept := filepath.Join(repo, "app"), repoWant
+			if tt.dir == DirectionSync {
+				target, kept = filepath.Join(system, "app"), systemWant
+			}
+			copies, _ := filepath.Glob(filepath.Join(target, "a.ini.conflict-*"))
+			switch {
+			case tt.keptCopy == "" && len(copies) != 0:
+				t.Fatalf("conflict copies = %v, want none", copies)
+			case tt.keptCopy != "" && len(copies) != 1:
+				t.Fatalf("conflict copies = %v, want one", copies)
+			case tt.keptCopy != "":
+				kept[filepath.Base(copies[0])] = tt.keptCopy
+			}
+			checkFiles(t, filepath.Join(system, "app"), systemWant)
+			checkFiles(t, filepath.Join(repo, "app"), repoWant)
+		})
+	}
+}
+
+func TestKeepBothFoldedCase(t *testing.T) {
+	root := t.TempDir()
+	system := filepath.Join(root, "sys")
+	t.Setenv("APPDATA", system)
+	repo := filepath.Join(root, "SyncData", "APPDATA", "app")
+	writeFile(t, filepath.Join(system, "app", "Config.ini"), "k=1\n")
+
+	folds := true
+	e := New(Options{
+		Root: root,
+		Config: &config.Config{SyncData: map[string]config.Section{
+			"APPDATA": {Folders: []string{"app"}, OnConflict: config.ConflictKeepBoth, CaseInsensitive: &folds},
+		}},
+		SnapshotStore: state.NewFileStore(filepath.Join(root, ".syncer", "state.json")),
+	})
+	ctx := context.Background()
+	if _, err := e.Backup(ctx, RunOptions{}); err != nil {
+		t.Fatalf("first Backup: %v", err)
+	}
+	if err := os.Remove(filepath.Join(repo, "Config.ini")); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(repo, "config.ini"), "k=3\n")
+	chtimes(t, filepath.Join(repo, "config.ini"), time.Now().Add(-time.Hour))
+	writeFile(t, filepath.Join(system, "app", "Config.ini"), "k=2\n")
+
+	if _, err := e.Backup(ctx, RunOptions{}); err != nil {
+		t.Fatalf("Backup: %v", err)
+	}
+	copies, _ := filepath.Glob(filepath.Join(repo, "Config.ini.conflict-*"))
+	if len(copies) != 1 {
+		t.Fatalf("conflict copies = %v, want one in the system spelling", copies)
+	}
+	checkFiles(t, repo, map[string]string{
+		"Config.ini":             "k=2\n",
+		filepath.Base(copies[0]): "k=3\n",
+	})
+	if entries, err := os.ReadDir(repo); err != nil || len(entries) != 2 {
+		t.Fatalf("repo holds %v, %v; want the renamed file and its conflict copy", entries, err)
+	}
+}
+
+func TestConflictCopyPath(t *testing.T) {
+	at := time.Date(2024, 3, 5, 18, 4, 5, 0, time.FixedZone("KST", 9*60*60))
+	got := conflictCopyPath(filepath.Join("repo", "a.ini"), `my pc:1/x\y`, at)
+	want := filepath.Join("repo", "a.ini.conflict-my_pc_1_x_y-20240305T090405Z")
+	if got != want {
+		t.Fatalf("conflictCopyPath = %q, want %q", got, want)
+	}
+}
+
+func chtimes(t *testing.T, path string, at time.Time) {
+	t.Helper()
+	if err := os.Chtimes(path, at, at); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,139 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	diffContextLines = 3
+	binarySniffLen   = 8000
+	// maxDiffSize is the largest text file whose content is diffed.
+	maxDiffSize = 8 << 20
+)
+
+// FileDiff describes the content difference of a single entry. Old is the
+// side that would be overwritten if the entry were applied in the direction
+// implied by its status; conflicts are shown as a backup would apply them.
+type FileDiff struct {
+	Entry    DiffEntry
+	OldLabel string
+	NewLabel string
+	Old      *FileInfo
+	New      *FileInfo
+	Binary   bool
+	TooLarge bool
+	Unified  string
+}
+
+// Diff computes content differences for pending entries whose path matches
+// one of the given prefixes. An empty prefix list selects every entry.
+func (e *Engine) Diff(ctx context.Context, prefixes []string) ([]FileDiff, error) {
+	report, err := e.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	diffs := make([]FileDiff, 0, len(report.Entries))
+	for _, entry := range report.Entries {
+		if !matchesPrefix(entry.Path, prefixes) {
+			continue
+		}
+		fd, err := contentDiff(entry)
+		if err != nil {
+			return nil, fmt.Errorf("diff %s: %w", entry.Path, err)
+		}
+		diffs = append(diffs, fd)
+	}
+	return diffs, nil
+}
+
+func contentDiff(entry DiffEntry) (FileDiff, error) {
+	fd := FileDiff{Entry: entry}
+	switch entry.Status {
+	case DiffStatusRepoAdded, DiffStatusRepoModified, DiffStatusRepoDeleted:
+		fd.OldLabel, fd.Old = "system", entry.System
+		fd.NewLabel, fd.New = "repo", entry.Repo
+	default:
+		fd.OldLabel, fd.Old = "repo", entry.Repo
+		fd.NewLabel, fd.New = "system", entry.System
+	}
+
+	for _, info := range []*FileInfo{fd.Old, fd.New} {
+		if info == nil || info.AbsPath == "" {
+			continue
+		}
+		binary, err := isBinaryFile(info.AbsPath)
+		if err != nil {
+			return fd, err
+		}
+		if binary {
+			fd.Binary = true
+			return fd, nil
+		}
+		if info.Size > maxDiffSize {
+			fd.TooLarge = true
+		}
+	}
+	if fd.TooLarge {
+		return fd, nil
+	}
+
+	oldContent, err := readInfo(fd.Old)
+	if err != nil {
+		return fd, err
+	}
+	newContent, err := readInfo(fd.New)
+	if err != nil {
+		return fd, err
+	}
+
+	fd.Unified = unifiedDiff(
+		diffLabel(fd.OldLabel, fd.Old),
+		diffLabel(fd.NewLabel, fd.New),
+		splitLines(string(oldContent)),
+		splitLines(string(newContent)),
+		diffContextLines,
+	)
+	return fd, nil
+}
+
+func readInfo(info *FileInfo) ([]byte, error) {
+	if info == nil || info.AbsPath == "" {
+		return nil, nil
+	}
+	return os.ReadFile(info.AbsPath)
+}
+
+func diffLabel(side string, info *FileInfo) string {
+	if info == nil {
+		return "/dev/null"
+	}
+	return side + "/" + info.Path
+}
+
+// isBinary reports whether content looks like binary data, using the same
+// NUL byte heuristic as git.
+func isBinary(content []byte) bool {
+	if len(content) > binarySniffLen {
+		content = content[:binarySniffLen]
+	}
+	return bytes.IndexByte(content, 0) >= 0
+}
+
+// matchesPrefix reports whether key equals or lies below one of prefixes.
+func matchesPrefix(key string, prefixes []string) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range prefixes {
+		prefix = strings.Trim(toForwardSlashes(prefix), "/")
+		if prefix == "" || key == prefix || strings.HasPrefix(key, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,29 @@
+package engine
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestContentDiffLimits(t *testing.T) {
+	dir := t.TempDir()
+	info := func(name, content string) *FileInfo {
+		path := filepath.Join(dir, name)
+		writeFile(t, path, content)
+		return &FileInfo{Path: "APPDATA/" + name, AbsPath: path, Size: int64(len(content))}
+	}
+	old := info("old.txt", "a\n")
+
+	fd, err := contentDiff(DiffEntry{Status: DiffStatusSystemModified, Repo: old, System: info("dump.bin", "x\x00y")})
+	if err != nil || !fd.Binary || fd.Unified != "" {
+		t.Fatalf("binary diff = %+v, %v; want only marked binary", fd, err)
+	}
+
+	large := info("large.txt", strings.Repeat("line\n", maxDiffSize/5+1))
+	fd, err = contentDiff(DiffEntry{Status: DiffStatusSystemModified, Repo: old, System: large})
+	if err != nil || !fd.TooLarge || fd.Unified != "" {
+		t.Fatalf("large diff = %+v, %v; want only marked too large", fd, err)
+	}
+
+}
@@ -6,7 +6,9 @@ import (
 	"github.com/nir414/pc-setup/syncer/internal/state"
 )
 
-func buildDiff(systemFiles, repoFiles fileMap, snapshot *state.Snapshot) *diffResult {
+// buildDiff pairs the files of both sides, keyed by folded key, with their
+// snapshot records, looked up by the same folded key.
+func buildDiff(systemFiles, repoFiles fileMap, snapshot *state.Snapshot, fold func(string) string) *diffResult {
 	keys := make(map[string]struct{})
 	for key := range systemFiles {
 		keys[key] = struct{}{}
@@ -14,9 +16,14 @@ func buildDiff(systemFiles, repoFiles fileMap, snapshot *state.Snapshot) *diffRe
 	for key := range repoFiles {
 		keys[key] = struct{}{}
 	}
+	bases := make(map[string]string)
 	if snapshot != nil {
 		for key := range snapshot.Files {
-			keys[key] = struct{}{}
+			folded := fold(key)
+			if base, ok := bases[folded]; !ok || key < base {
+				bases[folded] = key
+			}
+			keys[folded] = struct{}{}
 		}
 	}
 
@@ -34,14 +41,24 @@ func buildDiff(systemFiles, repoFiles fileMap, snapshot *state.Snapshot) *diffRe
 		if sys == nil && repo == nil {
 			continue
 		}
-		prev, hasPrev := snapshotLookup(snapshot, key)
+		base := bases[key]
+		prev, hasPrev := snapshotLookup(snapshot, base)
 		status := classifyDifference(sys, repo, prev, hasPrev)
-		entries = append(entries, DiffEntry{
-			Path:   key,
+		entry := DiffEntry{
 			Status: status,
 			System: sys,
 			Repo:   repo,
-		})
+		}
+		if sys != nil {
+			entry.Path = sys.Path
+		} else {
+			entry.Path = repo.Path
+		}
+		if hasPrev {
+			entry.BaseHash = prev.Hash
+		}
+		classifyRename(&entry, base)
+		entries = append(entries, entry)
 	}
 
 	return &diffResult{Entries: entries}
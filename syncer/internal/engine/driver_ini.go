@@ -0,0 +1,183 @@
+package engine
+
+import "strings"
+
+// iniDriver merges INI files key by key. Sections and keys keep the layout
+// of the system file; keys and sections added only in the repo are inserted
+// after their nearest preceding neighbour. A key changed differently on both
+// sides is a conflict.
+type iniDriver struct{}
+
+type iniSection struct {
+	Name   string
+	Header string // empty for the lines before the first section header
+	Lines  []iniLine
+}
+
+type iniLine struct {
+	Key  string // empty for comments and blank lines
+	Text string
+}
+
+func (iniDriver) Merge(base, system, repo []byte) ([]byte, int, error) {
+	sectionID := func(s *iniSection) string { return s.Name }
+	b := indexBy(parseINI(base), sectionID)
+	s := indexBy(parseINI(system), sectionID)
+	r := indexBy(parseINI(repo), sectionID)
+
+	var lines []string
+	conflicts := 0
+	for _, id := range mergeOrder(s.IDs, r.IDs) {
+		section, n := mergeINISection(b.ByID[id], s.ByID[id], r.ByID[id])
+		conflicts += n
+		if section == nil {
+			continue
+		}
+		if section.Header != "" {
+			lines = append(lines, section.Header)
+		}
+		for _, line := range section.Lines {
+			lines = append(lines, line.Text)
+		}
+	}
+	return joinLines(lines, system), conflicts, nil
+}
+
+func parseINI(content []byte) []*iniSection {
+	current := &iniSection{}
+	sections := []*iniSection{current}
+	for _, raw := range splitLines(string(content)) {
+		line := trimNewline(raw)
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]"):
+			current = &iniSection{Name: strings.TrimSpace(trimmed[1 : len(trimmed)-1]), Header: line}
+			sections = append(sections, current)
+		case trimmed == "" || trimmed[0] == ';' || trimmed[0] == '#':
+			current.Lines = append(current.Lines, iniLine{Text: line})
+		default:
+			key, _, _ := strings.Cut(trimmed, "=")
+			current.Lines = append(current.Lines, iniLine{Key: strings.TrimSpace(key), Text: line})
+		}
+	}
+	return sections
+}
+
+func (s *iniSection) keys() []string {
+	var keys []string
+	for _, line := range s.Lines {
+		if line.Key != "" {
+			keys = append(keys, line.Key)
+		}
+	}
+	return keys
+}
+
+func (s *iniSection) values() keyed[string] {
+	if s == nil {
+		return keyed[string]{}
+	}
+	var texts []string
+	for _, line := range s.Lines {
+		if line.Key != "" {
+			texts = append(texts, line.Text)
+		}
+	}
+	index := keyed[string]{IDs: occurrenceKeys(s.keys()), ByID: make(map[string]string, len(texts))}
+	for i, id := range index.IDs {
+		index.ByID[id] = texts[i]
+	}
+	return index
+}
+
+func iniSectionsEqual(a, b *iniSection) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if a.Header != b.Header || len(a.Lines) != len(b.Lines) {
+		return false
+	}
+	for i := range a.Lines {
+		if a.Lines[i] != b.Lines[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func mergeINISection(base, system, repo *iniSection) (*iniSection, int) {
+	if picked, ok := pickChange(base, system, repo, iniSectionsEqual); ok {
+		return picked, 0
+	}
+	if system == nil || repo == nil {
+		return system, 1
+	}
+
+	b, s, r := base.values(), system.values(), repo.values()
+	inSystem := make(map[string]bool, len(s.IDs))
+	for _, id := range s.IDs {
+		inSystem[id] = true
+	}
+	// Keys only the repo has are emitted after the system key they follow.
+	var leading []string
+	following := make(map[string][]string)
+	previous := ""
+	for _, id := range mergeOrder(s.IDs, r.IDs) {
+		switch {
+		case inSystem[id]:
+			previous = id
+		case previous == "":
+			leading = append(leading, id)
+		default:
+			following[previous] = append(following[previous], id)
+		}
+	}
+
+	merged := &iniSection{Name: system.Name, Header: system.Header}
+	conflicts := 0
+	emit := func(id string) {
+		text, ok := pickChange(b.get(id), s.get(id), r.get(id), equalPtr[string])
+		if !ok {
+			conflicts++
+		}
+		if text != nil {
+			key := id[:strings.LastIndex(id, "#")]
+			merged.Lines = append(merged.Lines, iniLine{Key: key, Text: *text})
+		}
+	}
+
+	leadAt := len(system.Lines)
+	for leadAt > 0 && strings.TrimSpace(system.Lines[leadAt-1].Text) == "" {
+		leadAt--
+	}
+	for i, line := range system.Lines {
+		if line.Key != "" {
+			leadAt = min(leadAt, i)
+		}
+	}
+
+	keyIndex := 0
+	for i, line := range system.Lines {
+		if i == leadAt {
+			for _, id := range leading {
+				emit(id)
+			}
+		}
+		if line.Key == "" {
+			merged.Lines = append(merged.Lines, line)
+			continue
+		}
+		id := s.IDs[keyIndex]
+		keyIndex++
+		emit(id)
+		for _, added := range following[id] {
+			emit(added)
+		}
+	}
+	if leadAt == len(system.Lines) {
+		for _, id := range leading {
+			emit(id)
+		}
+	}
+	return merged, conflicts
+}
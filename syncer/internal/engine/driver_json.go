@@ -0,0 +1,226 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// jsonDriver merges JSON documents member by member. Objects are merged
+// recursively, while arrays and scalars are replaced as a whole. The result
+// is re-serialized with the indentation of the system file, so formatting
+// that differs from that style is normalized.
+type jsonDriver struct{}
+
+// jsonValue is a parsed JSON value that remembers object member order.
+type jsonValue struct {
+	Keys   []string
+	Fields map[string]*jsonValue // non-nil for objects
+	Items  []*jsonValue
+	Array  bool
+	Scalar string // encoded literal for strings, numbers, booleans and null
+
+	canonical string
+}
+
+type jsonStyle struct {
+	Indent  string // empty for compact output
+	Newline string
+}
+
+func (jsonDriver) Merge(base, system, repo []byte) ([]byte, int, error) {
+	var values [3]*jsonValue
+	for i, content := range [][]byte{base, system, repo} {
+		value, err := parseJSON(content)
+		if err != nil {
+			return nil, 0, err
+		}
+		values[i] = value
+	}
+
+	merged, conflicts := mergeJSONValue(values[0], values[1], values[2])
+	if merged == nil {
+		return nil, conflicts, nil
+	}
+
+	style := detectJSONStyle(system)
+	var buf strings.Builder
+	merged.write(&buf, style, 0)
+	if bytes.HasSuffix(bytes.TrimRight(system, " \t"), []byte("\n")) {
+		buf.WriteString(style.Newline)
+	}
+	return []byte(buf.String()), conflicts, nil
+}
+
+func parseJSON(content []byte) (*jsonValue, error) {
+	dec := json.NewDecoder(bytes.NewReader(content))
+	dec.UseNumber()
+	value, err := decodeJSONValue(dec)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("unexpected data after json value")
+	}
+	return value, nil
+}
+
+func decodeJSONValue(dec *json.Decoder) (*jsonValue, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch t := tok.(type) {
+	case json.Delim:
+		value := &jsonValue{}
+		if t == '{' {
+			value.Fields = make(map[string]*jsonValue)
+		} else {
+			value.Array = true
+		}
+		for dec.More() {
+			if value.Fields == nil {
+				item, err := decodeJSONValue(dec)
+				if err != nil {
+					return nil, err
+				}
+				value.Items = append(value.Items, item)
+				continue
+			}
+			keyTok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			key, _ := keyTok.(string)
+			field, err := decodeJSONValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			if _, exists := value.Fields[key]; !exists {
+				value.Keys = append(value.Keys, key)
+			}
+			value.Fields[key] = field
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		return value, nil
+	case json.Number:
+		return &jsonValue{Scalar: t.String()}, nil
+	case nil:
+		return &jsonValue{Scalar: "null"}, nil
+	default:
+		return &jsonValue{Scalar: encodeJSONString(t)}, nil
+	}
+}
+
+func encodeJSONString(value any) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(value); err != nil {
+		return fmt.Sprint(value)
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// detectJSONStyle picks compact output for single-line documents and
+// otherwise reuses the first indentation found.
+func detectJSONStyle(content []byte) jsonStyle {
+	style := jsonStyle{Newline: detectNewline(content)}
+	trimmed := bytes.TrimSpace(content)
+	if !bytes.Contains(trimmed, []byte("\n")) {
+		return style
+	}
+	style.Indent = "  "
+	for _, line := range strings.Split(string(trimmed), "\n")[1:] {
+		if indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]; indent != "" {
+			style.Indent = indent
+			break
+		}
+	}
+	return style
+}
+
+func jsonEqual(a, b *jsonValue) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.canonicalForm() == b.canonicalForm()
+}
+
+func (v *jsonValue) canonicalForm() string {
+	if v.canonical == "" {
+		var buf strings.Builder
+		v.write(&buf, jsonStyle{}, 0)
+		v.canonical = buf.String()
+	}
+	return v.canonical
+}
+
+func (v *jsonValue) write(buf *strings.Builder, style jsonStyle, depth int) {
+	if v.Fields == nil && !v.Array {
+		buf.WriteString(v.Scalar)
+		return
+	}
+
+	openDelim, closeDelim, count := "[", "]", len(v.Items)
+	if v.Fields != nil {
+		openDelim, closeDelim, count = "{", "}", len(v.Keys)
+	}
+	buf.WriteString(openDelim)
+	for i := 0; i < count; i++ {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if style.Indent != "" {
+			buf.WriteString(style.Newline)
+			buf.WriteString(strings.Repeat(style.Indent, depth+1))
+		}
+		if v.Array {
+			v.Items[i].write(buf, style, depth+1)
+			continue
+		}
+		key := v.Keys[i]
+		buf.WriteString(encodeJSONString(key))
+		buf.WriteByte(':')
+		if style.Indent != "" {
+			buf.WriteByte(' ')
+		}
+		v.Fields[key].write(buf, style, depth+1)
+	}
+	if style.Indent != "" && count > 0 {
+		buf.WriteString(style.Newline)
+		buf.WriteString(strings.Repeat(style.Indent, depth))
+	}
+	buf.WriteString(closeDelim)
+}
+
+func mergeJSONValue(base, system, repo *jsonValue) (*jsonValue, int) {
+	if picked, ok := pickChange(base, system, repo, jsonEqual); ok {
+		return picked, 0
+	}
+	if system == nil || repo == nil || system.Fields == nil || repo.Fields == nil || (base != nil && base.Fields == nil) {
+		return system, 1
+	}
+
+	merged := &jsonValue{Fields: make(map[string]*jsonValue)}
+	conflicts := 0
+	for _, key := range mergeOrder(system.Keys, repo.Keys) {
+		var baseField *jsonValue
+		if base != nil {
+			baseField = base.Fields[key]
+		}
+		field, n := mergeJSONValue(baseField, system.Fields[key], repo.Fields[key])
+		conflicts += n
+		if field != nil {
+			merged.Keys = append(merged.Keys, key)
+			merged.Fields[key] = field
+		}
+	}
+	return merged, conflicts
+}
@@ -0,0 +1,308 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// xmlDriver merges XML documents element by element. Child elements are
+// matched by tag name and their name or id attribute, falling back to their
+// position among equally named siblings; attributes are merged one by one.
+// The result is re-indented in the style of the system file.
+type xmlDriver struct{}
+
+type xmlKind int
+
+const (
+	xmlDocument xmlKind = iota
+	xmlElement
+	xmlText
+	xmlComment
+	xmlProcInst
+	xmlDirective
+)
+
+// xmlNode is a parsed XML node. Whitespace-only text is dropped on parse
+// and regenerated from the detected indentation on output.
+type xmlNode struct {
+	Kind     xmlKind
+	Name     string // element name or processing instruction target
+	Attrs    []xmlAttr
+	Children []*xmlNode
+	Text     string
+
+	canonical string
+}
+
+type xmlAttr struct {
+	Name  string
+	Value string
+}
+
+type xmlStyle struct {
+	Indent    string
+	Newline   string
+	SelfClose string // " />" or "/>"
+	CharRefs  bool   // write non-ASCII characters as numeric references
+}
+
+func (xmlDriver) Merge(base, system, repo []byte) ([]byte, int, error) {
+	var docs [3]*xmlNode
+	for i, content := range [][]byte{base, system, repo} {
+		doc, err := parseXML(content)
+		if err != nil {
+			return nil, 0, err
+		}
+		docs[i] = doc
+	}
+
+	merged, conflicts := mergeXMLNode(docs[0], docs[1], docs[2])
+	if merged == nil {
+		return nil, conflicts, nil
+	}
+
+	style := detectXMLStyle(system)
+	var buf strings.Builder
+	merged.write(&buf, style, 0)
+	content := buf.String()
+	if !bytes.HasSuffix(system, []byte("\n")) {
+		content = strings.TrimSuffix(content, style.Newline)
+	}
+	return []byte(content), conflicts, nil
+}
+
+func parseXML(content []byte) (*xmlNode, error) {
+	dec := xml.NewDecoder(bytes.NewReader(content))
+	doc := &xmlNode{Kind: xmlDocument}
+	stack := []*xmlNode{doc}
+	for {
+		tok, err := dec.RawToken()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		top := stack[len(stack)-1]
+		switch t := tok.(type) {
+		case xml.StartElement:
+			node := &xmlNode{Kind: xmlElement, Name: xmlName(t.Name)}
+			for _, attr := range t.Attr {
+				node.Attrs = append(node.Attrs, xmlAttr{Name: xmlName(attr.Name), Value: attr.Value})
+			}
+			top.Children = append(top.Children, node)
+			stack = append(stack, node)
+		case xml.EndElement:
+			if len(stack) == 1 || xmlName(t.Name) != top.Name {
+				return nil, fmt.Errorf("unexpected end element %s", xmlName(t.Name))
+			}
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if strings.TrimSpace(string(t)) != "" {
+				top.Children = append(top.Children, &xmlNode{Kind: xmlText, Text: string(t)})
+			}
+		case xml.Comment:
+			top.Children = append(top.Children, &xmlNode{Kind: xmlComment, Text: string(t)})
+		case xml.ProcInst:
+			top.Children = append(top.Children, &xmlNode{Kind: xmlProcInst, Name: t.Target, Text: string(t.Inst)})
+		case xml.Directive:
+			top.Children = append(top.Children, &xmlNode{Kind: xmlDirective, Text: string(t)})
+		}
+	}
+	if len(stack) != 1 {
+		return nil, fmt.Errorf("unclosed element %s", stack[len(stack)-1].Name)
+	}
+	return doc, nil
+}
+
+func xmlName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
+}
+
+func detectXMLStyle(content []byte) xmlStyle {
+	style := xmlStyle{Indent: "    ", Newline: detectNewline(content), SelfClose: " />"}
+	spaced := bytes.Count(content, []byte(" />"))
+	if bytes.Count(content, []byte("/>"))-spaced > spaced {
+		style.SelfClose = "/>"
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		if indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]; indent != "" && len(indent) < len(line) {
+			style.Indent = indent
+			break
+		}
+	}
+	hasNonASCII := false
+	for _, c := range content {
+		if c >= utf8.RuneSelf {
+			hasNonASCII = true
+			break
+		}
+	}
+	style.CharRefs = bytes.Contains(content, []byte("&#x")) && !hasNonASCII
+	return style
+}
+
+// identity is what a node is matched by across versions.
+func (n *xmlNode) identity() string {
+	switch n.Kind {
+	case xmlElement:
+		for _, attr := range n.Attrs {
+			if attr.Name == "name" || attr.Name == "id" {
+				return n.Name + "[" + attr.Name + "=" + attr.Value + "]"
+			}
+		}
+		return n.Name
+	case xmlText:
+		return "#text"
+	case xmlComment:
+		return "#comment"
+	case xmlProcInst:
+		return "?" + n.Name
+	}
+	return "!"
+}
+
+func xmlEqual(a, b *xmlNode) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.canonicalForm() == b.canonicalForm()
+}
+
+func (n *xmlNode) canonicalForm() string {
+	if n.canonical == "" {
+		var buf strings.Builder
+		n.write(&buf, xmlStyle{Newline: "\n", SelfClose: "/>"}, 0)
+		n.canonical = buf.String()
+	}
+	return n.canonical
+}
+
+func xmlChildren(n *xmlNode) keyed[*xmlNode] {
+	if n == nil {
+		return keyed[*xmlNode]{}
+	}
+	return indexBy(n.Children, (*xmlNode).identity)
+}
+
+func xmlAttrs(n *xmlNode) keyed[string] {
+	index := keyed[string]{ByID: make(map[string]string)}
+	if n == nil {
+		return index
+	}
+	for _, attr := range n.Attrs {
+		index.IDs = append(index.IDs, attr.Name)
+		index.ByID[attr.Name] = attr.Value
+	}
+	return index
+}
+
+func mergeXMLNode(base, system, repo *xmlNode) (*xmlNode, int) {
+	if picked, ok := pickChange(base, system, repo, xmlEqual); ok {
+		return picked, 0
+	}
+	if system == nil || repo == nil || system.Kind != repo.Kind || system.Name != repo.Name ||
+		(system.Kind != xmlElement && system.Kind != xmlDocument) ||
+		(base != nil && (base.Kind != system.Kind || base.Name != system.Name)) {
+		return system, 1
+	}
+
+	merged := &xmlNode{Kind: system.Kind, Name: system.Name}
+	conflicts := 0
+
+	b, s, r := xmlAttrs(base), xmlAttrs(system), xmlAttrs(repo)
+	for _, name := range mergeOrder(s.IDs, r.IDs) {
+		value, ok := pickChange(b.get(name), s.get(name), r.get(name), equalPtr[string])
+		if !ok {
+			conflicts++
+		}
+		if value != nil {
+			merged.Attrs = append(merged.Attrs, xmlAttr{Name: name, Value: *value})
+		}
+	}
+
+	bc, sc, rc := xmlChildren(base), xmlChildren(system), xmlChildren(repo)
+	for _, id := range mergeOrder(sc.IDs, rc.IDs) {
+		child, n := mergeXMLNode(bc.ByID[id], sc.ByID[id], rc.ByID[id])
+		conflicts += n
+		if child != nil {
+			merged.Children = append(merged.Children, child)
+		}
+	}
+	return merged, conflicts
+}
+
+func (n *xmlNode) write(buf *strings.Builder, style xmlStyle, depth int) {
+	pad := strings.Repeat(style.Indent, depth)
+	switch n.Kind {
+	case xmlDocument:
+		for _, child := range n.Children {
+			child.write(buf, style, 0)
+			buf.WriteString(style.Newline)
+		}
+	case xmlElement:
+		buf.WriteString(pad + "<" + n.Name)
+		for _, attr := range n.Attrs {
+			buf.WriteString(" " + attr.Name + `="` + escapeXML(attr.Value, true, style.CharRefs) + `"`)
+		}
+		switch {
+		case len(n.Children) == 0:
+			buf.WriteString(style.SelfClose)
+		case len(n.Children) == 1 && n.Children[0].Kind == xmlText:
+			buf.WriteString(">" + escapeXML(n.Children[0].Text, false, style.CharRefs) + "</" + n.Name + ">")
+		default:
+			buf.WriteString(">" + style.Newline)
+			for _, child := range n.Children {
+				child.write(buf, style, depth+1)
+				buf.WriteString(style.Newline)
+			}
+			buf.WriteString(pad + "</" + n.Name + ">")
+		}
+	case xmlText:
+		buf.WriteString(pad + escapeXML(n.Text, false, style.CharRefs))
+	case xmlComment:
+		buf.WriteString(pad + "<!--" + n.Text + "-->")
+	case xmlProcInst:
+		buf.WriteString(pad + "<?" + n.Name)
+		if n.Text != "" {
+			buf.WriteString(" " + n.Text)
+		}
+		buf.WriteString("?>")
+	case xmlDirective:
+		buf.WriteString(pad + "<!" + n.Text + ">")
+	}
+}
+
+func escapeXML(text string, attr, charRefs bool) string {
+	var buf strings.Builder
+	for _, r := range text {
+		switch {
+		case r == '&':
+			buf.WriteString("&amp;")
+		case r == '<':
+			buf.WriteString("&lt;")
+		case r == '>':
+			buf.WriteString("&gt;")
+		case attr && r == '"':
+			buf.WriteString("&quot;")
+		case attr && r == '\'':
+			buf.WriteString("&apos;")
+		case attr && (r == '\n' || r == '\r' || r == '\t'):
+			fmt.Fprintf(&buf, "&#x%04X;", r)
+		case charRefs && r >= utf8.RuneSelf:
+			fmt.Fprintf(&buf, "&#x%04X;", r)
+		default:
+			buf.WriteRune(r)
+		}
+	}
+	return buf.String()
+}
@@ -0,0 +1,276 @@
+package engine
+
+import (
+	"bytes"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nir414/pc-setup/syncer/internal/config"
+)
+
+// mergeDriver merges two revisions of a file against their common base. It
+// returns the merged content and the number of unresolved conflicts; when
+// conflicts remain the content must not be written back.
+type mergeDriver interface {
+	Merge(base, system, repo []byte) ([]byte, int, error)
+}
+
+var mergeDrivers = map[config.MergeDriver]mergeDriver{
+	config.MergeText:    textDriver{},
+	config.MergeINI:     iniDriver{},
+	config.MergeJSON:    jsonDriver{},
+	config.MergeXML:     xmlDriver{},
+	config.MergeLineSet: linesetDriver{},
+}
+
+// mergeRule selects a merge driver for files matching a glob pattern. As
+// with excludes, patterns without a slash match the file name only.
+type mergeRule struct {
+	pattern  string
+	hasSlash bool
+	driver   config.MergeDriver
+}
+
+// newMergeRules compiles a section's merge table. The most specific rule
+// wins regardless of table order: patterns with fewer wildcards are tried
+// first, then longer ones.
+func newMergeRules(table map[string]config.MergeDriver) []mergeRule {
+	rules := make([]mergeRule, 0, len(table))
+	for pattern, driver := range table {
+		normalized := toForwardSlashes(strings.TrimSpace(pattern))
+		if normalized == "" {
+			continue
+		}
+		rules = append(rules, mergeRule{
+			pattern:  normalized,
+			hasSlash: strings.Contains(normalized, "/"),
+			driver:   driver,
+		})
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		wi, wj := strings.Count(rules[i].pattern, "*"), strings.Count(rules[j].pattern, "*")
+		if wi != wj {
+			return wi < wj
+		}
+		if len(rules[i].pattern) != len(rules[j].pattern) {
+			return len(rules[i].pattern) > len(rules[j].pattern)
+		}
+		return rules[i].pattern < rules[j].pattern
+	})
+	return rules
+}
+
+// mergeDriverFor returns the driver configured for key, defaulting to the
+// line-based text driver.
+func (e *Engine) mergeDriverFor(key string) config.MergeDriver {
+	section := e.sectionFor(key)
+	if section == nil {
+		return config.MergeText
+	}
+	rel := strings.TrimPrefix(key, section.Name+"/")
+	for _, rule := range section.MergeRules {
+		candidate := rel
+		if !rule.hasSlash {
+			candidate = path.Base(rel)
+		}
+		if ok, err := path.Match(rule.pattern, candidate); err == nil && ok {
+			return rule.driver
+		}
+	}
+	return config.MergeText
+}
+
+// pickChange resolves a value that two sides may have changed independently
+// of a common base; nil means the value is absent. It reports false when
+// both sides changed the value differently.
+func pickChange[T any](base, system, repo *T, equal func(a, b *T) bool) (*T, bool) {
+	switch {
+	case equal(system, repo):
+		return system, true
+	case equal(system, base):
+		return repo, true
+	case equal(repo, base):
+		return system, true
+	}
+	return system, false
+}
+
+// mergeOrder combines two ordered key lists. Keys of first keep their order
+// and keys only found in second are inserted after their nearest preceding
+// key from second.
+func mergeOrder(first, second []string) []string {
+	result := append([]string(nil), first...)
+	present := make(map[string]bool, len(first))
+	for _, key := range first {
+		present[key] = true
+	}
+
+	last := -1
+	for _, key := range second {
+		if present[key] {
+			for i, existing := range result {
+				if existing == key {
+					last = i
+					break
+				}
+			}
+			continue
+		}
+		last++
+		result = append(result, "")
+		copy(result[last+1:], result[last:])
+		result[last] = key
+		present[key] = true
+	}
+	return result
+}
+
+// keyed indexes the items of one version by identity.
+type keyed[T any] struct {
+	IDs  []string
+	ByID map[string]T
+}
+
+func indexBy[T any](items []T, identity func(T) string) keyed[T] {
+	ids := make([]string, len(items))
+	for i, item := range items {
+		ids[i] = identity(item)
+	}
+	index := keyed[T]{IDs: occurrenceKeys(ids), ByID: make(map[string]T, len(items))}
+	for i, id := range index.IDs {
+		index.ByID[id] = items[i]
+	}
+	return index
+}
+
+// get returns the item stored under id, or nil when there is none.
+func (k keyed[T]) get(id string) *T {
+	item, ok := k.ByID[id]
+	if !ok {
+		return nil
+	}
+	return &item
+}
+
+func equalPtr[T comparable](a, b *T) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// occurrenceKeys makes identities unique by numbering repeated ones.
+func occurrenceKeys(ids []string) []string {
+	seen := make(map[string]int, len(ids))
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = id + "#" + strconv.Itoa(seen[id])
+		seen[id]++
+	}
+	return keys
+}
+
+// detectNewline returns the line terminator used by content.
+func detectNewline(content []byte) string {
+	if idx := bytes.IndexByte(content, '\n'); idx > 0 && content[idx-1] == '\r' {
+		return "\r\n"
+	}
+	return "\n"
+}
+
+func trimNewline(line string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+}
+
+// textDriver performs a diff3-style line merge.
+type textDriver struct{}
+
+func (textDriver) Merge(base, system, repo []byte) ([]byte, int, error) {
+	merged, conflicts := merge3(
+		splitLines(string(base)),
+		splitLines(string(system)),
+		splitLines(string(repo)),
+		mergeLabels{Ours: "system", Base: "base", Theirs: "repo"},
+	)
+	return []byte(strings.Join(merged, "")), conflicts, nil
+}
+
+// linesetDriver treats a file as an unordered set of lines, such as a user
+// dictionary: lines added on either side are kept and lines removed on
+// either side are dropped, so it never conflicts. Hunspell dictionaries
+// start with a word count; when every version starts with a number it is
+// treated as such a header and recomputed.
+type linesetDriver struct{}
+
+func (linesetDriver) Merge(base, system, repo []byte) ([]byte, int, error) {
+	baseLines, systemLines, repoLines := setLines(base), setLines(system), setLines(repo)
+	counted := hasCountHeader(baseLines) && hasCountHeader(systemLines) && hasCountHeader(repoLines)
+	if counted {
+		baseLines, systemLines, repoLines = baseLines[1:], systemLines[1:], repoLines[1:]
+	}
+
+	inBase, inSystem, inRepo := lineSet(baseLines), lineSet(systemLines), lineSet(repoLines)
+	emitted := make(map[string]bool)
+	var result []string
+	for _, line := range systemLines {
+		if emitted[line] || (inBase[line] && !inRepo[line]) {
+			continue
+		}
+		emitted[line] = true
+		result = append(result, line)
+	}
+	for _, line := range repoLines {
+		if emitted[line] || inBase[line] || inSystem[line] {
+			continue
+		}
+		emitted[line] = true
+		result = append(result, line)
+	}
+	if counted {
+		result = append([]string{strconv.Itoa(len(result))}, result...)
+	}
+
+	return joinLines(result, system), 0, nil
+}
+
+// joinLines joins merged lines using the line terminator of like and keeps
+// its choice of whether the last line is terminated.
+func joinLines(lines []string, like []byte) []byte {
+	newline := detectNewline(like)
+	var buf strings.Builder
+	for i, line := range lines {
+		buf.WriteString(line)
+		if i < len(lines)-1 || len(like) == 0 || bytes.HasSuffix(like, []byte("\n")) {
+			buf.WriteString(newline)
+		}
+	}
+	return []byte(buf.String())
+}
+
+func setLines(content []byte) []string {
+	var lines []string
+	for _, line := range splitLines(string(content)) {
+		if line = trimNewline(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func lineSet(lines []string) map[string]bool {
+	set := make(map[string]bool, len(lines))
+	for _, line := range lines {
+		set[line] = true
+	}
+	return set
+}
+
+func hasCountHeader(lines []string) bool {
+	if len(lines) == 0 {
+		return false
+	}
+	_, err := strconv.Atoi(strings.TrimSpace(lines[0]))
+	return err == nil
+}
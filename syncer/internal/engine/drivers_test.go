@@ -0,0 +1,136 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/nir414/pc-setup/syncer/internal/config"
+)
+
+func TestMergeDrivers(t *testing.T) {
+	cases := []struct {
+		name      string
+		driver    config.MergeDriver
+		base      string
+		system    string
+		repo      string
+		want      string
+		conflicts int
+	}{
+		{
+			"ini keys changed on both sides",
+			config.MergeINI,
+			"[a]\nx=1\ny=1\n",
+			"[a]\nx=2\ny=1\n",
+			"[a]\nx=1\ny=2\n",
+			"[a]\nx=2\ny=2\n", 0,
+		},
+		{
+			"ini keys and sections added in repo",
+			config.MergeINI,
+			"[a]\nx=1\n\n[b]\nz=1\n",
+			"[a]\nx=2\n\n[b]\nz=1\n",
+			"[a]\nx=1\nw=1\n\n[b]\nz=1\n\n[c]\nq=1\n",
+			"[a]\nx=2\nw=1\n\n[b]\nz=1\n\n[c]\nq=1\n", 0,
+		},
+		{
+			"ini key deleted in repo",
+			config.MergeINI,
+			"[a]\r\nx=1\r\ny=1\r\n",
+			"[a]\r\nx=2\r\ny=1\r\n",
+			"[a]\r\nx=1\r\n",
+			"[a]\r\nx=2\r\n", 0,
+		},
+		{
+			"ini conflicting key",
+			config.MergeINI,
+			"[a]\nx=1\n",
+			"[a]\nx=2\n",
+			"[a]\nx=3\n",
+			"[a]\nx=2\n", 1,
+		},
+		{
+			"json nested members",
+			config.MergeJSON,
+			"{\n\t\"a\": 1,\n\t\"b\": {\"c\": true, \"d\": \"x\"}\n}\n",
+			"{\n\t\"a\": 2,\n\t\"b\": {\"c\": true, \"d\": \"x\"}\n}\n",
+			"{\n\t\"a\": 1,\n\t\"b\": {\"c\": false, \"d\": \"x\"},\n\t\"e\": [1, 2]\n}\n",
+			"{\n\t\"a\": 2,\n\t\"b\": {\n\t\t\"c\": false,\n\t\t\"d\": \"x\"\n\t},\n\t\"e\": [\n\t\t1,\n\t\t2\n\t]\n}\n", 0,
+		},
+		{
+			"json compact document",
+			config.MergeJSON,
+			`{"w":1,"h":1}`,
+			`{"w":2,"h":1}`,
+			`{"w":1,"h":3}`,
+			`{"w":2,"h":3}`, 0,
+		},
+		{
+			"json conflicting arrays",
+			config.MergeJSON,
+			`{"a":[1]}`,
+			`{"a":[2]}`,
+			`{"a":[3]}`,
+			`{"a":[2]}`, 1,
+		},
+		{
+			"xml attributes and named children",
+			config.MergeXML,
+			"<r>\n    <G name=\"a\" v=\"1\" />\n    <G name=\"b\" v=\"1\" />\n</r>\n",
+			"<r>\n    <G name=\"a\" v=\"2\" />\n    <G name=\"b\" v=\"1\" />\n</r>\n",
+			"<r>\n    <G name=\"a\" v=\"1\" />\n    <G name=\"c\" v=\"1\" />\n    <G name=\"b\" v=\"1\" w=\"x\" />\n</r>\n",
+			"<r>\n    <G name=\"a\" v=\"2\" />\n    <G name=\"c\" v=\"1\" />\n    <G name=\"b\" v=\"1\" w=\"x\" />\n</r>\n", 0,
+		},
+		{
+			"xml character references",
+			config.MergeXML,
+			"<r>\n  <P name=\"&#xC5C5;\" />\n</r>\n",
+			"<r>\n  <P name=\"&#xC5C5;\" a=\"1\" />\n</r>\n",
+			"<r>\n  <P name=\"&#xC5C5;\" />\n  <Q>t &amp; u</Q>\n</r>\n",
+			"<r>\n  <P name=\"&#xC5C5;\" a=\"1\" />\n  <Q>t &amp; u</Q>\n</r>\n", 0,
+		},
+		{
+			"lineset dictionary",
+			config.MergeLineSet,
+			"3\nalpha\nbeta\ngamma\n",
+			"3\nalpha\ngamma\ndelta\n",
+			"4\nalpha\nbeta\ngamma\nepsilon\n",
+			"4\nalpha\ngamma\ndelta\nepsilon\n", 0,
+		},
+	}
+
+	for _, tc := range cases {
+		got, conflicts, err := mergeDrivers[tc.driver].Merge([]byte(tc.base), []byte(tc.system), []byte(tc.repo))
+		if err != nil {
+			t.Fatalf("%s: merge failed: %v", tc.name, err)
+		}
+		if string(got) != tc.want || conflicts != tc.conflicts {
+			t.Fatalf("%s: got %q (%d conflicts), want %q (%d conflicts)", tc.name, got, conflicts, tc.want, tc.conflicts)
+		}
+	}
+}
+
+func TestMergeRules(t *testing.T) {
+	rules := newMergeRules(map[string]config.MergeDriver{
+		"*.xml":           config.MergeXML,
+		"Notepad++/*.xml": config.MergeText,
+		"plugins\\*.ini":  config.MergeINI,
+		"*.dic":           config.MergeLineSet,
+		"session.xml":     config.MergeNone,
+	})
+	e := &Engine{targets: []sectionSpec{{Name: "APPDATA", MergeRules: rules}}}
+
+	cases := map[string]config.MergeDriver{
+		"APPDATA/FileZilla/filezilla.xml": config.MergeXML,
+		"APPDATA/Notepad++/config.xml":    config.MergeText,
+		"APPDATA/Notepad++/session.xml":   config.MergeNone,
+		"APPDATA/plugins/a.ini":           config.MergeINI,
+		"APPDATA/other/a.ini":             config.MergeText,
+		"APPDATA/x/y/words.dic":           config.MergeLineSet,
+		"LOCALAPPDATA/a.xml":              config.MergeText,
+	}
+	for key, want := range cases {
+		if got := e.mergeDriverFor(key); got != want {
+			t.Fatalf("mergeDriverFor(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
@@ -2,34 +2,76 @@ package engine
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"runtime"
 	"sort"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/nir414/pc-setup/syncer/internal/config"
+	"github.com/nir414/pc-setup/syncer/internal/history"
+	"github.com/nir414/pc-setup/syncer/internal/journal"
+	"github.com/nir414/pc-setup/syncer/internal/objects"
 	"github.com/nir414/pc-setup/syncer/internal/state"
+	"github.com/nir414/pc-setup/syncer/internal/trash"
 )
 
 // Options configures the sync engine.
 type Options struct {
-	Root          string
-	Config        *config.Config
+	Root   string
+	Config *config.Config
+	// Profile describes the machine profile already applied to Config and is
+	// reported by Status.
+	Profile       *config.Profile
 	SnapshotStore state.Store
-	Logger        *log.Logger
+	// Objects keeps the base content of tracked files for three-way merges.
+	// Merging is disabled when it is nil.
+	Objects *objects.Store
+	// History indexes the versions preserved in Objects before files are
+	// overwritten or removed. Nothing is preserved when it is nil.
+	History *history.Log
+	// Trash receives files removed by backup and sync. Files are deleted
+	// outright when it is nil.
+	Trash *trash.Bin
+	// Journal records backup and sync runs as they happen so an interrupted
+	// run can be rolled back or finished. Runs are not journaled when it is
+	// nil.
+	Journal *journal.Journal
+	// RepoCache remembers repository file hashes between runs. System file
+	// hashes are reused from the snapshot.
+	RepoCache *state.HashCache
+	// Rehash ignores every cached hash and reads all files again.
+	Rehash bool
+	// Jobs bounds how many files are hashed at once; zero or less uses the
+	// number of CPUs.
+	Jobs int
+	// Observer receives progress events. Nothing is reported when it is nil.
+	Observer Observer
+	Logger   *log.Logger
 }
 
 // Engine orchestrates backup and synchronization operations.
 type Engine struct {
 	root      string
 	cfg       *config.Config
+	profile   *config.Profile
 	store     state.Store
+	objects   *objects.Store
+	history   *history.Log
+	trash     *trash.Bin
+	journal   *journal.Journal
+	repoCache *state.HashCache
+	rehash    atomic.Bool
+	jobs      int
+	slots     chan struct{}
+	observer  Observer
 	logger    *log.Logger
+	hostname  string
 	targets   []sectionSpec
 	pathIndex map[string]pathPair
 }
@@ -39,15 +81,21 @@ type BackupResult struct {
 	CopiedFiles  int
 	SkippedFiles int
 	CopiedBytes  int64
+	MergedFiles  int
 	RemovedFiles int
+	// PendingFiles counts repository changes left for a sync.
+	PendingFiles int
 }
 
 // SyncResult captures statistics from a sync run.
 type SyncResult struct {
 	UpdatedFiles int
 	UpdatedBytes int64
+	MergedFiles  int
 	RemovedFiles int
 	SkippedFiles int
+	// PendingFiles counts system changes left for a backup.
+	PendingFiles int
 }
 
 // StatusReport summarises the current difference between system and repository.
@@ -55,6 +103,13 @@ type StatusReport struct {
 	GeneratedAt time.Time
 	Summary     StatusSummary
 	Entries     []DiffEntry
+	// Profile is the active machine profile, or nil without one.
+	Profile *config.Profile
+	// Collisions lists files that differ only by case in case-insensitive
+	// sections. They are left alone until all but one are renamed.
+	Collisions []CaseCollision
+	// PolicySkips lists the files left out by max_file_size or skip_binary.
+	PolicySkips []PolicySkip
 }
 
 // StatusSummary aggregates counts for diff categories.
@@ -80,23 +135,43 @@ const (
 	DiffStatusConflict       DiffStatus = "conflict"
 )
 
-// DiffEntry describes the state of a single logical file.
+// Side names one of the two places a file lives.
+type Side string
+
+// Sides of a sync.
+const (
+	SideSystem Side = "system"
+	SideRepo   Side = "repo"
+)
+
+// DiffEntry describes the state of a single logical file. BaseHash is the
+// hash recorded in the snapshot, if any. In case-insensitive sections the
+// two sides may spell the path differently; Renamed then names the side whose
+// spelling changed since the snapshot, and Path uses that spelling.
 type DiffEntry struct {
 	Path       string
 	Status     DiffStatus
 	System     *FileInfo
 	Repo       *FileInfo
+	BaseHash   string
 	SystemPath string
 	RepoPath   string
+	Renamed    Side
 }
 
-// FileInfo represents a tracked file instance.
+// FileInfo represents a tracked file instance. Path is the key as spelled on
+// disk. Collides lists every path on the same side, this one included, that
+// differs from it only by case.
 type FileInfo struct {
-	Path    string
-	AbsPath string
-	Size    int64
-	ModTime time.Time
-	Hash    string
+	Path     string
+	AbsPath  string
+	Size     int64
+	ModTime  time.Time
+	Hash     string
+	Collides []string
+
+	// binary is whether the scan found the file binary.
+	binary bool
 }
 
 // New constructs an Engine from the provided options.
@@ -111,12 +186,33 @@ func New(opts Options) *Engine {
 		logger = log.New(io.Discard, "", log.LstdFlags)
 	}
 
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "unknown"
+	}
+
+	jobs := opts.Jobs
+	if jobs <= 0 {
+		jobs = runtime.NumCPU()
+	}
+
 	e := &Engine{
-		root:   root,
-		cfg:    opts.Config,
-		store:  opts.SnapshotStore,
-		logger: logger,
+		root:      root,
+		cfg:       opts.Config,
+		profile:   opts.Profile,
+		store:     opts.SnapshotStore,
+		objects:   opts.Objects,
+		history:   opts.History,
+		trash:     opts.Trash,
+		journal:   opts.Journal,
+		repoCache: opts.RepoCache,
+		observer:  opts.Observer,
+		logger:    logger,
+		hostname:  hostname,
+		jobs:      jobs,
+		slots:     make(chan struct{}, jobs),
 	}
+	e.rehash.Store(opts.Rehash)
 	sections, index := e.buildTargets()
 	e.targets = sections
 	e.pathIndex = index
@@ -131,20 +227,15 @@ func (e *Engine) buildTargets() ([]sectionSpec, map[string]pathPair) {
 	sections := make([]sectionSpec, 0, len(e.cfg.SyncData))
 	index := make(map[string]pathPair)
 	for name, section := range e.cfg.SyncData {
-		descriptor, ok := knownSections[strings.ToUpper(name)]
-		if !ok {
-			e.logger.Printf("warning: unsupported section %q ignored", name)
-			continue
-		}
-
-		sourceBase := os.Getenv(descriptor.EnvVar)
-		if sourceBase == "" {
-			e.logger.Printf("warning: environment variable %s not set; skipping section %s", descriptor.EnvVar, name)
+		sourceBase, err := sectionRoot(name, section, runtime.GOOS)
+		if err != nil {
+			e.logger.Printf("warning: %v; skipping section %s", err, name)
 			continue
 		}
 
-		destBase := filepath.Join(e.root, "SyncData", descriptor.RepositoryDir)
-		matcher := newMatcher(section.Excludes)
+		repoDir := section.RepoDirName(name)
+		destBase := filepath.Join(e.root, "SyncData", repoDir)
+		matcher := newMatcher(section.Excludes, section.Includes)
 
 		folders := make([]folderSpec, 0, len(section.Folders))
 		for _, folder := range section.Folders {
@@ -152,18 +243,17 @@ func (e *Engine) buildTargets() ([]sectionSpec, map[string]pathPair) {
 			if normalized == "" {
 				continue
 			}
+			maxFileSize, skipBinary := section.FolderLimits(folder)
 			folderInfo := folderSpec{
-				ConfigPath: normalized,
-				SourcePath: filepath.Join(sourceBase, normalized),
-				DestPath:   filepath.Join(destBase, normalized),
+				ConfigPath:  normalized,
+				SourcePath:  filepath.Join(sourceBase, normaliseFolder(section.FolderPath(runtime.GOOS, folder))),
+				DestPath:    filepath.Join(destBase, normalized),
+				MaxFileSize: maxFileSize,
+				SkipBinary:  skipBinary,
 			}
-			folders = append(folders, folderSpec{
-				ConfigPath: normalized,
-				SourcePath: folderInfo.SourcePath,
-				DestPath:   folderInfo.DestPath,
-			})
+			folders = append(folders, folderInfo)
 
-			prefix := makeKey(descriptor.RepositoryDir, normalized)
+			prefix := makeKey(repoDir, normalized)
 			index[prefix] = pathPair{
 				SystemBase: folderInfo.SourcePath,
 				RepoBase:   folderInfo.DestPath,
@@ -171,12 +261,17 @@ func (e *Engine) buildTargets() ([]sectionSpec, map[string]pathPair) {
 		}
 
 		spec := sectionSpec{
-			Name:       descriptor.RepositoryDir,
-			EnvVar:     descriptor.EnvVar,
+			Name:       repoDir,
 			SourceBase: sourceBase,
 			DestBase:   destBase,
 			Folders:    folders,
 			Matcher:    matcher,
+			FoldCase:   section.FoldsCase(name),
+			OnConflict: section.OnConflict,
+			MergeRules: newMergeRules(section.Merge),
+
+			MaxDeletes:       section.MaxDeletes,
+			MaxDeletePercent: section.MaxDeletePercent,
 		}
 
 		sections = append(sections, spec)
@@ -199,52 +294,31 @@ func (e *Engine) buildTargets() ([]sectionSpec, map[string]pathPair) {
 }
 
 // Backup synchronises files from the system into the repository.
-func (e *Engine) Backup(ctx context.Context) (*BackupResult, error) {
-	_, diff, err := e.computeDiff(ctx)
+func (e *Engine) Backup(ctx context.Context, opts RunOptions) (*BackupResult, error) {
+	if err := e.checkInterrupted(); err != nil {
+		return nil, err
+	}
+	plan, err := e.Plan(ctx, DirectionBackup)
 	if err != nil {
 		return nil, err
 	}
-
-	stats := &BackupResult{}
-
-	for _, entry := range diff.Entries {
-		switch entry.Status {
-		case DiffStatusSystemAdded, DiffStatusSystemModified:
-			if entry.SystemPath == "" || entry.RepoPath == "" {
-				continue
-			}
-			if err := e.copyFile(entry.SystemPath, entry.RepoPath); err != nil {
-				return nil, fmt.Errorf("copy %s: %w", entry.Path, err)
-			}
-			stats.CopiedFiles++
-			if entry.System != nil {
-				stats.CopiedBytes += entry.System.Size
-			}
-		case DiffStatusSystemDeleted:
-			if entry.RepoPath == "" {
-				continue
-			}
-			if err := os.Remove(entry.RepoPath); err != nil && !errors.Is(err, os.ErrNotExist) {
-				return nil, fmt.Errorf("remove %s: %w", entry.Path, err)
-			}
-			stats.RemovedFiles++
-		case DiffStatusConflict:
-			stats.SkippedFiles++
-		default:
-			// changes owned by repo, skip during backup
-		}
+	if err := guardPlan(plan, opts.AllowDeletes); err != nil {
+		return nil, err
 	}
 
-	freshSnapshot, err := e.collectSystemSnapshot(ctx)
+	result, err := e.execute(ctx, plan)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := e.store.Save(ctx, freshSnapshot); err != nil {
-		return nil, fmt.Errorf("save snapshot: %w", err)
-	}
-
-	return stats, nil
+	return &BackupResult{
+		CopiedFiles:  result.CopiedFiles,
+		SkippedFiles: result.SkippedFiles,
+		CopiedBytes:  result.CopiedBytes,
+		MergedFiles:  result.MergedFiles,
+		RemovedFiles: result.RemovedFiles,
+		PendingFiles: result.PendingFiles,
+	}, nil
 }
 
 // Status computes a status report describing pending changes.
@@ -257,9 +331,12 @@ func (e *Engine) Status(ctx context.Context) (*StatusReport, error) {
 	report := &StatusReport{
 		GeneratedAt: time.Now(),
 		Entries:     diff.Entries,
+		Profile:     e.profile,
+		PolicySkips: diff.Skipped,
 	}
 
 	for _, entry := range diff.Entries {
+		report.Collisions = append(report.Collisions, entry.collisions()...)
 		switch entry.Status {
 		case DiffStatusUpToDate:
 			report.Summary.UpToDate++
@@ -286,68 +363,46 @@ func (e *Engine) Status(ctx context.Context) (*StatusReport, error) {
 }
 
 // Sync applies repository changes to the system.
-func (e *Engine) Sync(ctx context.Context) (*SyncResult, error) {
-	_, diff, err := e.computeDiff(ctx)
+func (e *Engine) Sync(ctx context.Context, opts RunOptions) (*SyncResult, error) {
+	if err := e.checkInterrupted(); err != nil {
+		return nil, err
+	}
+	plan, err := e.Plan(ctx, DirectionSync)
 	if err != nil {
 		return nil, err
 	}
-	stats := &SyncResult{}
-
-	for _, entry := range diff.Entries {
-		switch entry.Status {
-		case DiffStatusRepoAdded, DiffStatusRepoModified:
-			if entry.SystemPath == "" || entry.RepoPath == "" {
-				continue
-			}
-			if err := e.copyFile(entry.RepoPath, entry.SystemPath); err != nil {
-				return nil, fmt.Errorf("sync copy %s: %w", entry.Path, err)
-			}
-			stats.UpdatedFiles++
-			if entry.Repo != nil {
-				stats.UpdatedBytes += entry.Repo.Size
-			}
-		case DiffStatusRepoDeleted:
-			if entry.SystemPath == "" {
-				continue
-			}
-			if err := os.Remove(entry.SystemPath); err != nil && !errors.Is(err, os.ErrNotExist) {
-				return nil, fmt.Errorf("sync remove %s: %w", entry.Path, err)
-			}
-			stats.RemovedFiles++
-		case DiffStatusConflict, DiffStatusSystemAdded, DiffStatusSystemModified, DiffStatusSystemDeleted:
-			stats.SkippedFiles++
-		}
+	if err := guardPlan(plan, opts.AllowDeletes); err != nil {
+		return nil, err
 	}
 
-	freshSnapshot, err := e.collectSystemSnapshot(ctx)
+	result, err := e.execute(ctx, plan)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := e.store.Save(ctx, freshSnapshot); err != nil {
-		return nil, fmt.Errorf("save snapshot: %w", err)
-	}
-
-	return stats, nil
+	return &SyncResult{
+		UpdatedFiles: result.CopiedFiles,
+		UpdatedBytes: result.CopiedBytes,
+		MergedFiles:  result.MergedFiles,
+		RemovedFiles: result.RemovedFiles,
+		SkippedFiles: result.SkippedFiles,
+		PendingFiles: result.PendingFiles,
+	}, nil
 }
 
 func (e *Engine) computeDiff(ctx context.Context) (*state.Snapshot, *diffResult, error) {
 	snapshot, err := e.store.Load(ctx)
 	if err != nil {
-		return nil, nil, fmt.Errorf("load snapshot: %w", err)
+		return nil, nil, &OpError{Op: OpLoadSnapshot, Err: err}
 	}
 
-	systemFiles, err := e.collectSystemFiles(ctx)
+	systemFiles, repoFiles, skipped, err := e.collectFiles(ctx, snapshot)
 	if err != nil {
-		return nil, nil, fmt.Errorf("collect system files: %w", err)
+		return nil, nil, err
 	}
 
-	repoFiles, err := e.collectRepoFiles(ctx)
-	if err != nil {
-		return nil, nil, fmt.Errorf("collect repo files: %w", err)
-	}
-
-	diff := buildDiff(systemFiles, repoFiles, snapshot)
+	diff := buildDiff(systemFiles, repoFiles, snapshot, e.foldKey)
+	diff.Skipped = skipped
 	for i := range diff.Entries {
 		sysPath, repoPath, ok := e.resolvePaths(diff.Entries[i].Path)
 		if diff.Entries[i].System != nil {
@@ -379,6 +434,19 @@ func (e *Engine) copyFile(src, dst string) error {
 
 type diffResult struct {
 	Entries []DiffEntry
+	// Skipped lists the files left out by file limits, sorted by path.
+	Skipped []PolicySkip
+}
+
+// sectionFor returns the section owning key, or nil if none does.
+func (e *Engine) sectionFor(key string) *sectionSpec {
+	name, _, _ := strings.Cut(key, "/")
+	for i := range e.targets {
+		if e.targets[i].Name == name {
+			return &e.targets[i]
+		}
+	}
+	return nil
 }
 
 func (e *Engine) resolvePaths(key string) (string, string, bool) {
@@ -0,0 +1,37 @@
+package engine
+
+import "fmt"
+
+// Op identifies the kind of operation that failed.
+type Op string
+
+// Operation kinds reported through OpError.
+const (
+	OpScan         Op = "scan"
+	OpLoadSnapshot Op = "load snapshot"
+	OpSaveSnapshot Op = "save snapshot"
+	OpCopy         Op = "copy"
+	OpRemove       Op = "remove"
+	OpHistory      Op = "history"
+	OpTrash        Op = "trash"
+	OpJournal      Op = "journal"
+)
+
+// OpError records a failed operation together with the logical path it
+// concerned, so callers can tell failure kinds apart without parsing text.
+type OpError struct {
+	Op   Op
+	Path string
+	Err  error
+}
+
+func (e *OpError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("%s: %v", e.Op, e.Err)
+	}
+	return fmt.Sprintf("%s %s: %v", e.Op, e.Path, e.Err)
+}
+
+func (e *OpError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,55 @@
+package engine
+
+import "time"
+
+// EventKind identifies a progress event.
+type EventKind string
+
+// Event kinds, in the order a run emits them. Scan events carry the side
+// being scanned; file events of a run carry the action they complete.
+const (
+	EventScanStarted   EventKind = "scan_started"
+	EventFileHashed    EventKind = "file_hashed"
+	EventScanFinished  EventKind = "scan_finished"
+	EventActionPlanned EventKind = "action_planned"
+	EventRunStarted    EventKind = "run_started"
+	EventFileCopied    EventKind = "file_copied"
+	EventFileMerged    EventKind = "file_merged"
+	EventFileRemoved   EventKind = "file_removed"
+	EventFileSkipped   EventKind = "file_skipped"
+	EventRunFinished   EventKind = "run_finished"
+)
+
+// Event reports progress of a scan, plan or run. Done and Total count files
+// for scans and actions for runs; DoneBytes and TotalBytes count the bytes
+// hashed or transferred so far and overall. Bytes is the size of the file
+// the event is about, and Cached is set when its hash was reused.
+type Event struct {
+	Kind       EventKind
+	Time       time.Time
+	Side       Side
+	Direction  Direction
+	Path       string
+	Action     ActionKind
+	Bytes      int64
+	Cached     bool
+	Done       int
+	Total      int
+	DoneBytes  int64
+	TotalBytes int64
+}
+
+// Observer receives progress events. The system and repository scans run
+// concurrently and hash files on several goroutines, so Observe must be safe
+// for concurrent use and should return quickly.
+type Observer interface {
+	Observe(Event)
+}
+
+func (e *Engine) emit(event Event) {
+	if e.observer == nil {
+		return
+	}
+	event.Time = time.Now().UTC()
+	e.observer.Observe(event)
+}
@@ -0,0 +1,128 @@
+package engine
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/nir414/pc-setup/syncer/internal/config"
+	"github.com/nir414/pc-setup/syncer/internal/state"
+)
+
+type recorder struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (r *recorder) Observe(event Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+func TestBackupEvents(t *testing.T) {
+	root := t.TempDir()
+	system := filepath.Join(root, "sys")
+	t.Setenv("APPDATA", system)
+	repo := filepath.Join(root, "SyncData", "APPDATA")
+	writeFile(t, filepath.Join(system, "app", "a.ini"), "aaa\n")
+	writeFile(t, filepath.Join(system, "app", "b.ini"), "bb\n")
+	writeFile(t, filepath.Join(system, "app", "c.ini"), "c\n")
+	writeFile(t, filepath.Join(repo, "app", "c.ini"), "c\n")
+
+	observer := &recorder{}
+	e := New(Options{
+		Root:          root,
+		Config:        &config.Config{SyncData: map[string]config.Section{"APPDATA": {Folders: []string{"app"}}}},
+		SnapshotStore: state.NewFileStore(filepath.Join(root, ".syncer", "state.json")),
+		Observer:      observer,
+		Jobs:          4,
+	})
+	if _, err := e.Backup(context.Background(), RunOptions{}); err != nil {
+		t.Fatalf("Backup: %v", err)
+	}
+	events := observer.events
+
+	// The scans come first, one per side, each hashing every file between
+	// its start and finish events.
+	planned := -1
+	for i, event := range events {
+		if event.Kind == EventActionPlanned {
+			planned = i
+			break
+		}
+	}
+	if planned < 0 {
+		t.Fatal("no action_planned events")
+	}
+	wantScan := map[Side]struct {
+		files int
+		bytes int64
+	}{SideSystem: {3, 9}, SideRepo: {1, 2}}
+	for side, want := range wantScan {
+		phase, hashed := "", 0
+		for _, event := range events[:planned] {
+			if event.Side != side {
+				continue
+			}
+			switch event.Kind {
+			case EventScanStarted:
+				if phase != "" || event.Total != want.files || event.TotalBytes != want.bytes {
+					t.Fatalf("%s scan_started %+v after %q; want %d files, %d bytes", side, event, phase, want.files, want.bytes)
+				}
+				phase = "started"
+			case EventFileHashed:
+				hashed++
+				if phase != "started" || event.Done != hashed || event.Total != want.files {
+					t.Fatalf("%s file_hashed %+v; want done %d of %d inside the scan", side, event, hashed, want.files)
+				}
+			case EventScanFinished:
+				if phase != "started" || event.Done != want.files || event.DoneBytes != want.bytes {
+					t.Fatalf("%s scan_finished %+v; want %d files, %d bytes", side, event, want.files, want.bytes)
+				}
+				phase = "finished"
+			}
+		}
+		if phase != "finished" || hashed != want.files {
+			t.Fatalf("%s scan ended %q after %d files; want finished after %d", side, phase, hashed, want.files)
+		}
+	}
+
+	// Then the plan and the run, which reports its end last; the rescan
+	// while saving the snapshot is not reported.
+	var kinds []EventKind
+	for _, event := range events[planned:] {
+		kinds = append(kinds, event.Kind)
+	}
+	wantKinds := []EventKind{EventActionPlanned, EventActionPlanned, EventRunStarted, EventFileCopied, EventFileCopied, EventRunFinished}
+	if len(kinds) != len(wantKinds) {
+		t.Fatalf("events after scanning = %v, want %v", kinds, wantKinds)
+	}
+	for i := range kinds {
+		if kinds[i] != wantKinds[i] {
+			t.Fatalf("events after scanning = %v, want %v", kinds, wantKinds)
+		}
+	}
+	if last := events[len(events)-1]; last.Kind != EventRunFinished || last.Done != 2 || last.Total != 2 || last.DoneBytes != 7 || last.TotalBytes != 7 {
+		t.Fatalf("run_finished = %+v; want 2 of 2 actions, 7 of 7 bytes", last)
+	}
+
+	var doneBytes []int64
+	for _, event := range events[planned:] {
+		switch event.Kind {
+		case EventRunStarted:
+			if event.Total != 2 || event.TotalBytes != 7 || event.Direction != DirectionBackup {
+				t.Fatalf("run_started = %+v; want 2 backup actions, 7 bytes", event)
+			}
+		case EventFileCopied:
+			doneBytes = append(doneBytes, event.DoneBytes)
+			if event.Done != len(doneBytes) || event.Total != 2 {
+				t.Fatalf("file_copied = %+v; want done %d of 2", event, len(doneBytes))
+			}
+		}
+	}
+	if doneBytes[0] != 4 || doneBytes[1] != 7 {
+		t.Fatalf("file_copied bytes done = %v, want [4 7]", doneBytes)
+	}
+}
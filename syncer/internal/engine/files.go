@@ -57,13 +57,40 @@ func copyFileContents(src, dst string) error {
 		return err
 	}
 
-	if err := os.Rename(tmpDst, dst); err != nil {
+	return replaceFile(tmpDst, dst)
+}
+
+// writeContent atomically replaces dst with content, keeping the mode of an
+// existing file.
+func writeContent(dst string, content []byte) error {
+	mode := os.FileMode(0o644)
+	if info, err := os.Stat(dst); err == nil {
+		mode = info.Mode()
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+
+	tmpDst := dst + ".tmp"
+	if err := os.WriteFile(tmpDst, content, mode); err != nil {
+		os.Remove(tmpDst)
+		return err
+	}
+
+	return replaceFile(tmpDst, dst)
+}
+
+// replaceFile renames tmp over dst, removing dst first on platforms where
+// rename does not replace existing files.
+func replaceFile(tmp, dst string) error {
+	if err := os.Rename(tmp, dst); err != nil {
 		if removeErr := os.Remove(dst); removeErr != nil && !errors.Is(removeErr, os.ErrNotExist) {
-			os.Remove(tmpDst)
+			os.Remove(tmp)
 			return err
 		}
-		if err := os.Rename(tmpDst, dst); err != nil {
-			os.Remove(tmpDst)
+		if err := os.Rename(tmp, dst); err != nil {
+			os.Remove(tmp)
 			return err
 		}
 	}
@@ -0,0 +1,160 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Deletion guard defaults, used when a section leaves max_deletes or
+// max_delete_percent unset. The percentage limit only applies to scopes with
+// at least guardMinFiles tracked files, so removing one of two files in a
+// small folder does not trip it.
+const (
+	defaultMaxDeletes       = 20
+	defaultMaxDeletePercent = 50
+	guardMinFiles           = 10
+)
+
+// GuardTrigger names a folder or section whose planned deletions tripped the
+// deletion guard.
+type GuardTrigger struct {
+	Scope   string `json:"scope"`
+	Path    string `json:"path"`
+	Deletes int    `json:"deletes"`
+	Files   int    `json:"files"`
+	Reason  string `json:"reason"`
+}
+
+// DeletionGuardError is returned when a plan deletes more files than the
+// configured limits allow and deletions were not explicitly allowed.
+type DeletionGuardError struct {
+	Triggers []GuardTrigger
+}
+
+func (e *DeletionGuardError) Error() string {
+	parts := make([]string, 0, len(e.Triggers))
+	for _, trigger := range e.Triggers {
+		parts = append(parts, fmt.Sprintf("%s (%s)", trigger.Path, trigger.Reason))
+	}
+	return fmt.Sprintf("deletion guard: refusing to delete files in %d location(s): %s",
+		len(e.Triggers), strings.Join(parts, "; "))
+}
+
+// guardPlan refuses plans whose deletions tripped the guard unless allow is
+// set.
+func guardPlan(plan *Plan, allow bool) error {
+	if allow || len(plan.Guard) == 0 {
+		return nil
+	}
+	return &DeletionGuardError{Triggers: plan.Guard}
+}
+
+type guardScope struct {
+	path    string
+	section *sectionSpec
+	folder  *folderSpec
+	files   int
+	deletes int
+}
+
+// checkDeletions evaluates the deletion guard for plan. Every configured
+// folder and section is checked against the count and percentage limits of
+// its section, and a folder whose source directory is missing while the plan
+// deletes files from it always trips the guard. Section limits are only
+// reported when none of their folders already tripped.
+func (e *Engine) checkDeletions(plan *Plan, diff *diffResult) []GuardTrigger {
+	deleted := make(map[string]bool)
+	for _, action := range plan.Actions {
+		if action.Kind == ActionDelete {
+			deleted[e.foldKey(action.Path)] = true
+		}
+	}
+	if len(deleted) == 0 {
+		return nil
+	}
+
+	var triggers []GuardTrigger
+	for i := range e.targets {
+		section := &e.targets[i]
+		sectionScope := &guardScope{path: section.Name, section: section}
+		folderScopes := make([]*guardScope, len(section.Folders))
+		for j := range section.Folders {
+			folder := &section.Folders[j]
+			folderScopes[j] = &guardScope{path: makeKey(section.Name, toForwardSlashes(folder.ConfigPath)), section: section, folder: folder}
+		}
+
+		for _, entry := range diff.Entries {
+			if !strings.HasPrefix(entry.Path, section.Name+"/") {
+				continue
+			}
+			isDeleted := deleted[e.foldKey(entry.Path)]
+			sectionScope.count(isDeleted)
+			for _, scope := range folderScopes {
+				if strings.HasPrefix(entry.Path, scope.path+"/") {
+					scope.count(isDeleted)
+				}
+			}
+		}
+
+		tripped := false
+		for _, scope := range folderScopes {
+			if trigger, ok := e.evaluate(plan.Direction, scope, "folder"); ok {
+				triggers = append(triggers, trigger)
+				tripped = true
+			}
+		}
+		if !tripped {
+			if trigger, ok := e.evaluate(plan.Direction, sectionScope, "section"); ok {
+				triggers = append(triggers, trigger)
+			}
+		}
+	}
+	return triggers
+}
+
+func (s *guardScope) count(deleted bool) {
+	s.files++
+	if deleted {
+		s.deletes++
+	}
+}
+
+func (e *Engine) evaluate(dir Direction, scope *guardScope, kind string) (GuardTrigger, bool) {
+	if scope.deletes == 0 {
+		return GuardTrigger{}, false
+	}
+	trigger := GuardTrigger{Scope: kind, Path: scope.path, Deletes: scope.deletes, Files: scope.files}
+
+	if scope.folder != nil {
+		source := scope.folder.SourcePath
+		if dir == DirectionSync {
+			source = scope.folder.DestPath
+		}
+		if _, err := os.Stat(source); errors.Is(err, os.ErrNotExist) {
+			trigger.Reason = fmt.Sprintf("folder missing on %s side; %d file(s) would be deleted", sourceSide(dir), scope.deletes)
+			return trigger, true
+		}
+	}
+
+	maxDeletes := scope.section.MaxDeletes
+	if maxDeletes == 0 {
+		maxDeletes = defaultMaxDeletes
+	}
+	if maxDeletes > 0 && scope.deletes > maxDeletes {
+		trigger.Reason = fmt.Sprintf("%d deletions exceed max_deletes=%d", scope.deletes, maxDeletes)
+		return trigger, true
+	}
+
+	maxPercent := scope.section.MaxDeletePercent
+	if maxPercent == 0 {
+		maxPercent = defaultMaxDeletePercent
+	}
+	if maxPercent > 0 && scope.files >= guardMinFiles && scope.deletes*100 > maxPercent*scope.files {
+		trigger.Reason = fmt.Sprintf("%d of %d files would be deleted, over max_delete_percent=%d",
+			scope.deletes, scope.files, maxPercent)
+		return trigger, true
+	}
+	return GuardTrigger{}, false
+}
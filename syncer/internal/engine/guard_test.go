@@ -0,0 +1,89 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nir414/pc-setup/syncer/internal/config"
+	"github.com/nir414/pc-setup/syncer/internal/state"
+)
+
+func TestGuardLimits(t *testing.T) {
+	e := &Engine{}
+
+	cases := []struct {
+		name       string
+		maxDeletes int
+		maxPercent int
+		files      int
+		deletes    int
+		expect     bool
+	}{
+		{"no deletions", 0, 0, 100, 0, false},
+		{"under defaults", 0, 0, 100, 20, false},
+		{"over default count", 0, 0, 100, 21, true},
+		{"over default percent", 0, 0, 12, 7, true},
+		{"small scope ignores percent", 0, 0, 4, 3, false},
+		{"custom count", 5, 0, 100, 6, true},
+		{"count disabled", -1, 0, 1000, 400, false},
+		{"percent disabled", 0, -1, 10, 10, false},
+		{"custom percent", 0, 10, 20, 3, true},
+	}
+
+	for _, tc := range cases {
+		section := &sectionSpec{MaxDeletes: tc.maxDeletes, MaxDeletePercent: tc.maxPercent}
+		scope := &guardScope{path: "S", section: section, files: tc.files, deletes: tc.deletes}
+		if _, got := e.evaluate(DirectionBackup, scope, "section"); got != tc.expect {
+			t.Fatalf("%s: evaluate = %v, want %v", tc.name, got, tc.expect)
+		}
+	}
+}
+
+func TestApplyChecksGuardAgain(t *testing.T) {
+	root := t.TempDir()
+	system := filepath.Join(root, "sys")
+	t.Setenv("APPDATA", system)
+	for i := 0; i < 30; i++ {
+		writeFile(t, filepath.Join(system, "app", fmt.Sprintf("f%02d.ini", i)), "x\n")
+	}
+
+	e := New(Options{
+		Root:          root,
+		Config:        &config.Config{SyncData: map[string]config.Section{"APPDATA": {Folders: []string{"app"}}}},
+		SnapshotStore: state.NewFileStore(filepath.Join(root, ".syncer", "state.json")),
+	})
+	ctx := context.Background()
+	if _, err := e.Backup(ctx, RunOptions{}); err != nil {
+		t.Fatalf("initial Backup: %v", err)
+	}
+	for i := 0; i < 25; i++ {
+		if err := os.Remove(filepath.Join(system, "app", fmt.Sprintf("f%02d.ini", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	plan, err := e.Plan(ctx, DirectionBackup)
+	if err != nil {
+		t.Fatalf("Plan: %v", err)
+	}
+	if len(plan.Guard) == 0 {
+		t.Fatal("Plan did not trip the deletion guard")
+	}
+	// A plan written without the guard, or edited, must still be refused.
+	plan.Guard = nil
+	var guard *DeletionGuardError
+	if _, err := e.Apply(ctx, plan, ApplyOptions{}); !errors.As(err, &guard) {
+		t.Fatalf("Apply = %v, want a deletion guard error", err)
+	}
+	result, err := e.Apply(ctx, plan, ApplyOptions{AllowDeletes: true})
+	if err != nil {
+		t.Fatalf("Apply with AllowDeletes: %v", err)
+	}
+	if result.RemovedFiles != 25 {
+		t.Fatalf("Apply removed %d files, want 25", result.RemovedFiles)
+	}
+}
@@ -0,0 +1,181 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nir414/pc-setup/syncer/internal/history"
+)
+
+// Reasons recorded for preserved versions.
+const (
+	reasonOverwritten = "overwritten"
+	reasonDeleted     = "deleted"
+	reasonMerged      = "merged"
+	reasonRestored    = "restored"
+)
+
+// Directions recorded for versions replaced outside backup and sync runs.
+const (
+	directionRestore = "restore"
+	directionResolve = "resolve"
+)
+
+var (
+	// ErrUnknownPath is returned for paths not tracked by the configuration.
+	ErrUnknownPath = errors.New("path is not tracked by the current configuration")
+	// ErrVersionNotFound is returned when no preserved version matches.
+	ErrVersionNotFound = errors.New("no matching version")
+)
+
+// RestoreOptions selects the version to restore and where to write it.
+type RestoreOptions struct {
+	// Hash selects a version by its content hash or a unique prefix of it.
+	Hash string
+	// At selects the newest version saved at or before the given time when
+	// Hash is empty.
+	At time.Time
+	// To is the side to write; it defaults to the side the version was
+	// saved from.
+	To Resolution
+}
+
+// RestoreResult describes a completed restore.
+type RestoreResult struct {
+	Path    string
+	Version history.Entry
+	Side    Resolution
+	Target  string
+}
+
+// History returns the preserved versions of key, newest first.
+func (e *Engine) History(key string) ([]history.Entry, error) {
+	key = strings.Trim(toForwardSlashes(key), "/")
+	entries, err := e.history.Entries(key)
+	if err != nil {
+		return nil, &OpError{Op: OpHistory, Path: key, Err: err}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Time.After(entries[j].Time)
+	})
+	return entries, nil
+}
+
+// Restore writes a preserved version of key back to one side. The content
+// it replaces is preserved in turn, so a restore can itself be undone.
+func (e *Engine) Restore(ctx context.Context, key string, opts RestoreOptions) (*RestoreResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := e.checkInterrupted(); err != nil {
+		return nil, err
+	}
+
+	key = strings.Trim(toForwardSlashes(key), "/")
+	systemPath, repoPath, ok := e.resolvePaths(key)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownPath, key)
+	}
+
+	versions, err := e.History(key)
+	if err != nil {
+		return nil, err
+	}
+	version, err := selectVersion(versions, opts)
+	if err != nil {
+		return nil, fmt.Errorf("restore %s: %w", key, err)
+	}
+
+	side := opts.To
+	if side == "" {
+		side = Resolution(version.Side)
+	}
+	target := systemPath
+	if side == ResolveRepo {
+		target = repoPath
+	}
+
+	content, err := e.objects.Get(version.Hash)
+	if err != nil {
+		return nil, &OpError{Op: OpHistory, Path: key, Err: err}
+	}
+	if err := e.preserve(key, target, side, directionRestore, reasonRestored); err != nil {
+		return nil, err
+	}
+	if err := writeContent(target, content); err != nil {
+		return nil, &OpError{Op: OpCopy, Path: key, Err: err}
+	}
+
+	return &RestoreResult{Path: key, Version: version, Side: side, Target: target}, nil
+}
+
+// selectVersion picks the version described by opts from versions, which
+// are ordered newest first.
+func selectVersion(versions []history.Entry, opts RestoreOptions) (history.Entry, error) {
+	if opts.Hash != "" {
+		prefix := strings.ToLower(opts.Hash)
+		var match *history.Entry
+		for i := range versions {
+			if !strings.HasPrefix(versions[i].Hash, prefix) {
+				continue
+			}
+			if match != nil && match.Hash != versions[i].Hash {
+				return history.Entry{}, fmt.Errorf("hash prefix %q is ambiguous", opts.Hash)
+			}
+			if match == nil {
+				match = &versions[i]
+			}
+		}
+		if match == nil {
+			return history.Entry{}, fmt.Errorf("%w: no version with hash %s", ErrVersionNotFound, opts.Hash)
+		}
+		return *match, nil
+	}
+
+	for _, version := range versions {
+		if !version.Time.After(opts.At) {
+			return version, nil
+		}
+	}
+	return history.Entry{}, fmt.Errorf("%w: nothing saved at or before %s", ErrVersionNotFound, opts.At.Format(time.RFC3339))
+}
+
+// preserve stores the current content of path, if it exists, and records it
+// in the history log before the file is overwritten or removed.
+func (e *Engine) preserve(key, path string, side Resolution, direction, reason string) error {
+	if e.history == nil || e.objects == nil {
+		return nil
+	}
+
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return &OpError{Op: OpHistory, Path: key, Err: err}
+	}
+
+	hash, err := e.objects.PutFile(path)
+	if err != nil {
+		return &OpError{Op: OpHistory, Path: key, Err: err}
+	}
+	err = e.history.Append(history.Entry{
+		Key:       key,
+		Hash:      hash,
+		Size:      info.Size(),
+		Time:      time.Now().UTC(),
+		Host:      e.hostname,
+		Direction: direction,
+		Side:      string(side),
+		Reason:    reason,
+	})
+	if err != nil {
+		return &OpError{Op: OpHistory, Path: key, Err: err}
+	}
+	return nil
+}
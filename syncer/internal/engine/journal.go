@@ -0,0 +1,291 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/nir414/pc-setup/syncer/internal/journal"
+)
+
+// Directions and reasons recorded for versions replaced during recovery.
+const (
+	directionRecover = "recover"
+	reasonRolledBack = "rolled back"
+)
+
+// RecoverMode selects how an interrupted run is recovered.
+type RecoverMode string
+
+// Recovery modes.
+const (
+	// RecoverRollback puts every file the run touched back to its original
+	// content, leaving the tree as it was before the run.
+	RecoverRollback RecoverMode = "rollback"
+	// RecoverFinish undoes the action that was cut short and performs the
+	// rest of the run's plan.
+	RecoverFinish RecoverMode = "finish"
+)
+
+// InterruptedRun describes a backup or sync run that did not finish.
+// Pending lists the paths of actions that were started but not completed.
+type InterruptedRun struct {
+	Direction Direction
+	Host      string
+	StartedAt time.Time
+	Actions   int
+	Completed int
+	Pending   []string
+
+	plan    *Plan
+	intents []journal.Intent
+}
+
+// InterruptedRunError is returned while the journal of an interrupted run
+// exists. Nothing else runs until it has been recovered.
+type InterruptedRunError struct {
+	Run *InterruptedRun
+}
+
+func (e *InterruptedRunError) Error() string {
+	return fmt.Sprintf("%s run started %s on %s was interrupted after %d of %d action(s); recover it before running again",
+		e.Run.Direction, e.Run.StartedAt.Local().Format("2006-01-02 15:04:05"), e.Run.Host, e.Run.Completed, e.Run.Actions)
+}
+
+// RecoverResult describes a completed recovery. Reverted lists the paths
+// whose files were put back to their original content; Applied is set when
+// the rest of the run was finished.
+type RecoverResult struct {
+	Mode     RecoverMode
+	Run      *InterruptedRun
+	Reverted []string
+	Applied  *ApplyResult
+}
+
+// Interrupted returns the run recorded in the journal, or nil when the last
+// run finished.
+func (e *Engine) Interrupted() (*InterruptedRun, error) {
+	if e.journal == nil {
+		return nil, nil
+	}
+	recorded, err := e.journal.Load()
+	if err != nil {
+		return nil, &OpError{Op: OpJournal, Err: err}
+	}
+	if recorded == nil {
+		return nil, nil
+	}
+
+	run := &InterruptedRun{Host: recorded.Host, StartedAt: recorded.StartedAt, intents: recorded.Intents}
+	if len(recorded.Plan) > 0 {
+		var plan Plan
+		if err := json.Unmarshal(recorded.Plan, &plan); err != nil {
+			return nil, &OpError{Op: OpJournal, Err: fmt.Errorf("decode journaled plan: %w", err)}
+		}
+		run.plan = &plan
+		run.Direction = plan.Direction
+		for _, action := range plan.Actions {
+			if action.Kind != ActionSkip {
+				run.Actions++
+			}
+		}
+	}
+	for _, intent := range recorded.Intents {
+		if intent.Done {
+			run.Completed++
+		} else if run.plan != nil && intent.Index < len(run.plan.Actions) {
+			run.Pending = append(run.Pending, run.plan.Actions[intent.Index].Path)
+		}
+	}
+	return run, nil
+}
+
+// checkInterrupted refuses to run while an interrupted run awaits recovery.
+func (e *Engine) checkInterrupted() error {
+	run, err := e.Interrupted()
+	if err != nil {
+		return err
+	}
+	if run != nil {
+		return &InterruptedRunError{Run: run}
+	}
+	return nil
+}
+
+// Recover rolls back or finishes the interrupted run. Files changed since
+// the run was interrupted are preserved in the history before they are
+// reverted. A rollback does not empty the trash: files the run removed stay
+// there as well as being put back.
+func (e *Engine) Recover(ctx context.Context, mode RecoverMode) (*RecoverResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	run, err := e.Interrupted()
+	if err != nil {
+		return nil, err
+	}
+	if run == nil {
+		return nil, nil
+	}
+
+	result := &RecoverResult{Mode: mode, Run: run}
+	switch mode {
+	case RecoverRollback:
+		for i := len(run.intents) - 1; i >= 0; i-- {
+			if err := e.revertIntent(run.intents[i], result); err != nil {
+				return nil, err
+			}
+		}
+		if err := e.finishJournal(); err != nil {
+			return nil, err
+		}
+		return result, nil
+
+	case RecoverFinish:
+		if run.plan == nil {
+			if err := e.finishJournal(); err != nil {
+				return nil, err
+			}
+			return result, nil
+		}
+		if err := e.validatePlan(run.plan); err != nil {
+			return nil, err
+		}
+		done := make(map[int]bool)
+		for _, intent := range run.intents {
+			if intent.Done {
+				done[intent.Index] = true
+			} else if err := e.revertIntent(intent, result); err != nil {
+				return nil, err
+			}
+		}
+
+		var stale []string
+		for i, action := range run.plan.Actions {
+			if done[i] {
+				continue
+			}
+			ok, err := actionIsCurrent(action)
+			if err != nil {
+				return nil, &OpError{Op: OpScan, Path: action.Path, Err: err}
+			}
+			if !ok {
+				stale = append(stale, action.Path)
+			}
+		}
+		if len(stale) > 0 {
+			return nil, &StalePlanError{Paths: stale}
+		}
+
+		result.Applied, err = e.run(ctx, run.plan, done)
+		if err != nil {
+			return nil, err
+		}
+		return result, nil
+	}
+	return nil, fmt.Errorf("unknown recovery mode %q", mode)
+}
+
+// revertIntent puts the files of a journaled action back to the content
+// they had before it started.
+func (e *Engine) revertIntent(intent journal.Intent, result *RecoverResult) error {
+	reverted := false
+	for i := len(intent.Files) - 1; i >= 0; i-- {
+		file := intent.Files[i]
+		current, err := currentHash(file.Path)
+		if err != nil {
+			return &OpError{Op: OpJournal, Path: file.Key, Err: err}
+		}
+		if current == file.Hash {
+			continue
+		}
+		if err := e.preserve(file.Key, file.Path, Resolution(file.Side), directionRecover, reasonRolledBack); err != nil {
+			return err
+		}
+		if file.Hash == "" {
+			if err := os.Remove(file.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return &OpError{Op: OpRemove, Path: file.Key, Err: err}
+			}
+		} else {
+			content, err := e.journal.Original(file.Hash)
+			if err != nil {
+				return &OpError{Op: OpJournal, Path: file.Key, Err: err}
+			}
+			if err := writeContent(file.Path, content); err != nil {
+				return &OpError{Op: OpCopy, Path: file.Key, Err: err}
+			}
+		}
+		reverted = true
+	}
+	if reverted && len(intent.Files) > 0 {
+		result.Reverted = append(result.Reverted, intent.Files[0].Key)
+	}
+	return nil
+}
+
+// beginJournal starts journaling a run of plan.
+func (e *Engine) beginJournal(plan *Plan) error {
+	if e.journal == nil {
+		return nil
+	}
+	err := e.journal.Begin(e.hostname, plan)
+	if errors.Is(err, journal.ErrActive) {
+		if err := e.checkInterrupted(); err != nil {
+			return err
+		}
+	}
+	if err != nil {
+		return &OpError{Op: OpJournal, Err: err}
+	}
+	return nil
+}
+
+// journalIntent records the files action index is about to touch together
+// with their original content.
+func (e *Engine) journalIntent(dir Direction, index int, action Action) error {
+	if e.journal == nil || action.Kind == ActionSkip {
+		return nil
+	}
+	targetSide := string(sourceSide(otherDirection(dir)))
+	// A copy renaming its target by case records the file under its old
+	// spelling only: on a case-insensitive file system both names refer to
+	// it, and rolling back must not remove it as a file the run created.
+	target := action.Target
+	if action.RenameFrom != "" {
+		target = action.RenameFrom
+	}
+	files := []journal.File{{Key: action.Path, Side: targetSide, Path: target}}
+	switch {
+	case action.Kind == ActionMerge:
+		files = append(files, journal.File{Key: action.Path, Side: string(sourceSide(dir)), Path: action.Source})
+	case action.Kind == ActionCopy && action.ConflictCopy != "":
+		files = append(files, journal.File{Key: action.Path, Side: targetSide, Path: action.ConflictCopy})
+	}
+	if err := e.journal.Intend(index, files); err != nil {
+		return &OpError{Op: OpJournal, Path: action.Path, Err: err}
+	}
+	return nil
+}
+
+func (e *Engine) journalDone(index int, action Action) error {
+	if e.journal == nil || action.Kind == ActionSkip {
+		return nil
+	}
+	if err := e.journal.Done(index); err != nil {
+		return &OpError{Op: OpJournal, Path: action.Path, Err: err}
+	}
+	return nil
+}
+
+func (e *Engine) finishJournal() error {
+	if e.journal == nil {
+		return nil
+	}
+	if err := e.journal.Finish(); err != nil {
+		return &OpError{Op: OpJournal, Err: err}
+	}
+	return nil
+}
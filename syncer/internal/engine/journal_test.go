@@ -0,0 +1,146 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nir414/pc-setup/syncer/internal/config"
+	"github.com/nir414/pc-setup/syncer/internal/journal"
+	"github.com/nir414/pc-setup/syncer/internal/state"
+)
+
+// cancelAfter cancels a run once it has applied n actions.
+type cancelAfter struct {
+	n      int
+	cancel context.CancelFunc
+}
+
+func (c *cancelAfter) Observe(event Event) {
+	if event.Kind == EventFileCopied || event.Kind == EventFileRemoved {
+		if c.n--; c.n == 0 {
+			c.cancel()
+		}
+	}
+}
+
+// interruptedBackup leaves a backup that removed a.ini and copied b.ini,
+// then was cut short while copying c.ini, with d.ini still to copy.
+func interruptedBackup(t *testing.T) (e *Engine, system, repo string) {
+	t.Helper()
+	root := t.TempDir()
+	system = filepath.Join(root, "sys", "app")
+	t.Setenv("APPDATA", filepath.Dir(system))
+	repo = filepath.Join(root, "SyncData", "APPDATA", "app")
+	for _, name := range []string{"a", "b", "c", "d"} {
+		writeFile(t, filepath.Join(system, name+".ini"), name+"1\n")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	journalDir := filepath.Join(root, ".syncer", "journal")
+	observer := &cancelAfter{cancel: cancel}
+	e = New(Options{
+		Root:          root,
+		Config:        &config.Config{SyncData: map[string]config.Section{"APPDATA": {Folders: []string{"app"}}}},
+		SnapshotStore: state.NewFileStore(filepath.Join(root, ".syncer", "state.json")),
+		Journal:       journal.New(journalDir),
+		Observer:      observer,
+	})
+	if _, err := e.Backup(context.Background(), RunOptions{}); err != nil {
+		t.Fatalf("initial Backup: %v", err)
+	}
+
+	if err := os.Remove(filepath.Join(system, "a.ini")); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"b", "c", "d"} {
+		writeFile(t, filepath.Join(system, name+".ini"), name+"2\n")
+	}
+	observer.n = 2
+	if _, err := e.Backup(ctx, RunOptions{}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Backup = %v, want it cancelled", err)
+	}
+	if err := journal.New(journalDir).Intend(2, []journal.File{{Key: "APPDATA/app/c.ini", Side: "repo", Path: filepath.Join(repo, "c.ini")}}); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(repo, "c.ini"), "c2 partly")
+	return e, system, repo
+}
+
+func checkFiles(t *testing.T, dir string, want map[string]string) {
+	t.Helper()
+	for name, content := range want {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if content == "" {
+			if !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("%s exists, want it removed", name)
+			}
+			continue
+		}
+		if err != nil || string(data) != content {
+			t.Errorf("%s = %q, %v; want %q", name, data, err, content)
+		}
+	}
+}
+
+func TestRecover(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("refuses changes but allows status", func(t *testing.T) {
+		e, _, _ := interruptedBackup(t)
+		var interrupted *InterruptedRunError
+		if _, err := e.Sync(ctx, RunOptions{}); !errors.As(err, &interrupted) {
+			t.Fatalf("Sync = %v, want an interrupted run error", err)
+		}
+		if interrupted.Run.Completed != 2 || len(interrupted.Run.Pending) != 1 || interrupted.Run.Pending[0] != "APPDATA/app/c.ini" {
+			t.Fatalf("interrupted run = %+v; want 2 done and c.ini pending", interrupted.Run)
+		}
+		if _, err := e.Status(ctx); err != nil {
+			t.Fatalf("Status: %v", err)
+		}
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		e, _, repo := interruptedBackup(t)
+		result, err := e.Recover(ctx, RecoverRollback)
+		if err != nil {
+			t.Fatalf("Recover: %v", err)
+		}
+		if len(result.Reverted) != 3 {
+			t.Fatalf("Reverted = %v; want a.ini, b.ini and c.ini", result.Reverted)
+		}
+		checkFiles(t, repo, map[string]string{"a.ini": "a1\n", "b.ini": "b1\n", "c.ini": "c1\n", "d.ini": "d1\n"})
+		if run, err := e.Interrupted(); run != nil || err != nil {
+			t.Fatalf("Interrupted after rollback = %+v, %v", run, err)
+		}
+	})
+
+	t.Run("finish", func(t *testing.T) {
+		e, _, repo := interruptedBackup(t)
+		result, err := e.Recover(ctx, RecoverFinish)
+		if err != nil {
+			t.Fatalf("Recover: %v", err)
+		}
+		if result.Applied == nil || result.Applied.CopiedFiles != 2 {
+			t.Fatalf("Applied = %+v; want c.ini and d.ini copied", result.Applied)
+		}
+		checkFiles(t, repo, map[string]string{"a.ini": "", "b.ini": "b2\n", "c.ini": "c2\n", "d.ini": "d2\n"})
+		report, err := e.Status(ctx)
+		if err != nil || len(report.Entries) != 0 {
+			t.Fatalf("Status after finish = %+v, %v; want up to date", report, err)
+		}
+	})
+
+	t.Run("torn begin record", func(t *testing.T) {
+		root := t.TempDir()
+		journalDir := filepath.Join(root, "journal")
+		writeFile(t, filepath.Join(journalDir, "journal.jsonl"), `{"kind":"beg`)
+		e := New(Options{Root: root, Config: &config.Config{}, Journal: journal.New(journalDir)})
+		if _, err := e.Recover(ctx, RecoverRollback); !errors.Is(err, journal.ErrCorrupt) {
+			t.Fatalf("Recover = %v, want a corrupt journal error", err)
+		}
+	})
+}
@@ -5,73 +5,189 @@ import (
 	"strings"
 )
 
+// matcher decides which section-relative paths are left out of a scan.
+// Excludes follow .gitignore rules: the last match wins, ! re-includes, and
+// nothing below an excluded directory is scanned. Includes are per-folder
+// allowlists matched relative to the folder.
 type matcher struct {
-	patterns []compiledPattern
+	excludes []rule
+	includes []includeSet
 }
 
-type compiledPattern struct {
-	pattern  string
+type rule struct {
+	segments []segment
+	negate   bool
 	dirOnly  bool
-	hasSlash bool
+	anchored bool
+}
+
+// segment is one slash-separated part of a pattern, compiled once.
+type segment struct {
+	pattern  string
+	literal  bool
+	globstar bool
 }
 
-func newMatcher(patterns []string) *matcher {
-	if len(patterns) == 0 {
-		return &matcher{}
+type includeSet struct {
+	folder string
+	rules  []rule
+}
+
+func newMatcher(excludes []string, includes map[string][]string) *matcher {
+	m := &matcher{excludes: parseRules(excludes)}
+	for folder, patterns := range includes {
+		folder = toForwardSlashes(normaliseFolder(folder))
+		if folder == "" {
+			continue
+		}
+		m.includes = append(m.includes, includeSet{folder: folder, rules: parseRules(patterns)})
 	}
-	compiled := make([]compiledPattern, 0, len(patterns))
+	return m
+}
+
+func parseRules(patterns []string) []rule {
+	rules := make([]rule, 0, len(patterns))
 	for _, raw := range patterns {
 		raw = strings.TrimSpace(raw)
-		if raw == "" {
-			continue
+		var r rule
+		if strings.HasPrefix(raw, "!") {
+			r.negate = true
+			raw = raw[1:]
+		}
+		raw = toForwardSlashes(raw)
+		if strings.HasSuffix(raw, "/") {
+			r.dirOnly = true
+			raw = strings.TrimRight(raw, "/")
+		}
+		if strings.HasPrefix(raw, "/") {
+			r.anchored = true
+			raw = strings.TrimLeft(raw, "/")
 		}
-		dirOnly := strings.HasSuffix(raw, "/") || strings.HasSuffix(raw, "\\")
-		raw = strings.TrimSuffix(strings.TrimSuffix(raw, "/"), "\\")
 		if raw == "" {
 			continue
 		}
-		normalized := toForwardSlashes(raw)
-		compiled = append(compiled, compiledPattern{
-			pattern:  normalized,
-			dirOnly:  dirOnly,
-			hasSlash: strings.Contains(normalized, "/"),
-		})
+		r.anchored = r.anchored || strings.Contains(raw, "/")
+		for _, part := range strings.Split(raw, "/") {
+			if part == "" {
+				continue
+			}
+			r.segments = append(r.segments, segment{
+				pattern:  part,
+				literal:  !strings.ContainsAny(part, `*?[\`),
+				globstar: part == "**",
+			})
+		}
+		rules = append(rules, r)
 	}
-	return &matcher{patterns: compiled}
+	return rules
 }
 
-func (m *matcher) ShouldSkip(sectionRelative string, isDir bool) bool {
-	if m == nil || len(m.patterns) == 0 {
+func (s segment) match(name string) bool {
+	if s.literal {
+		return s.pattern == name
+	}
+	matched, err := path.Match(s.pattern, name)
+	return err == nil && matched
+}
+
+// matches reports whether r matches the path split into segments.
+func (r rule) matches(segments []string, isDir bool) bool {
+	if r.dirOnly && !isDir {
 		return false
 	}
-	rel := toForwardSlashes(sectionRelative)
-	for _, p := range m.patterns {
-		candidate := rel
-		if !p.hasSlash {
-			candidate = path.Base(rel)
+	if !r.anchored {
+		return r.segments[0].match(segments[len(segments)-1])
+	}
+	return matchSegments(r.segments, segments)
+}
+
+// matchSegments matches names against pattern, where ** stands for any
+// number of directories. A trailing ** matches everything inside a
+// directory but not the directory itself, as in .gitignore.
+func matchSegments(pattern []segment, names []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0].globstar {
+			pattern = pattern[1:]
+			if len(pattern) == 0 {
+				return len(names) > 0
+			}
+			for i := range names {
+				if matchSegments(pattern, names[i:]) {
+					return true
+				}
+			}
+			return false
 		}
-		matched, err := path.Match(p.pattern, candidate)
-		if err != nil {
-			continue
+		if len(names) == 0 || !pattern[0].match(names[0]) {
+			return false
 		}
-		if matched && (!p.dirOnly || isDir) {
-			return true
+		pattern, names = pattern[1:], names[1:]
+	}
+	return len(names) == 0
+}
+
+// lastMatch reports whether the last rule matching segments is a positive
+// one, and whether any rule matched at all.
+func lastMatch(rules []rule, segments []string, isDir bool) (positive, matched bool) {
+	for _, r := range rules {
+		if r.matches(segments, isDir) {
+			positive, matched = !r.negate, true
 		}
-		if p.dirOnly {
-			current := path.Dir(rel)
-			for current != "." && current != "/" && current != "" {
-				target := current
-				if !p.hasSlash {
-					target = path.Base(current)
-				}
-				ok, err := path.Match(p.pattern, target)
-				if err == nil && ok {
-					return true
-				}
-				current = path.Dir(current)
+	}
+	return positive, matched
+}
+
+// ShouldSkip reports whether the section-relative path is left out, checking
+// the directories above it as well.
+func (m *matcher) ShouldSkip(sectionRelative string, isDir bool) bool {
+	return m.skip(sectionRelative, isDir, true)
+}
+
+// skipEntry is ShouldSkip for a walk that never descends into skipped
+// directories, so only the entry itself needs to be checked.
+func (m *matcher) skipEntry(sectionRelative string, isDir bool) bool {
+	return m.skip(sectionRelative, isDir, false)
+}
+
+func (m *matcher) skip(sectionRelative string, isDir, parents bool) bool {
+	if m == nil || len(m.excludes) == 0 && len(m.includes) == 0 {
+		return false
+	}
+	rel := strings.Trim(toForwardSlashes(sectionRelative), "/")
+	segments := strings.Split(rel, "/")
+	if parents {
+		for i := 1; i < len(segments); i++ {
+			if positive, _ := lastMatch(m.excludes, segments[:i], true); positive {
+				return true
 			}
 		}
 	}
+	if positive, _ := lastMatch(m.excludes, segments, isDir); positive {
+		return true
+	}
+	if isDir {
+		return false
+	}
+	for _, set := range m.includes {
+		if sub, ok := strings.CutPrefix(rel, set.folder+"/"); ok && !set.allows(strings.Split(sub, "/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// allows reports whether the file at segments, relative to the set's folder,
+// is included: by the last pattern matching the file itself, or else by the
+// nearest directory above it that a pattern matches.
+func (s includeSet) allows(segments []string) bool {
+	if positive, matched := lastMatch(s.rules, segments, false); matched {
+		return positive
+	}
+	for i := len(segments) - 1; i > 0; i-- {
+		if positive, matched := lastMatch(s.rules, segments[:i], true); matched {
+			return positive
+		}
+	}
 	return false
 }
 
@@ -3,7 +3,24 @@ package engine
 import "testing"
 
 func TestMatcher(t *testing.T) {
-	m := newMatcher([]string{"Notepad++/backup/", "*.log", "*/cache/"})
+	m := newMatcher([]string{
+		"Notepad++/backup/",
+		"*.log",
+		"*/cache/",
+		"*/tmp/",
+		"!keep.log",
+		"/top.txt",
+		"WinMerge/Temp/*",
+		"!WinMerge/Temp/keep.ini",
+		"!Notepad++/backup/keep.txt",
+		"session/",
+		"**/cache/",
+		"Notepad++/**/*.bak",
+		"lghub/**/logs/",
+		"Everything/**",
+	}, map[string][]string{
+		"Notepad++": {"*.ini", "*.xml", "plugins/Config/", "!secret.xml"},
+	})
 
 	cases := []struct {
 		path   string
@@ -17,6 +34,48 @@ func TestMatcher(t *testing.T) {
 		{"Any/cache/file.txt", false, true},
 		{"logs/app.log", false, true},
 		{"logs/app.txt", false, false},
+
+		// A later !pattern re-includes what an earlier pattern excluded.
+		{"logs/keep.log", false, false},
+		// A leading slash anchors to the section root.
+		{"top.txt", false, true},
+		{"Any/top.txt", false, false},
+		// A middle slash anchors too: */tmp/ is one level deep only.
+		{"Any/tmp", true, true},
+		{"Any/deeper/tmp", true, false},
+		// Files can be re-included from a directory whose contents, but not
+		// the directory itself, are excluded.
+		{"WinMerge/Temp", true, false},
+		{"WinMerge/Temp/scratch.txt", false, true},
+		{"WinMerge/Temp/keep.ini", false, false},
+		// Nothing below an excluded directory can be re-included.
+		{"Notepad++/backup/keep.txt", false, true},
+		// A trailing slash matches directories only.
+		{"Any/session", true, true},
+		{"Any/session", false, false},
+		// ** matches any number of directories, including none.
+		{"Notepad++/plugins/foo/cache", true, true},
+		{"cache", true, true},
+		{"Notepad++/old.bak", false, true},
+		{"Notepad++/plugins/foo/old.bak", false, true},
+		{"Other/old.bak", false, false},
+		{"lghub/logs", true, true},
+		{"lghub/a/b/logs/today.txt", false, true},
+		// A trailing ** matches the contents but not the directory itself.
+		{"Everything", true, false},
+		{"Everything/Everything.ini", false, true},
+
+		// Includes allow only matching files inside their folder, after
+		// excludes have been applied.
+		{"Notepad++/shortcuts.xml", false, false},
+		{"Notepad++/session.dat", false, true},
+		{"Notepad++/themes/dark.xml", false, false},
+		{"Notepad++/secret.xml", false, true},
+		{"Notepad++/plugins/Config/npp.dat", false, false},
+		{"Notepad++/plugins/other.dll", false, true},
+		{"Notepad++/plugins", true, false},
+		{"Notepad++/debug.log", false, true},
+		{"WinMerge/settings.dat", false, false},
 	}
 
 	for _, tc := range cases {
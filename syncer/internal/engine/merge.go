@@ -0,0 +1,112 @@
+package engine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+
+	"github.com/nir414/pc-setup/syncer/internal/config"
+)
+
+// mergeResult is the outcome of a three-way merge of a conflicted entry.
+type mergeResult struct {
+	Content   []byte
+	Conflicts int
+}
+
+// MergePreview returns the three-way merge of a conflicted entry, including
+// conflict markers for unresolved regions, together with the number of
+// such regions. It returns false when no merge can be attempted.
+func (e *Engine) MergePreview(entry DiffEntry) ([]byte, int, bool) {
+	result, ok, err := e.mergeEntry(entry)
+	if err != nil {
+		e.logger.Printf("warning: merge %s: %v", entry.Path, err)
+		return nil, 0, false
+	}
+	if !ok {
+		return nil, 0, false
+	}
+	return result.Content, result.Conflicts, true
+}
+
+// mergeEntry attempts a three-way merge of a conflicted entry against the
+// base content kept in the object store. It returns false when no merge can
+// be attempted: a side is missing, the base content is unknown, or any
+// version is binary.
+func (e *Engine) mergeEntry(entry DiffEntry) (mergeResult, bool, error) {
+	if entry.System == nil || entry.Repo == nil {
+		return mergeResult{}, false, nil
+	}
+	return e.mergeFiles(entry.Path, entry.BaseHash, entry.System.AbsPath, entry.Repo.AbsPath)
+}
+
+// mergeFiles merges the two sides of key with the driver configured for it.
+// A driver that cannot parse its input falls back to the text driver, and
+// unresolved conflicts are always presented as text conflict markers.
+func (e *Engine) mergeFiles(key, baseHash, systemPath, repoPath string) (mergeResult, bool, error) {
+	kind := e.mergeDriverFor(key)
+	if kind == config.MergeNone || baseHash == "" || !e.objects.Has(baseHash) {
+		return mergeResult{}, false, nil
+	}
+
+	base, err := e.objects.Get(baseHash)
+	if err != nil {
+		return mergeResult{}, false, err
+	}
+	system, err := os.ReadFile(systemPath)
+	if err != nil {
+		return mergeResult{}, false, err
+	}
+	repo, err := os.ReadFile(repoPath)
+	if err != nil {
+		return mergeResult{}, false, err
+	}
+	if isBinary(base) || isBinary(system) || isBinary(repo) {
+		return mergeResult{}, false, nil
+	}
+
+	content, conflicts, err := mergeDrivers[kind].Merge(base, system, repo)
+	if err != nil {
+		e.logger.Printf("warning: %s merge of %s failed, using text merge: %v", kind, key, err)
+		content, conflicts, _ = textDriver{}.Merge(base, system, repo)
+	} else if conflicts > 0 && kind != config.MergeText {
+		content, _, _ = textDriver{}.Merge(base, system, repo)
+	}
+	return mergeResult{Content: content, Conflicts: conflicts}, true, nil
+}
+
+// applyMerge recomputes the merge recorded in action and writes the result
+// to both sides.
+func (e *Engine) applyMerge(dir Direction, action Action) error {
+	systemPath, repoPath := action.Source, action.Target
+	if dir == DirectionSync {
+		systemPath, repoPath = action.Target, action.Source
+	}
+
+	result, ok, err := e.mergeFiles(action.Path, action.BaseHash, systemPath, repoPath)
+	if err != nil {
+		return &OpError{Op: OpCopy, Path: action.Path, Err: err}
+	}
+	if !ok || result.Conflicts > 0 || contentHash(result.Content) != action.ResultHash {
+		return &OpError{Op: OpCopy, Path: action.Path, Err: fmt.Errorf("merge result differs from plan")}
+	}
+
+	if err := e.preserve(action.Path, systemPath, ResolveSystem, string(dir), reasonMerged); err != nil {
+		return err
+	}
+	if err := e.preserve(action.Path, repoPath, ResolveRepo, string(dir), reasonMerged); err != nil {
+		return err
+	}
+	for _, target := range []string{systemPath, repoPath} {
+		if err := writeContent(target, result.Content); err != nil {
+			return &OpError{Op: OpCopy, Path: action.Path, Err: err}
+		}
+	}
+	return nil
+}
+
+func contentHash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,168 @@
+package engine
+
+import (
+	"sort"
+	"strings"
+)
+
+// changeHunk is a contiguous region of base lines [BaseStart, BaseEnd) that
+// one side replaced with its lines [Start, End).
+type changeHunk struct {
+	BaseStart int
+	BaseEnd   int
+	Start     int
+	End       int
+	Side      int
+}
+
+// mergeLabels names the sides in conflict markers.
+type mergeLabels struct {
+	Ours   string
+	Base   string
+	Theirs string
+}
+
+// changeHunks lists the regions of base that other changed, in base order.
+func changeHunks(base, other []string, side int) []changeHunk {
+	var hunks []changeHunk
+	var current *changeHunk
+	for _, edit := range diffLines(base, other) {
+		if edit.Kind == editEqual {
+			if current != nil {
+				hunks = append(hunks, *current)
+				current = nil
+			}
+			continue
+		}
+		if current == nil {
+			current = &changeHunk{BaseStart: edit.Old, BaseEnd: edit.Old, Start: edit.New, End: edit.New, Side: side}
+		}
+		if edit.Kind == editDelete {
+			current.BaseEnd = edit.Old + 1
+		} else {
+			current.End = edit.New + 1
+		}
+	}
+	if current != nil {
+		hunks = append(hunks, *current)
+	}
+	return hunks
+}
+
+// touches reports whether two base ranges overlap, treating an insertion
+// (empty range) as touching any range it borders.
+func touches(aStart, aEnd, bStart, bEnd int) bool {
+	if aStart < bEnd && bStart < aEnd {
+		return true
+	}
+	if aStart == aEnd && bStart <= aStart && aStart <= bEnd {
+		return true
+	}
+	if bStart == bEnd && aStart <= bStart && bStart <= aEnd {
+		return true
+	}
+	return false
+}
+
+// merge3 performs a diff3-style line merge of ours and theirs against their
+// common base. Changes made by only one side are taken as-is, identical
+// changes made by both sides are taken once, and overlapping differing
+// changes are emitted between conflict markers. It returns the merged lines
+// and the number of conflicting regions.
+func merge3(base, ours, theirs []string, labels mergeLabels) ([]string, int) {
+	hunks := append(changeHunks(base, ours, 0), changeHunks(base, theirs, 1)...)
+	sort.SliceStable(hunks, func(i, j int) bool {
+		if hunks[i].BaseStart != hunks[j].BaseStart {
+			return hunks[i].BaseStart < hunks[j].BaseStart
+		}
+		return hunks[i].BaseEnd < hunks[j].BaseEnd
+	})
+
+	newline := "\n"
+	for _, line := range base {
+		if strings.HasSuffix(line, "\r\n") {
+			newline = "\r\n"
+			break
+		}
+	}
+
+	var merged []string
+	conflicts := 0
+	basePos := 0
+	// offsets translate base positions outside any hunk into positions in
+	// ours and theirs.
+	offsets := [2]int{}
+	sides := [2][]string{ours, theirs}
+
+	for i := 0; i < len(hunks); {
+		regionStart, regionEnd := hunks[i].BaseStart, hunks[i].BaseEnd
+		j := i + 1
+		for j < len(hunks) && touches(regionStart, regionEnd, hunks[j].BaseStart, hunks[j].BaseEnd) {
+			regionEnd = max(regionEnd, hunks[j].BaseEnd)
+			j++
+		}
+
+		merged = append(merged, base[basePos:regionStart]...)
+
+		var changed [2]bool
+		var growth [2]int
+		for _, h := range hunks[i:j] {
+			changed[h.Side] = true
+			growth[h.Side] += (h.End - h.Start) - (h.BaseEnd - h.BaseStart)
+		}
+		var versions [2][]string
+		for side := range sides {
+			start := regionStart + offsets[side]
+			end := regionEnd + offsets[side] + growth[side]
+			versions[side] = sides[side][start:end]
+		}
+
+		switch {
+		case !changed[1]:
+			merged = append(merged, versions[0]...)
+		case !changed[0]:
+			merged = append(merged, versions[1]...)
+		case equalLines(versions[0], versions[1]):
+			merged = append(merged, versions[0]...)
+		default:
+			conflicts++
+			merged = append(merged, "<<<<<<< "+labels.Ours+newline)
+			merged = appendTerminated(merged, versions[0], newline)
+			merged = append(merged, "||||||| "+labels.Base+newline)
+			merged = appendTerminated(merged, base[regionStart:regionEnd], newline)
+			merged = append(merged, "======="+newline)
+			merged = appendTerminated(merged, versions[1], newline)
+			merged = append(merged, ">>>>>>> "+labels.Theirs+newline)
+		}
+
+		for side := range sides {
+			offsets[side] += growth[side]
+		}
+		basePos = regionEnd
+		i = j
+	}
+	merged = append(merged, base[basePos:]...)
+	return merged, conflicts
+}
+
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// appendTerminated appends lines, making sure the last one ends with a
+// newline so a following conflict marker starts on its own line.
+func appendTerminated(dst, lines []string, newline string) []string {
+	dst = append(dst, lines...)
+	if n := len(dst); len(lines) > 0 && !strings.HasSuffix(dst[n-1], "\n") {
+		dst[n-1] += newline
+	}
+	return dst
+}
@@ -0,0 +1,68 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMerge3(t *testing.T) {
+	labels := mergeLabels{Ours: "system", Base: "base", Theirs: "repo"}
+
+	cases := []struct {
+		name      string
+		base      string
+		ours      string
+		theirs    string
+		want      string
+		conflicts int
+	}{
+		{
+			"only ours changed",
+			"a\nb\nc\n", "a\nB\nc\n", "a\nb\nc\n",
+			"a\nB\nc\n", 0,
+		},
+		{
+			"disjoint changes",
+			"a\nb\nc\nd\ne\n", "A\nb\nc\nd\ne\n", "a\nb\nc\nd\nE\n",
+			"A\nb\nc\nd\nE\n", 0,
+		},
+		{
+			"identical changes",
+			"a\nb\n", "a\nx\n", "a\nx\n",
+			"a\nx\n", 0,
+		},
+		{
+			"insertions at different places",
+			"a\nb\nc\n", "a\nnew1\nb\nc\n", "a\nb\nc\nnew2\n",
+			"a\nnew1\nb\nc\nnew2\n", 0,
+		},
+		{
+			"deletion and distant edit",
+			"a\nb\nc\nd\n", "b\nc\nd\n", "a\nb\nc\nD\n",
+			"b\nc\nD\n", 0,
+		},
+		{
+			"conflicting edits",
+			"a\nb\nc\n", "a\nX\nc\n", "a\nY\nc\n",
+			"a\n<<<<<<< system\nX\n||||||| base\nb\n=======\nY\n>>>>>>> repo\nc\n", 1,
+		},
+		{
+			"conflicting insertions at the same place",
+			"a\n", "a\nx\n", "a\ny\n",
+			"a\n<<<<<<< system\nx\n||||||| base\n=======\ny\n>>>>>>> repo\n", 1,
+		},
+		{
+			"crlf markers",
+			"k=1\r\n", "k=2\r\n", "k=3\r\n",
+			"<<<<<<< system\r\nk=2\r\n||||||| base\r\nk=1\r\n=======\r\nk=3\r\n>>>>>>> repo\r\n", 1,
+		},
+	}
+
+	for _, tc := range cases {
+		merged, conflicts := merge3(splitLines(tc.base), splitLines(tc.ours), splitLines(tc.theirs), labels)
+		got := strings.Join(merged, "")
+		if got != tc.want || conflicts != tc.conflicts {
+			t.Fatalf("%s: merge3 = %q (%d conflicts), want %q (%d conflicts)", tc.name, got, conflicts, tc.want, tc.conflicts)
+		}
+	}
+}
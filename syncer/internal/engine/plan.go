@@ -0,0 +1,612 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nir414/pc-setup/syncer/internal/config"
+)
+
+// Direction identifies which side a run treats as the source of truth.
+type Direction string
+
+// Run directions.
+const (
+	DirectionBackup Direction = "backup"
+	DirectionSync   Direction = "sync"
+)
+
+// ActionKind enumerates the steps a plan can contain.
+type ActionKind string
+
+// Action kinds.
+const (
+	ActionCopy   ActionKind = "copy"
+	ActionDelete ActionKind = "delete"
+	ActionMerge  ActionKind = "merge"
+	ActionSkip   ActionKind = "skip"
+)
+
+// Action is a single planned step for one logical file. Source is empty for
+// deletions and Target is the path that would be written or removed.
+// SourceHash and TargetHash record the content expected at planning time;
+// an empty hash means the file is expected to be absent. ConflictCopy, when
+// set, is where the current Target content is preserved before it is
+// overwritten. Merge actions write the three-way merge of Source and Target
+// against the base content BaseHash, expected to hash to ResultHash, to
+// both sides. RenameFrom, when set, is the existing target file spelled with
+// a different case; a copy renames it to Target first.
+type Action struct {
+	Kind         ActionKind `json:"kind"`
+	Path         string     `json:"path"`
+	Status       DiffStatus `json:"status"`
+	Source       string     `json:"source,omitempty"`
+	Target       string     `json:"target,omitempty"`
+	SourceHash   string     `json:"source_hash,omitempty"`
+	TargetHash   string     `json:"target_hash,omitempty"`
+	ConflictCopy string     `json:"conflict_copy,omitempty"`
+	RenameFrom   string     `json:"rename_from,omitempty"`
+	BaseHash     string     `json:"base_hash,omitempty"`
+	ResultHash   string     `json:"result_hash,omitempty"`
+	Bytes        int64      `json:"bytes"`
+	Reason       string     `json:"reason"`
+}
+
+// Plan lists the actions a backup or sync run would perform, in key order.
+// Guard lists the folders and sections whose deletions exceed the configured
+// limits; such a plan only runs when deletions are explicitly allowed.
+type Plan struct {
+	Direction   Direction      `json:"direction"`
+	GeneratedAt time.Time      `json:"generated_at"`
+	Actions     []Action       `json:"actions"`
+	Guard       []GuardTrigger `json:"guard,omitempty"`
+}
+
+// RunOptions controls a backup or sync run.
+type RunOptions struct {
+	// AllowDeletes runs the plan even when it tripped the deletion guard.
+	AllowDeletes bool
+}
+
+// ApplyOptions controls how a previously computed plan is executed.
+type ApplyOptions struct {
+	// SkipStale skips actions whose files changed since planning instead of
+	// refusing the whole plan.
+	SkipStale bool
+	// AllowDeletes runs the plan even when it tripped the deletion guard.
+	AllowDeletes bool
+}
+
+// ApplyResult captures statistics from executing a plan. SkippedFiles counts
+// the entries left unresolved, such as conflicts; PendingFiles counts the
+// changes left for a run in the other direction.
+type ApplyResult struct {
+	Direction    Direction
+	CopiedFiles  int
+	CopiedBytes  int64
+	MergedFiles  int
+	RemovedFiles int
+	SkippedFiles int
+	PendingFiles int
+	StalePaths   []string
+}
+
+// StalePlanError reports plan actions whose files changed after planning.
+type StalePlanError struct {
+	Paths []string
+}
+
+func (e *StalePlanError) Error() string {
+	return fmt.Sprintf("plan is stale: %d action(s) changed since planning: %s",
+		len(e.Paths), strings.Join(e.Paths, ", "))
+}
+
+// Count returns the number of actions of the given kind.
+func (p *Plan) Count(kind ActionKind) int {
+	n := 0
+	for _, action := range p.Actions {
+		if action.Kind == kind {
+			n++
+		}
+	}
+	return n
+}
+
+// Plan computes the actions a run in the given direction would perform
+// without touching any file.
+func (e *Engine) Plan(ctx context.Context, dir Direction) (*Plan, error) {
+	if dir != DirectionBackup && dir != DirectionSync {
+		return nil, fmt.Errorf("unknown plan direction %q", dir)
+	}
+
+	_, diff, err := e.computeDiff(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &Plan{
+		Direction:   dir,
+		GeneratedAt: time.Now().UTC(),
+	}
+	for _, entry := range diff.Entries {
+		if entry.Status == DiffStatusUpToDate {
+			continue
+		}
+		action := e.planEntry(plan, entry)
+		plan.Actions = append(plan.Actions, action)
+		e.emit(Event{Kind: EventActionPlanned, Direction: dir, Path: action.Path, Action: action.Kind,
+			Bytes: action.Bytes, Done: len(plan.Actions)})
+	}
+	plan.Guard = e.checkDeletions(plan, diff)
+	return plan, nil
+}
+
+func (e *Engine) planEntry(plan *Plan, entry DiffEntry) Action {
+	action := Action{
+		Kind:   ActionSkip,
+		Path:   entry.Path,
+		Status: entry.Status,
+	}
+
+	if collisions := entry.collisions(); len(collisions) > 0 {
+		action.Reason = fmt.Sprintf("paths differ only by case on %s: %s", collisions[0].Side, strings.Join(collisions[0].Paths, ", "))
+		return action
+	}
+
+	dir := plan.Direction
+	source, target := entry.SystemPath, entry.RepoPath
+	sourceInfo, targetInfo := entry.System, entry.Repo
+	if dir == DirectionSync {
+		source, target = entry.RepoPath, entry.SystemPath
+		sourceInfo, targetInfo = entry.Repo, entry.System
+	}
+
+	if entry.Status != DiffStatusConflict {
+		if !ownedBy(dir, entry.Status) {
+			action.Reason = pendingReason(dir)
+			return action
+		}
+		action.Reason = statusReason(entry.Status)
+		if entry.Renamed == Side(sourceSide(dir)) {
+			action.Reason = fmt.Sprintf("renamed on %s", entry.Renamed)
+			if sourceInfo.Hash != targetInfo.Hash {
+				action.Reason += "; " + statusReason(entry.Status)
+			}
+		}
+		if setTransfer(&action, source, target, sourceInfo, targetInfo) {
+			e.renameTarget(&action, dir, sourceInfo, targetInfo)
+		}
+		return action
+	}
+
+	merge, merged, err := e.mergeEntry(entry)
+	if err != nil {
+		e.logger.Printf("warning: merge %s: %v", entry.Path, err)
+	}
+	if merged && merge.Conflicts == 0 {
+		action.Kind = ActionMerge
+		action.Source = source
+		action.Target = target
+		action.SourceHash = sourceInfo.Hash
+		action.TargetHash = targetInfo.Hash
+		action.BaseHash = entry.BaseHash
+		action.ResultHash = contentHash(merge.Content)
+		action.Bytes = int64(len(merge.Content))
+		action.Reason = "merged changes from both sides"
+		return action
+	}
+
+	strategy := config.ConflictSkip
+	if section := e.sectionFor(entry.Path); section != nil && section.OnConflict != "" {
+		strategy = section.OnConflict
+	}
+	winner, ok := conflictWinner(strategy, entry)
+	switch {
+	case !ok && merged:
+		action.Reason = fmt.Sprintf("%s; three-way merge left %d conflicting region(s)", statusReason(entry.Status), merge.Conflicts)
+	case !ok:
+		action.Reason = statusReason(entry.Status)
+	case winner != sourceSide(dir):
+		action.Reason = fmt.Sprintf("conflict won by %s (on_conflict=%s); pending %s", winner, strategy, otherDirection(dir))
+	default:
+		action.Reason = fmt.Sprintf("conflict won by %s (on_conflict=%s)", winner, strategy)
+		if setTransfer(&action, source, target, sourceInfo, targetInfo) {
+			e.renameTarget(&action, dir, sourceInfo, targetInfo)
+			if strategy == config.ConflictKeepBoth && action.Kind == ActionCopy && targetInfo != nil {
+				action.ConflictCopy = conflictCopyPath(action.Target, e.hostname, plan.GeneratedAt)
+			}
+		}
+	}
+	return action
+}
+
+// setTransfer turns action into a copy from source to target, or into a
+// deletion of target when the source side is absent. It leaves action as a
+// skip and returns false when the paths cannot be resolved.
+func setTransfer(action *Action, source, target string, sourceInfo, targetInfo *FileInfo) bool {
+	if target == "" || (sourceInfo != nil && source == "") {
+		action.Reason = "path could not be resolved"
+		return false
+	}
+	action.Target = target
+	action.TargetHash = infoHash(targetInfo)
+	if sourceInfo == nil {
+		action.Kind = ActionDelete
+		return true
+	}
+	action.Kind = ActionCopy
+	action.Source = source
+	action.SourceHash = sourceInfo.Hash
+	action.Bytes = sourceInfo.Size
+	return true
+}
+
+// renameTarget makes a copy whose source and target spell the path with
+// different case write the source spelling, renaming the target first.
+func (e *Engine) renameTarget(action *Action, dir Direction, sourceInfo, targetInfo *FileInfo) {
+	if action.Kind != ActionCopy || sourceInfo == nil || targetInfo == nil || sourceInfo.Path == targetInfo.Path {
+		return
+	}
+	systemPath, repoPath, ok := e.resolvePaths(sourceInfo.Path)
+	if !ok {
+		return
+	}
+	action.Path = sourceInfo.Path
+	action.RenameFrom = action.Target
+	action.Target = repoPath
+	if dir == DirectionSync {
+		action.Target = systemPath
+	}
+}
+
+// conflictWinner picks the side that wins a conflict under strategy. It
+// returns false when the conflict should be left alone.
+func conflictWinner(strategy config.ConflictStrategy, entry DiffEntry) (Resolution, bool) {
+	switch strategy {
+	case config.ConflictSystem:
+		return ResolveSystem, true
+	case config.ConflictRepo:
+		return ResolveRepo, true
+	case config.ConflictNewest, config.ConflictKeepBoth:
+		switch {
+		case entry.System == nil && entry.Repo == nil:
+			return "", false
+		case entry.Repo == nil:
+			return ResolveSystem, true
+		case entry.System == nil:
+			return ResolveRepo, true
+		case entry.System.ModTime.After(entry.Repo.ModTime):
+			return ResolveSystem, true
+		case entry.Repo.ModTime.After(entry.System.ModTime):
+			return ResolveRepo, true
+		}
+	}
+	return "", false
+}
+
+// sourceSide returns the side a run in direction dir copies from.
+func sourceSide(dir Direction) Resolution {
+	if dir == DirectionSync {
+		return ResolveRepo
+	}
+	return ResolveSystem
+}
+
+func otherDirection(dir Direction) Direction {
+	if dir == DirectionSync {
+		return DirectionBackup
+	}
+	return DirectionSync
+}
+
+// conflictCopyPath names the file that preserves the losing side of a
+// keep-both conflict next to target.
+func conflictCopyPath(target, host string, at time.Time) string {
+	host = strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ':' || r == ' ' {
+			return '_'
+		}
+		return r
+	}, host)
+	return fmt.Sprintf("%s.conflict-%s-%s", target, host, at.UTC().Format("20060102T150405Z"))
+}
+
+func infoHash(info *FileInfo) string {
+	if info == nil {
+		return ""
+	}
+	return info.Hash
+}
+
+// ownedBy reports whether a change with the given status is applied by a run
+// in direction dir.
+func ownedBy(dir Direction, status DiffStatus) bool {
+	switch status {
+	case DiffStatusSystemAdded, DiffStatusSystemModified, DiffStatusSystemDeleted:
+		return dir == DirectionBackup
+	case DiffStatusRepoAdded, DiffStatusRepoModified, DiffStatusRepoDeleted:
+		return dir == DirectionSync
+	}
+	return false
+}
+
+func pendingReason(dir Direction) string {
+	if dir == DirectionBackup {
+		return "repository change pending sync"
+	}
+	return "system change pending backup"
+}
+
+func statusReason(status DiffStatus) string {
+	switch status {
+	case DiffStatusSystemAdded:
+		return "added on system"
+	case DiffStatusSystemModified:
+		return "modified on system"
+	case DiffStatusSystemDeleted:
+		return "deleted on system"
+	case DiffStatusRepoAdded:
+		return "added in repository"
+	case DiffStatusRepoModified:
+		return "modified in repository"
+	case DiffStatusRepoDeleted:
+		return "deleted from repository"
+	case DiffStatusConflict:
+		return "changed on both sides"
+	}
+	return string(status)
+}
+
+// Apply executes a plan produced earlier, possibly by another process. Every
+// action must still resolve to the same files under the current
+// configuration, and files must still have the hashes recorded at planning
+// time; otherwise the plan is refused or, with SkipStale, the affected
+// actions are skipped. The deletion guard is evaluated again rather than
+// taken from the plan.
+func (e *Engine) Apply(ctx context.Context, plan *Plan, opts ApplyOptions) (*ApplyResult, error) {
+	if err := e.checkInterrupted(); err != nil {
+		return nil, err
+	}
+	if err := e.validatePlan(plan); err != nil {
+		return nil, err
+	}
+	if !opts.AllowDeletes {
+		// The plan's own Guard may be missing or edited, so the deletions are
+		// checked again against the current scan.
+		_, diff, err := e.computeDiff(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if triggers := e.checkDeletions(plan, diff); len(triggers) > 0 {
+			return nil, &DeletionGuardError{Triggers: triggers}
+		}
+	}
+
+	var stale []string
+	for i, action := range plan.Actions {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		ok, err := actionIsCurrent(action)
+		if err != nil {
+			return nil, &OpError{Op: OpScan, Path: action.Path, Err: err}
+		}
+		if ok {
+			continue
+		}
+		stale = append(stale, action.Path)
+		if opts.SkipStale {
+			plan.Actions[i].Kind = ActionSkip
+			plan.Actions[i].Reason = "changed since planning"
+		}
+	}
+	if len(stale) > 0 && !opts.SkipStale {
+		return nil, &StalePlanError{Paths: stale}
+	}
+
+	result, err := e.execute(ctx, plan)
+	if err != nil {
+		return nil, err
+	}
+	result.StalePaths = stale
+	return result, nil
+}
+
+func (e *Engine) validatePlan(plan *Plan) error {
+	if plan == nil {
+		return fmt.Errorf("%w: empty plan", ErrInvalidPlan)
+	}
+	if plan.Direction != DirectionBackup && plan.Direction != DirectionSync {
+		return fmt.Errorf("%w: unknown direction %q", ErrInvalidPlan, plan.Direction)
+	}
+	for _, action := range plan.Actions {
+		if action.Kind == ActionSkip {
+			continue
+		}
+		if action.Kind != ActionCopy && action.Kind != ActionDelete && action.Kind != ActionMerge {
+			return fmt.Errorf("%w: unknown action %q for %s", ErrInvalidPlan, action.Kind, action.Path)
+		}
+		systemPath, repoPath, ok := e.resolvePaths(action.Path)
+		if !ok {
+			return fmt.Errorf("%w: %s is not tracked by the current configuration", ErrInvalidPlan, action.Path)
+		}
+		source, target := systemPath, repoPath
+		if plan.Direction == DirectionSync {
+			source, target = repoPath, systemPath
+		}
+		if action.Kind == ActionDelete {
+			source = ""
+		}
+		// Merges of a file spelled differently on the two sides write each
+		// side under its own spelling.
+		samePath := func(path, want string) bool { return filepath.Clean(path) == want }
+		if section := e.sectionFor(action.Path); section != nil && section.FoldCase {
+			samePath = func(path, want string) bool { return strings.EqualFold(filepath.Clean(path), want) }
+		}
+		if !samePath(action.Target, target) || (source != "" && !samePath(action.Source, source)) {
+			return fmt.Errorf("%w: %s resolves to different files on this machine", ErrInvalidPlan, action.Path)
+		}
+		if action.RenameFrom != "" && (action.Kind != ActionCopy ||
+			!strings.EqualFold(filepath.Clean(action.RenameFrom), target) || filepath.Clean(action.RenameFrom) == target) {
+			return fmt.Errorf("%w: %s has an unexpected rename", ErrInvalidPlan, action.Path)
+		}
+		if action.ConflictCopy != "" &&
+			(filepath.Dir(action.ConflictCopy) != filepath.Dir(target) ||
+				!strings.HasPrefix(filepath.Base(action.ConflictCopy), filepath.Base(target)+".conflict-")) {
+			return fmt.Errorf("%w: %s has an unexpected conflict copy path", ErrInvalidPlan, action.Path)
+		}
+	}
+	return nil
+}
+
+// actionIsCurrent reports whether the files touched by action still match
+// the hashes recorded when it was planned.
+func actionIsCurrent(action Action) (bool, error) {
+	if action.Kind == ActionSkip {
+		return true, nil
+	}
+	if action.Kind == ActionCopy || action.Kind == ActionMerge {
+		hash, err := currentHash(action.Source)
+		if err != nil || hash != action.SourceHash {
+			return false, err
+		}
+	}
+	target := action.Target
+	if action.RenameFrom != "" {
+		target = action.RenameFrom
+	}
+	hash, err := currentHash(target)
+	if err != nil {
+		return false, err
+	}
+	return hash == action.TargetHash, nil
+}
+
+func currentHash(path string) (string, error) {
+	hash, err := hashFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	return hash, err
+}
+
+// execute applies plan in order, journaling the run, and records the
+// reconciled entries in the base snapshot.
+func (e *Engine) execute(ctx context.Context, plan *Plan) (*ApplyResult, error) {
+	if err := e.beginJournal(plan); err != nil {
+		return nil, err
+	}
+	return e.run(ctx, plan, nil)
+}
+
+// run applies the actions of a journaled plan, except those in done, and
+// closes the journal once the snapshot is saved.
+func (e *Engine) run(ctx context.Context, plan *Plan, done map[int]bool) (*ApplyResult, error) {
+	progress := Event{Direction: plan.Direction}
+	for i, action := range plan.Actions {
+		if !done[i] {
+			progress.Total++
+			progress.TotalBytes += action.Bytes
+		}
+	}
+	progress.Kind = EventRunStarted
+	e.emit(progress)
+
+	result := &ApplyResult{Direction: plan.Direction}
+	for i, action := range plan.Actions {
+		if done[i] {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err := e.journalIntent(plan.Direction, i, action); err != nil {
+			return nil, err
+		}
+		if err := e.applyAction(plan.Direction, action); err != nil {
+			return nil, err
+		}
+		if err := e.journalDone(i, action); err != nil {
+			return nil, err
+		}
+		switch action.Kind {
+		case ActionCopy:
+			result.CopiedFiles++
+			result.CopiedBytes += action.Bytes
+			progress.Kind = EventFileCopied
+		case ActionMerge:
+			result.MergedFiles++
+			progress.Kind = EventFileMerged
+		case ActionDelete:
+			result.RemovedFiles++
+			progress.Kind = EventFileRemoved
+		case ActionSkip:
+			if action.Status != DiffStatusConflict && !ownedBy(plan.Direction, action.Status) {
+				result.PendingFiles++
+			} else {
+				result.SkippedFiles++
+			}
+			progress.Kind = EventFileSkipped
+		}
+		progress.Done++
+		progress.DoneBytes += action.Bytes
+		progress.Path, progress.Action, progress.Bytes = action.Path, action.Kind, action.Bytes
+		e.emit(progress)
+	}
+
+	if err := e.updateSnapshot(ctx, plan); err != nil {
+		return nil, err
+	}
+
+	if err := e.finishJournal(); err != nil {
+		return nil, err
+	}
+	progress.Kind = EventRunFinished
+	progress.Path, progress.Action, progress.Bytes = "", "", 0
+	e.emit(progress)
+	return result, nil
+}
+
+// applyAction performs a single step of a plan in direction dir. Skip
+// actions are no-ops.
+func (e *Engine) applyAction(dir Direction, action Action) error {
+	targetSide := sourceSide(otherDirection(dir))
+	switch action.Kind {
+	case ActionMerge:
+		return e.applyMerge(dir, action)
+	case ActionCopy:
+		current := action.Target
+		if action.RenameFrom != "" {
+			current = action.RenameFrom
+		}
+		if err := e.preserve(action.Path, current, targetSide, string(dir), reasonOverwritten); err != nil {
+			return err
+		}
+		if action.ConflictCopy != "" {
+			if err := e.copyFile(current, action.ConflictCopy); err != nil {
+				return &OpError{Op: OpCopy, Path: action.Path, Err: err}
+			}
+		}
+		if action.RenameFrom != "" {
+			if err := renameCase(action.RenameFrom, action.Target); err != nil {
+				return &OpError{Op: OpCopy, Path: action.Path, Err: err}
+			}
+		}
+		if err := e.copyFile(action.Source, action.Target); err != nil {
+			return &OpError{Op: OpCopy, Path: action.Path, Err: err}
+		}
+	case ActionDelete:
+		if err := e.preserve(action.Path, action.Target, targetSide, string(dir), reasonDeleted); err != nil {
+			return err
+		}
+		if err := e.discard(action, targetSide, string(dir)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
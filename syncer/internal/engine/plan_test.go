@@ -0,0 +1,72 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/nir414/pc-setup/syncer/internal/config"
+	"github.com/nir414/pc-setup/syncer/internal/state"
+)
+
+func TestApplyStalePlan(t *testing.T) {
+	root := t.TempDir()
+	system := filepath.Join(root, "sys")
+	t.Setenv("APPDATA", system)
+	repo := filepath.Join(root, "SyncData", "APPDATA")
+	writeFile(t, filepath.Join(system, "app", "a.ini"), "a\n")
+	writeFile(t, filepath.Join(system, "app", "b.ini"), "b\n")
+
+	e := New(Options{
+		Root:          root,
+		Config:        &config.Config{SyncData: map[string]config.Section{"APPDATA": {Folders: []string{"app"}}}},
+		SnapshotStore: state.NewFileStore(filepath.Join(root, ".syncer", "state.json")),
+	})
+	ctx := context.Background()
+	planned, err := e.Plan(ctx, DirectionBackup)
+	if err != nil {
+		t.Fatalf("Plan: %v", err)
+	}
+	saved, err := json.Marshal(planned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	load := func() *Plan {
+		var plan Plan
+		if err := json.Unmarshal(saved, &plan); err != nil {
+			t.Fatal(err)
+		}
+		return &plan
+	}
+	writeFile(t, filepath.Join(system, "app", "b.ini"), "b changed\n")
+
+	var stale *StalePlanError
+	if _, err := e.Apply(ctx, load(), ApplyOptions{}); !errors.As(err, &stale) {
+		t.Fatalf("Apply = %v, want a stale plan error", err)
+	}
+	if !reflect.DeepEqual(stale.Paths, []string{"APPDATA/app/b.ini"}) {
+		t.Fatalf("stale paths = %v, want b.ini", stale.Paths)
+	}
+	if _, err := os.Stat(filepath.Join(repo, "app", "a.ini")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("refused plan still copied a.ini: %v", err)
+	}
+
+	result, err := e.Apply(ctx, load(), ApplyOptions{SkipStale: true})
+	if err != nil {
+		t.Fatalf("Apply with SkipStale: %v", err)
+	}
+	if result.CopiedFiles != 1 || !reflect.DeepEqual(result.StalePaths, []string{"APPDATA/app/b.ini"}) {
+		t.Fatalf("Apply = %+v; want a.ini copied and b.ini skipped as stale", result)
+	}
+	if _, err := os.Stat(filepath.Join(repo, "app", "b.ini")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("stale action for b.ini was applied: %v", err)
+	}
+	report, err := e.Status(ctx)
+	if err != nil || len(report.Entries) != 1 || report.Entries[0].Path != "APPDATA/app/b.ini" {
+		t.Fatalf("Status = %+v, %v; want only b.ini pending", report, err)
+	}
+}
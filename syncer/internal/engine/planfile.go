@@ -0,0 +1,40 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// planFileVersion is the format version written by WritePlan.
+const planFileVersion = 1
+
+// ErrInvalidPlan is returned when a saved plan cannot be read or does not
+// fit the current configuration.
+var ErrInvalidPlan = errors.New("invalid plan")
+
+type planFile struct {
+	Version int `json:"version"`
+	Plan
+}
+
+// WritePlan serialises plan so it can be reviewed and applied later.
+func WritePlan(w io.Writer, plan *Plan) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(planFile{Version: planFileVersion, Plan: *plan})
+}
+
+// ReadPlan decodes a plan written by WritePlan.
+func ReadPlan(r io.Reader) (*Plan, error) {
+	var file planFile
+	if err := json.NewDecoder(r).Decode(&file); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPlan, err)
+	}
+	if file.Version != planFileVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidPlan, file.Version)
+	}
+	plan := file.Plan
+	return &plan, nil
+}
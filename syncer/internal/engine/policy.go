@@ -0,0 +1,90 @@
+package engine
+
+import (
+	"io"
+	"os"
+	"sort"
+
+	"github.com/nir414/pc-setup/syncer/internal/state"
+)
+
+// PolicySkip is a file left out of a scan by max_file_size or skip_binary.
+// Its key is not synced in either direction, and its base is kept, until the
+// file fits the limits again.
+type PolicySkip struct {
+	Path string
+	Side Side
+	// Rule names the setting that excluded the file.
+	Rule string
+	Size int64
+}
+
+// sizeRule returns the rule that leaves a file of the given size out of
+// folder, or "" if max_file_size allows it.
+func (f folderSpec) sizeRule(size int64) string {
+	if f.MaxFileSize > 0 && size > int64(f.MaxFileSize) {
+		return "max_file_size = " + f.MaxFileSize.String()
+	}
+	return ""
+}
+
+// sniffBinary reports whether file is binary, trusting the verdict of a
+// cached record.
+func sniffBinary(file scannedFile, record state.FileRecord, cached bool) (bool, error) {
+	if cached && record.Binary != nil {
+		return *record.Binary, nil
+	}
+	return isBinaryFile(file.path)
+}
+
+// isBinaryFile reports whether the file at path is binary, judged by isBinary
+// from its first bytes.
+func isBinaryFile(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	head := make([]byte, binarySniffLen)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false, err
+	}
+	return isBinary(head[:n]), nil
+}
+
+// splitPolicySkips separates the files left out by a file limit from those
+// to hash.
+func splitPolicySkips(files []scannedFile, side Side) ([]scannedFile, []PolicySkip) {
+	kept := files[:0]
+	var skipped []PolicySkip
+	for _, file := range files {
+		if file.rule == "" {
+			kept = append(kept, file)
+			continue
+		}
+		skipped = append(skipped, PolicySkip{Path: file.key, Side: side, Rule: file.rule, Size: file.size})
+	}
+	return kept, skipped
+}
+
+// dropPolicySkips removes the keys of skipped files from both sides and sorts
+// skipped by path.
+func (e *Engine) dropPolicySkips(skipped []PolicySkip, systemFiles, repoFiles fileMap) {
+	for _, skip := range skipped {
+		fold := e.foldKey(skip.Path)
+		delete(systemFiles, fold)
+		delete(repoFiles, fold)
+	}
+	sort.SliceStable(skipped, func(i, j int) bool { return skipped[i].Path < skipped[j].Path })
+}
+
+// policySkipped returns the folded keys of skipped.
+func (e *Engine) policySkipped(skipped []PolicySkip) map[string]bool {
+	keys := make(map[string]bool, len(skipped))
+	for _, skip := range skipped {
+		keys[e.foldKey(skip.Path)] = true
+	}
+	return keys
+}
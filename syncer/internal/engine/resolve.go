@@ -0,0 +1,149 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+)
+
+// Resolution names the side that wins when a conflict is settled.
+type Resolution string
+
+// Conflict resolutions.
+const (
+	ResolveSystem Resolution = "system"
+	ResolveRepo   Resolution = "repo"
+)
+
+// Conflicts returns the conflicted entries whose path matches one of the
+// given prefixes. An empty prefix list selects every conflict.
+func (e *Engine) Conflicts(ctx context.Context, prefixes []string) ([]DiffEntry, error) {
+	report, err := e.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var conflicts []DiffEntry
+	for _, entry := range report.Entries {
+		if entry.Status == DiffStatusConflict && matchesPrefix(entry.Path, prefixes) {
+			conflicts = append(conflicts, entry)
+		}
+	}
+	return conflicts, nil
+}
+
+// ConflictDiff renders the content difference of a conflicted entry.
+func (e *Engine) ConflictDiff(entry DiffEntry) (FileDiff, error) {
+	return contentDiff(entry)
+}
+
+// Resolve settles a conflict by copying the winning side over the other one
+// (or removing the other one when the winner is absent) and records the
+// result as the new base so the entry is no longer reported as a conflict.
+func (e *Engine) Resolve(ctx context.Context, entry DiffEntry, take Resolution) error {
+	if err := e.checkInterrupted(); err != nil {
+		return err
+	}
+	var action Action
+	dir := DirectionBackup
+	sourceInfo, targetInfo := entry.System, entry.Repo
+	switch take {
+	case ResolveSystem:
+		action = Action{Path: entry.Path, Source: entry.SystemPath, Target: entry.RepoPath,
+			SourceHash: infoHash(entry.System), TargetHash: infoHash(entry.Repo)}
+	case ResolveRepo:
+		dir = DirectionSync
+		sourceInfo, targetInfo = entry.Repo, entry.System
+		action = Action{Path: entry.Path, Source: entry.RepoPath, Target: entry.SystemPath,
+			SourceHash: infoHash(entry.Repo), TargetHash: infoHash(entry.System)}
+	default:
+		return fmt.Errorf("unknown resolution %q", take)
+	}
+	if action.Source == "" || action.Target == "" {
+		return fmt.Errorf("resolve %s: path could not be resolved", entry.Path)
+	}
+
+	action.Kind = ActionCopy
+	if action.SourceHash == "" {
+		action.Kind = ActionDelete
+		action.Source = ""
+	}
+	e.renameTarget(&action, dir, sourceInfo, targetInfo)
+
+	current, err := actionIsCurrent(action)
+	if err != nil {
+		return &OpError{Op: OpScan, Path: entry.Path, Err: err}
+	}
+	if !current {
+		return &StalePlanError{Paths: []string{entry.Path}}
+	}
+
+	if err := e.applyAction(dir, action); err != nil {
+		return err
+	}
+
+	if action.Kind == ActionDelete {
+		return e.recordBase(ctx, action.Path, "")
+	}
+	return e.recordBase(ctx, action.Path, action.Target)
+}
+
+// ResolveContent settles a conflict by writing content to both sides, e.g.
+// after the user edited the file by hand. It refuses when either side
+// changed since entry was read.
+func (e *Engine) ResolveContent(ctx context.Context, entry DiffEntry, content []byte) error {
+	if err := e.checkInterrupted(); err != nil {
+		return err
+	}
+	if entry.SystemPath == "" || entry.RepoPath == "" {
+		return fmt.Errorf("resolve %s: path could not be resolved", entry.Path)
+	}
+	for path, info := range map[string]*FileInfo{entry.SystemPath: entry.System, entry.RepoPath: entry.Repo} {
+		hash, err := currentHash(path)
+		if err != nil {
+			return &OpError{Op: OpScan, Path: entry.Path, Err: err}
+		}
+		if hash != infoHash(info) {
+			return &StalePlanError{Paths: []string{entry.Path}}
+		}
+	}
+	if err := e.preserve(entry.Path, entry.SystemPath, ResolveSystem, directionResolve, reasonOverwritten); err != nil {
+		return err
+	}
+	if err := e.preserve(entry.Path, entry.RepoPath, ResolveRepo, directionResolve, reasonOverwritten); err != nil {
+		return err
+	}
+	for _, target := range []string{entry.SystemPath, entry.RepoPath} {
+		if err := writeContent(target, content); err != nil {
+			return &OpError{Op: OpCopy, Path: entry.Path, Err: err}
+		}
+	}
+	return e.recordBase(ctx, entry.Path, entry.SystemPath)
+}
+
+// recordBase stores the current content of path as the base of key in the
+// snapshot, replacing records of key spelled with a different case. An empty
+// path removes the key from the snapshot.
+func (e *Engine) recordBase(ctx context.Context, key, path string) error {
+	snapshot, err := e.store.Load(ctx)
+	if err != nil {
+		return &OpError{Op: OpLoadSnapshot, Err: err}
+	}
+
+	if path == "" {
+		for existing := range snapshot.Files {
+			if e.foldKey(existing) == e.foldKey(key) {
+				delete(snapshot.Files, existing)
+			}
+		}
+	} else {
+		if err := e.setBase(snapshot, key, path); err != nil {
+			return err
+		}
+		e.pruneCaseVariants(snapshot, map[string]bool{key: true})
+	}
+
+	if err := e.store.Save(ctx, snapshot); err != nil {
+		return &OpError{Op: OpSaveSnapshot, Err: err}
+	}
+	return nil
+}
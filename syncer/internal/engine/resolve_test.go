@@ -0,0 +1,81 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/nir414/pc-setup/syncer/internal/config"
+	"github.com/nir414/pc-setup/syncer/internal/state"
+)
+
+func TestResolve(t *testing.T) {
+	ctx := context.Background()
+	// conflicted returns an engine whose a.ini changed on both sides, the
+	// conflict entry, and the system and repo folders.
+	conflicted := func(t *testing.T) (*Engine, DiffEntry, string, string) {
+		t.Helper()
+		root := t.TempDir()
+		system := filepath.Join(root, "sys")
+		t.Setenv("APPDATA", system)
+		repo := filepath.Join(root, "SyncData", "APPDATA")
+		writeFile(t, filepath.Join(system, "app", "a.ini"), "k=1\n")
+		e := New(Options{
+			Root:          root,
+			Config:        &config.Config{SyncData: map[string]config.Section{"APPDATA": {Folders: []string{"app"}}}},
+			SnapshotStore: state.NewFileStore(filepath.Join(root, ".syncer", "state.json")),
+		})
+		if _, err := e.Backup(ctx, RunOptions{}); err != nil {
+			t.Fatalf("Backup: %v", err)
+		}
+		writeFile(t, filepath.Join(system, "app", "a.ini"), "k=2\n")
+		writeFile(t, filepath.Join(repo, "app", "a.ini"), "k=3\n")
+		conflicts, err := e.Conflicts(ctx, nil)
+		if err != nil || len(conflicts) != 1 {
+			t.Fatalf("Conflicts = %+v, %v; want a.ini", conflicts, err)
+		}
+		return e, conflicts[0], filepath.Join(system, "app"), filepath.Join(repo, "app")
+	}
+	resolved := func(t *testing.T, e *Engine) {
+		t.Helper()
+		report, err := e.Status(ctx)
+		if err != nil || len(report.Entries) != 0 {
+			t.Fatalf("Status = %+v, %v; want the conflict resolved", report, err)
+		}
+	}
+
+	t.Run("take repo", func(t *testing.T) {
+		e, entry, system, repo := conflicted(t)
+		if err := e.Resolve(ctx, entry, ResolveRepo); err != nil {
+			t.Fatalf("Resolve: %v", err)
+		}
+		checkFiles(t, system, map[string]string{"a.ini": "k=3\n"})
+		checkFiles(t, repo, map[string]string{"a.ini": "k=3\n"})
+		resolved(t, e)
+	})
+
+	t.Run("content", func(t *testing.T) {
+		e, entry, system, repo := conflicted(t)
+		if err := e.ResolveContent(ctx, entry, []byte("k=4\n")); err != nil {
+			t.Fatalf("ResolveContent: %v", err)
+		}
+		checkFiles(t, system, map[string]string{"a.ini": "k=4\n"})
+		checkFiles(t, repo, map[string]string{"a.ini": "k=4\n"})
+		resolved(t, e)
+	})
+
+	t.Run("refuses changed files", func(t *testing.T) {
+		e, entry, system, repo := conflicted(t)
+		writeFile(t, filepath.Join(repo, "a.ini"), "k=5\n")
+		var stale *StalePlanError
+		if err := e.Resolve(ctx, entry, ResolveSystem); !errors.As(err, &stale) {
+			t.Fatalf("Resolve = %v, want a stale plan error", err)
+		}
+		if err := e.ResolveContent(ctx, entry, []byte("k=4\n")); !errors.As(err, &stale) {
+			t.Fatalf("ResolveContent = %v, want a stale plan error", err)
+		}
+		checkFiles(t, system, map[string]string{"a.ini": "k=2\n"})
+		checkFiles(t, repo, map[string]string{"a.ini": "k=5\n"})
+	})
+}
@@ -1,20 +1,41 @@
 package engine
 
-import "strings"
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/nir414/pc-setup/syncer/internal/config"
+)
 
 type sectionSpec struct {
 	Name       string
-	EnvVar     string
 	SourceBase string
 	DestBase   string
 	Folders    []folderSpec
 	Matcher    *matcher
+	// FoldCase matches keys of the two sides and the snapshot without
+	// regard to case.
+	FoldCase   bool
+	OnConflict config.ConflictStrategy
+	MergeRules []mergeRule
+	// MaxDeletes and MaxDeletePercent limit deletions per folder and per
+	// section; zero selects the default and a negative value disables the
+	// limit.
+	MaxDeletes       int
+	MaxDeletePercent int
 }
 
 type folderSpec struct {
 	ConfigPath string
 	SourcePath string
 	DestPath   string
+	// MaxFileSize, when not zero, and SkipBinary are the folder's file
+	// limits, see policyRule.
+	MaxFileSize config.Size
+	SkipBinary  bool
 }
 
 type pathPair struct {
@@ -22,24 +43,69 @@ type pathPair struct {
 	RepoBase   string
 }
 
-type sectionDescriptor struct {
-	EnvVar        string
-	RepositoryDir string
+// sectionRoot returns the system directory of the section called name on
+// the operating system goos.
+func sectionRoot(name string, section config.Section, goos string) (string, error) {
+	if root, ok := section.Roots[goos]; ok {
+		expanded, err := expandRoot(root)
+		if err != nil {
+			return "", err
+		}
+		return absoluteRoot(root, expanded)
+	}
+	if len(section.Roots) > 0 && section.Env == "" && section.Path == "" {
+		return "", fmt.Errorf("no root for %s", goos)
+	}
+
+	if env := section.RootEnv(name); env != "" {
+		root := os.Getenv(env)
+		if root == "" {
+			return "", fmt.Errorf("environment variable %s not set", env)
+		}
+		return root, nil
+	}
+	return absoluteRoot(section.Path, section.Path)
+}
+
+// windowsVar matches %NAME% references; ProgramFiles(x86) needs the parentheses.
+var windowsVar = regexp.MustCompile(`%([A-Za-z_][A-Za-z0-9_()]*)%`)
+
+// expandRoot replaces %NAME%, $NAME and ${NAME} in root with the values of
+// the environment variables. A variable that is not set is an error rather
+// than an empty string, which would turn the root into a relative path.
+func expandRoot(root string) (string, error) {
+	var missing string
+	lookup := func(name string) string {
+		value := os.Getenv(name)
+		if value == "" && missing == "" {
+			missing = name
+		}
+		return value
+	}
+	expanded := windowsVar.ReplaceAllStringFunc(root, func(ref string) string {
+		return lookup(ref[1 : len(ref)-1])
+	})
+	expanded = os.Expand(expanded, lookup)
+	if missing != "" {
+		return "", fmt.Errorf("environment variable %s not set", missing)
+	}
+	return expanded, nil
 }
 
-var knownSections = map[string]sectionDescriptor{
-	"APPDATA": {
-		EnvVar:        "APPDATA",
-		RepositoryDir: "APPDATA",
-	},
-	"LOCALAPPDATA": {
-		EnvVar:        "LOCALAPPDATA",
-		RepositoryDir: "LOCALAPPDATA",
-	},
-	"USERPROFILE": {
-		EnvVar:        "USERPROFILE",
-		RepositoryDir: "USERPROFILE",
-	},
+// absoluteRoot expands a leading ~ in root and checks that the result is an
+// absolute path on this system. configured is the value named in errors.
+func absoluteRoot(configured, root string) (string, error) {
+	if root == "~" || strings.HasPrefix(root, "~/") || strings.HasPrefix(root, `~\`) {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("resolve ~ in %q: %w", configured, err)
+		}
+		root = filepath.Join(home, filepath.FromSlash(root[1:]))
+	}
+	if !filepath.IsAbs(root) {
+		return "", fmt.Errorf("path %q is not absolute on this system", configured)
+	}
+	return filepath.Clean(root), nil
 }
 
 func normaliseFolder(folder string) string {
@@ -0,0 +1,52 @@
+package engine
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/nir414/pc-setup/syncer/internal/config"
+)
+
+func TestSectionRoot(t *testing.T) {
+	base := t.TempDir()
+	t.Setenv("SYNCER_TEST_ROOT", base)
+	t.Setenv("SYNCER_TEST_UNSET", "")
+
+	roots := map[string]string{
+		"windows": "%SYNCER_TEST_ROOT%/Roaming",
+		"linux":   "${SYNCER_TEST_ROOT}/config",
+		"darwin":  "$SYNCER_TEST_UNSET/Library",
+	}
+	tests := []struct {
+		name    string
+		section config.Section
+		goos    string
+		want    string
+		wantErr bool
+	}{
+		{name: "percent variable", section: config.Section{Roots: roots}, goos: "windows", want: filepath.Join(base, "Roaming")},
+		{name: "dollar variable", section: config.Section{Roots: roots}, goos: "linux", want: filepath.Join(base, "config")},
+		{name: "unset variable", section: config.Section{Roots: roots}, goos: "darwin", wantErr: true},
+		{name: "no root for os", section: config.Section{Roots: roots}, goos: "freebsd", wantErr: true},
+		{name: "env fallback", section: config.Section{Roots: roots, Env: "SYNCER_TEST_ROOT"}, goos: "freebsd", want: base},
+		{name: "section name", section: config.Section{}, goos: "linux", want: base},
+		{name: "relative path", section: config.Section{Path: "relative/dir"}, goos: "linux", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sectionRoot("SYNCER_TEST_ROOT", tt.section, tt.goos)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("sectionRoot = %q, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("sectionRoot: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("sectionRoot = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
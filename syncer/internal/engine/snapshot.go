@@ -0,0 +1,117 @@
+package engine
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"github.com/nir414/pc-setup/syncer/internal/state"
+)
+
+// updateSnapshot records the entries plan reconciled in the base snapshot.
+// Skipped entries keep their previous base.
+func (e *Engine) updateSnapshot(ctx context.Context, plan *Plan) error {
+	snapshot, err := e.store.Load(ctx)
+	if err != nil {
+		return &OpError{Op: OpLoadSnapshot, Err: err}
+	}
+
+	variants := make(map[string][]string)
+	for key := range snapshot.Files {
+		variants[e.foldKey(key)] = append(variants[e.foldKey(key)], key)
+	}
+
+	skipped := make(map[string]bool)
+	recorded := make(map[string]bool)
+	for _, action := range plan.Actions {
+		switch action.Kind {
+		case ActionSkip:
+			skipped[e.foldKey(action.Path)] = true
+		case ActionDelete:
+			for _, key := range variants[e.foldKey(action.Path)] {
+				delete(snapshot.Files, key)
+			}
+		case ActionCopy, ActionMerge:
+			// The record doubles as the system-side hash cache. Copies keep
+			// the source mtime, so the target matches the system file; merges
+			// write each side separately, so record the system one.
+			path := action.Target
+			if action.Kind == ActionMerge && plan.Direction == DirectionBackup {
+				path = action.Source
+			}
+			if err := e.setBase(snapshot, action.Path, path); err != nil {
+				return err
+			}
+			recorded[action.Path] = true
+		}
+	}
+
+	// The rescan only refreshes records, so its progress is not reported.
+	observer := e.observer
+	e.observer = nil
+	systemFiles, repoFiles, policySkips, err := e.collectFiles(ctx, snapshot)
+	e.observer = observer
+	if err != nil {
+		return err
+	}
+	kept := e.policySkipped(policySkips)
+
+	for key, sys := range systemFiles {
+		repo := repoFiles[key]
+		if skipped[key] || repo == nil || repo.Hash != sys.Hash || repo.Path != sys.Path {
+			continue
+		}
+		recorded[sys.Path] = true
+		if record, ok := snapshot.Files[sys.Path]; ok && record.Hash == sys.Hash && record.Binary != nil {
+			continue
+		}
+		binary := sys.binary
+		snapshot.Files[sys.Path] = state.FileRecord{Hash: sys.Hash, Size: sys.Size, ModTime: sys.ModTime, Binary: &binary}
+		e.storeBase(sys.Path, sys.AbsPath, sys.Hash, sys.Size)
+	}
+	e.pruneCaseVariants(snapshot, recorded)
+	for key := range snapshot.Files {
+		fold := e.foldKey(key)
+		if systemFiles[fold] == nil && repoFiles[fold] == nil && !kept[fold] && e.scanned(key) {
+			delete(snapshot.Files, key)
+		}
+	}
+
+	if err := e.store.Save(ctx, snapshot); err != nil {
+		return &OpError{Op: OpSaveSnapshot, Err: err}
+	}
+	return nil
+}
+
+// scanned reports whether key lies in a folder scanned on this machine.
+// Records outside them belong to folders suppressed by a machine profile or
+// sections without a root here, and are kept for the machines syncing them.
+func (e *Engine) scanned(key string) bool {
+	for _, section := range e.targets {
+		for _, folder := range section.Folders {
+			if strings.HasPrefix(key, makeKey(section.Name, toForwardSlashes(folder.ConfigPath))+"/") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// setBase records the current content of path as the base of key.
+func (e *Engine) setBase(snapshot *state.Snapshot, key, path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return &OpError{Op: OpScan, Path: key, Err: err}
+	}
+	hash, err := hashFile(path)
+	if err != nil {
+		return &OpError{Op: OpScan, Path: key, Err: err}
+	}
+	snapshot.Files[key] = state.FileRecord{
+		Hash:    hash,
+		Size:    info.Size(),
+		ModTime: info.ModTime().UTC(),
+	}
+	e.storeBase(key, path, hash, info.Size())
+	return nil
+}
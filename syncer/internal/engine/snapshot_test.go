@@ -0,0 +1,96 @@
+package engine
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/nir414/pc-setup/syncer/internal/config"
+	"github.com/nir414/pc-setup/syncer/internal/objects"
+	"github.com/nir414/pc-setup/syncer/internal/state"
+)
+
+func TestSkippedEntriesKeepBase(t *testing.T) {
+	root := t.TempDir()
+	system := filepath.Join(root, "sys")
+	t.Setenv("APPDATA", system)
+
+	repo := filepath.Join(root, "SyncData", "APPDATA")
+	writeFile(t, filepath.Join(system, "app", "a.ini"), "a\n")
+	writeFile(t, filepath.Join(system, "app", "b.ini"), "b\n")
+	writeFile(t, filepath.Join(repo, "app", "a.ini"), "a\n")
+	writeFile(t, filepath.Join(repo, "app", "b.ini"), "b\n")
+
+	e := New(Options{
+		Root:          root,
+		Config:        &config.Config{SyncData: map[string]config.Section{"APPDATA": {Folders: []string{"app"}}}},
+		SnapshotStore: state.NewFileStore(filepath.Join(root, ".syncer", "state.json")),
+	})
+	ctx := context.Background()
+	if _, err := e.Sync(ctx, RunOptions{}); err != nil {
+		t.Fatalf("initial Sync: %v", err)
+	}
+
+	// A local edit is skipped by sync and must still need a backup afterwards;
+	// an edit on both sides must stay a conflict.
+	writeFile(t, filepath.Join(system, "app", "a.ini"), "a local\n")
+	writeFile(t, filepath.Join(system, "app", "b.ini"), "b local\n")
+	writeFile(t, filepath.Join(repo, "app", "b.ini"), "b repo\n")
+	result, err := e.Sync(ctx, RunOptions{})
+	if err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if result.SkippedFiles != 1 || result.PendingFiles != 1 || result.UpdatedFiles != 0 {
+		t.Fatalf("Sync = %+v; want the conflict skipped and the local edit pending", result)
+	}
+
+	report, err := e.Status(ctx)
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	want := map[string]DiffStatus{
+		"APPDATA/app/a.ini": DiffStatusSystemModified,
+		"APPDATA/app/b.ini": DiffStatusConflict,
+	}
+	if len(report.Entries) != len(want) {
+		t.Fatalf("Status entries = %+v; want %v", report.Entries, want)
+	}
+	for _, entry := range report.Entries {
+		if entry.Status != want[entry.Path] {
+			t.Fatalf("%s = %s after skipped sync, want %s", entry.Path, entry.Status, want[entry.Path])
+		}
+	}
+}
+
+func TestBaseContentOnlyForMergeableFiles(t *testing.T) {
+	root := t.TempDir()
+	system := filepath.Join(root, "sys")
+	t.Setenv("APPDATA", system)
+	writeFile(t, filepath.Join(system, "app", "a.ini"), "a\n")
+	writeFile(t, filepath.Join(system, "app", "cache.bin"), "b\x00\n")
+	writeFile(t, filepath.Join(system, "app", "session.xml"), "<s/>\n")
+
+	store := objects.NewStore(filepath.Join(root, ".syncer", "objects"))
+	e := New(Options{
+		Root: root,
+		Config: &config.Config{SyncData: map[string]config.Section{"APPDATA": {
+			Folders: []string{"app"},
+			Merge:   map[string]config.MergeDriver{"session.xml": config.MergeNone},
+		}}},
+		SnapshotStore: state.NewFileStore(filepath.Join(root, ".syncer", "state.json")),
+		Objects:       store,
+	})
+	if _, err := e.Backup(context.Background(), RunOptions{}); err != nil {
+		t.Fatalf("Backup: %v", err)
+	}
+
+	for name, want := range map[string]bool{"a.ini": true, "cache.bin": false, "session.xml": false} {
+		hash, err := hashFile(filepath.Join(system, "app", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := store.Has(hash); got != want {
+			t.Errorf("base of %s stored = %v, want %v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package engine
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// syncIgnoreName is the file holding a directory's own exclude rules.
+const syncIgnoreName = ".syncignore"
+
+// ignoreFiles holds the .syncignore rules of a folder's directories, keyed
+// by the directory relative to the folder. Rules are read from both sides so
+// the system and repository scans skip the same paths even before an edited
+// .syncignore has been synced. Together they work like nested .gitignore
+// files on top of the section's excludes: rules are checked from the
+// outermost file to the innermost and the last matching one wins, so a
+// !pattern in a deeper file re-includes what a parent file ignored.
+type ignoreFiles struct {
+	folder folderSpec
+	dirs   map[string][][]rule
+}
+
+func newIgnoreFiles(folder folderSpec) *ignoreFiles {
+	return &ignoreFiles{folder: folder, dirs: make(map[string][][]rule)}
+}
+
+// load reads the .syncignore files of dir, a slash-separated path relative
+// to the folder, from both sides. A .syncignore that is not a regular file
+// counts as absent.
+func (f *ignoreFiles) load(dir string) error {
+	for _, base := range []string{f.folder.SourcePath, f.folder.DestPath} {
+		path := filepath.Join(base, filepath.FromSlash(dir), syncIgnoreName)
+		info, err := os.Stat(path)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ENOTDIR) {
+				continue
+			}
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if rules := parseIgnoreFile(string(data)); len(rules) > 0 {
+			f.dirs[dir] = append(f.dirs[dir], rules)
+		}
+	}
+	return nil
+}
+
+// skip reports whether the .syncignore files in the directories above rel,
+// a path relative to the folder, ignore it.
+func (f *ignoreFiles) skip(rel string, isDir bool) bool {
+	if len(f.dirs) == 0 {
+		return false
+	}
+	segments := strings.Split(rel, "/")
+	ignored := false
+	for i := 0; i < len(segments); i++ {
+		for _, rules := range f.dirs[strings.Join(segments[:i], "/")] {
+			if positive, matched := lastMatch(rules, segments[i:], isDir); matched {
+				ignored = positive
+			}
+		}
+	}
+	return ignored
+}
+
+// parseIgnoreFile parses .gitignore-style content: one pattern per line,
+// with blank lines and lines starting with # ignored.
+func parseIgnoreFile(content string) []rule {
+	var patterns []string
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	return parseRules(patterns)
+}
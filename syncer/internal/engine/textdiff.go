@@ -0,0 +1,285 @@
+package engine
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+type editKind int
+
+const (
+	editEqual editKind = iota
+	editDelete
+	editInsert
+)
+
+// lineEdit is a single step of an edit script. Old and New hold the cursor
+// positions in the old and new line slices before the step is applied.
+type lineEdit struct {
+	Kind editKind
+	Old  int
+	New  int
+}
+
+// splitLines splits content into lines that keep their terminators so a
+// missing final newline is preserved as a difference.
+func splitLines(content string) []string {
+	if content == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(content, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// maxEditDistance bounds the work spent on a single edit script. Inputs
+// that differ by more lines than this are treated as a full replacement.
+const maxEditDistance = 2048
+
+// diffLines computes a shortest edit script between a and b using Myers'
+// algorithm, after stripping the common prefix and suffix.
+func diffLines(a, b []string) []lineEdit {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	edits := make([]lineEdit, 0, len(a)+len(b)-prefix-suffix)
+	for i := 0; i < prefix; i++ {
+		edits = append(edits, lineEdit{Kind: editEqual, Old: i, New: i})
+	}
+	for _, edit := range myersDiff(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]) {
+		edit.Old += prefix
+		edit.New += prefix
+		edits = append(edits, edit)
+	}
+	for i := suffix; i > 0; i-- {
+		edits = append(edits, lineEdit{Kind: editEqual, Old: len(a) - i, New: len(b) - i})
+	}
+	return edits
+}
+
+func myersDiff(a, b []string) []lineEdit {
+	n, m := len(a), len(b)
+	limit := min(n+m, maxEditDistance)
+	offset := limit + 1
+	v := make([]int, 2*limit+3)
+	// trace[d] holds v[-d+1..d-1] as it was before round d, which is all
+	// the backtracking step of round d reads.
+	var trace [][]int32
+	found := false
+
+search:
+	for d := 0; d <= limit; d++ {
+		round := make([]int32, 0, max(2*d-1, 0))
+		for k := -d + 1; k <= d-1; k++ {
+			round = append(round, int32(v[offset+k]))
+		}
+		trace = append(trace, round)
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				found = true
+				break search
+			}
+		}
+	}
+
+	if !found {
+		return replaceLines(n, m)
+	}
+
+	var reversed []lineEdit
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		prevX, prevY := 0, 0
+		if d > 0 {
+			at := func(k int) int { return int(trace[d][k+d-1]) }
+			k := x - y
+			prevK := k - 1
+			if k == -d || (k != d && at(k-1) < at(k+1)) {
+				prevK = k + 1
+			}
+			prevX = at(prevK)
+			prevY = prevX - prevK
+		}
+		for x > prevX && y > prevY {
+			reversed = append(reversed, lineEdit{Kind: editEqual, Old: x - 1, New: y - 1})
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				reversed = append(reversed, lineEdit{Kind: editInsert, Old: x, New: y - 1})
+			} else {
+				reversed = append(reversed, lineEdit{Kind: editDelete, Old: x - 1, New: y})
+			}
+		}
+		x, y = prevX, prevY
+	}
+
+	edits := make([]lineEdit, len(reversed))
+	for i, edit := range reversed {
+		edits[len(reversed)-1-i] = edit
+	}
+	return edits
+}
+
+// replaceLines is the edit script that deletes all n old lines and inserts
+// all m new ones.
+func replaceLines(n, m int) []lineEdit {
+	edits := make([]lineEdit, 0, n+m)
+	for i := 0; i < n; i++ {
+		edits = append(edits, lineEdit{Kind: editDelete, Old: i, New: 0})
+	}
+	for j := 0; j < m; j++ {
+		edits = append(edits, lineEdit{Kind: editInsert, Old: n, New: j})
+	}
+	return edits
+}
+
+// unifiedDiff renders the difference between a and b in unified format with
+// the given number of context lines. It returns an empty string when the
+// inputs are identical.
+func unifiedDiff(oldName, newName string, a, b []string, context int) string {
+	edits := diffLines(a, b)
+
+	var buf strings.Builder
+	i := 0
+	for i < len(edits) {
+		for i < len(edits) && edits[i].Kind == editEqual {
+			i++
+		}
+		if i == len(edits) {
+			break
+		}
+
+		start := max(i-context, 0)
+		end := i
+		for {
+			for end < len(edits) && edits[end].Kind != editEqual {
+				end++
+			}
+			run := end
+			for run < len(edits) && edits[run].Kind == editEqual {
+				run++
+			}
+			if run == len(edits) || run-end > 2*context {
+				end = min(end+context, run)
+				break
+			}
+			end = run
+		}
+
+		if buf.Len() == 0 {
+			fmt.Fprintf(&buf, "--- %s\n+++ %s\n", oldName, newName)
+		}
+		writeHunk(&buf, a, b, edits[start:end])
+		i = end
+	}
+	return buf.String()
+}
+
+func writeHunk(buf *strings.Builder, a, b []string, hunk []lineEdit) {
+	oldCount, newCount := 0, 0
+	for _, edit := range hunk {
+		switch edit.Kind {
+		case editEqual:
+			oldCount++
+			newCount++
+		case editDelete:
+			oldCount++
+		case editInsert:
+			newCount++
+		}
+	}
+
+	// Lines are shown without their endings, so a hunk whose lines differ
+	// only by CRLF against LF says so in its header.
+	deleted := make(map[string]string)
+	for _, edit := range hunk {
+		if edit.Kind == editDelete {
+			deleted[trimNewline(a[edit.Old])] = a[edit.Old]
+		}
+	}
+	var endings []string
+	for _, edit := range hunk {
+		if edit.Kind != editInsert {
+			continue
+		}
+		line := b[edit.New]
+		old, ok := deleted[trimNewline(line)]
+		if !ok || old == line || !strings.HasSuffix(old, "\n") || !strings.HasSuffix(line, "\n") {
+			continue
+		}
+		change := lineEnding(old) + " -> " + lineEnding(line)
+		if !slices.Contains(endings, change) {
+			endings = append(endings, change)
+		}
+	}
+
+	fmt.Fprintf(buf, "@@ -%s +%s @@",
+		hunkRange(hunk[0].Old, oldCount),
+		hunkRange(hunk[0].New, newCount),
+	)
+	if len(endings) > 0 {
+		fmt.Fprintf(buf, " line endings differ: %s", strings.Join(endings, ", "))
+	}
+	buf.WriteByte('\n')
+	for _, edit := range hunk {
+		switch edit.Kind {
+		case editEqual:
+			writeHunkLine(buf, ' ', a[edit.Old])
+		case editDelete:
+			writeHunkLine(buf, '-', a[edit.Old])
+		case editInsert:
+			writeHunkLine(buf, '+', b[edit.New])
+		}
+	}
+}
+
+func lineEnding(line string) string {
+	if strings.HasSuffix(line, "\r\n") {
+		return "CRLF"
+	}
+	return "LF"
+}
+
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+func writeHunkLine(buf *strings.Builder, marker byte, line string) {
+	buf.WriteByte(marker)
+	if strings.HasSuffix(line, "\n") {
+		buf.WriteString(trimNewline(line))
+		buf.WriteByte('\n')
+		return
+	}
+	buf.WriteString(line)
+	buf.WriteString("\n\\ No newline at end of file\n")
+}
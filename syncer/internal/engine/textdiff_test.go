@@ -0,0 +1,118 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	cases := []struct {
+		name string
+		old  string
+		new  string
+		want string
+	}{
+		{"identical", "a\nb\n", "a\nb\n", ""},
+		{
+			"modified line",
+			"a\nb\nc\n",
+			"a\nB\nc\n",
+			"--- old\n+++ new\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n",
+		},
+		{
+			"added file",
+			"",
+			"x\n",
+			"--- old\n+++ new\n@@ -0,0 +1 @@\n+x\n",
+		},
+		{
+			"missing final newline",
+			"a\n",
+			"a",
+			"--- old\n+++ new\n@@ -1 +1 @@\n-a\n+a\n\\ No newline at end of file\n",
+		},
+		{
+			"crlf is trimmed for display",
+			"k=1\r\n",
+			"k=2\r\n",
+			"--- old\n+++ new\n@@ -1 +1 @@\n-k=1\n+k=2\n",
+		},
+		{
+			"a changed line ending is shown",
+			"a\nk=1\r\n",
+			"a\nk=1\n",
+			"--- old\n+++ new\n@@ -1,2 +1,2 @@ line endings differ: CRLF -> LF\n a\n-k=1\n+k=1\n",
+		},
+		{
+			"separate hunks",
+			"1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			"one\n2\n3\n4\n5\n6\n7\n8\n9\nten\n",
+			"--- old\n+++ new\n@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n 4\n@@ -7,4 +7,4 @@\n 7\n 8\n 9\n-10\n+ten\n",
+		},
+	}
+
+	for _, tc := range cases {
+		got := unifiedDiff("old", "new", splitLines(tc.old), splitLines(tc.new), diffContextLines)
+		if got != tc.want {
+			t.Fatalf("%s: unifiedDiff mismatch\ngot:\n%s\nwant:\n%s", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestDiffLinesLargeInput(t *testing.T) {
+	// apply rebuilds the new lines from an edit script, checking that the
+	// equal lines really match.
+	apply := func(a, b []string, edits []lineEdit) []string {
+		t.Helper()
+		var out []string
+		for _, edit := range edits {
+			switch edit.Kind {
+			case editEqual:
+				if a[edit.Old] != b[edit.New] {
+					t.Fatalf("edit marks %q and %q equal", a[edit.Old], b[edit.New])
+				}
+				out = append(out, a[edit.Old])
+			case editInsert:
+				out = append(out, b[edit.New])
+			}
+		}
+		return out
+	}
+
+	a := make([]string, 50000)
+	for i := range a {
+		a[i] = fmt.Sprintf("line %d", i)
+	}
+	b := append([]string(nil), a...)
+	b[100] = "changed"
+	b[25000] = "changed"
+	b = append(b[:40000], b[40010:]...)
+	edits := diffLines(a, b)
+	if got := apply(a, b, edits); strings.Join(got, "\n") != strings.Join(b, "\n") {
+		t.Fatal("edit script does not rebuild the new lines")
+	}
+	if changed := len(edits) - countEqual(edits); changed != 14 {
+		t.Fatalf("edit script changes %d lines, want 14", changed)
+	}
+
+	// Inputs that differ beyond maxEditDistance become a full replacement.
+	c := make([]string, 5000)
+	for i := range c {
+		c[i] = fmt.Sprintf("other %d", i)
+	}
+	edits = diffLines(a[:5000], c)
+	if got := apply(a[:5000], c, edits); strings.Join(got, "\n") != strings.Join(c, "\n") || countEqual(edits) != 0 {
+		t.Fatal("unrelated inputs are not replaced wholesale")
+	}
+}
+
+func countEqual(edits []lineEdit) int {
+	n := 0
+	for _, edit := range edits {
+		if edit.Kind == editEqual {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,129 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/nir414/pc-setup/syncer/internal/trash"
+)
+
+// discard removes the target of a delete action, moving it to the trash
+// when one is configured.
+func (e *Engine) discard(action Action, side Resolution, direction string) error {
+	if e.trash == nil {
+		if err := os.Remove(action.Target); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return &OpError{Op: OpRemove, Path: action.Path, Err: err}
+		}
+		return nil
+	}
+
+	if _, err := os.Stat(action.Target); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	reason := action.Reason
+	if reason == "" {
+		reason = "removed"
+	}
+	_, err := e.trash.Put(action.Target, trash.Item{
+		Key:       action.Path,
+		Side:      string(side),
+		Direction: direction,
+		Reason:    reason,
+		Host:      e.hostname,
+	})
+	if err != nil {
+		return &OpError{Op: OpRemove, Path: action.Path, Err: err}
+	}
+	return nil
+}
+
+// TrashItems lists the files in the trash, oldest first.
+func (e *Engine) TrashItems() ([]trash.Item, error) {
+	items, err := e.trash.List()
+	if err != nil {
+		return nil, &OpError{Op: OpTrash, Err: err}
+	}
+	return items, nil
+}
+
+// RestoreTrash moves a trashed file back to where it was removed from. An
+// existing file at that location is only replaced when overwrite is set, and
+// is preserved in the history first.
+func (e *Engine) RestoreTrash(ctx context.Context, id string, overwrite bool) (trash.Item, error) {
+	if err := ctx.Err(); err != nil {
+		return trash.Item{}, err
+	}
+	if err := e.checkInterrupted(); err != nil {
+		return trash.Item{}, err
+	}
+
+	item, err := e.trash.Get(id)
+	if err != nil {
+		return trash.Item{}, err
+	}
+	if _, err := os.Stat(item.Original); err == nil {
+		if !overwrite {
+			return trash.Item{}, &OpError{Op: OpTrash, Path: item.Key,
+				Err: fmt.Errorf("%s already exists", item.Original)}
+		}
+		if err := e.preserve(item.Key, item.Original, Resolution(item.Side), directionRestore, reasonRestored); err != nil {
+			return trash.Item{}, err
+		}
+	}
+
+	if err := e.trash.Restore(item, item.Original); err != nil {
+		return trash.Item{}, &OpError{Op: OpTrash, Path: item.Key, Err: err}
+	}
+	return item, nil
+}
+
+// PurgeTrash permanently deletes files trashed before cutoff, then removes
+// the stored contents nothing refers to any more.
+func (e *Engine) PurgeTrash(ctx context.Context, cutoff time.Time) ([]trash.Item, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	items, err := e.trash.Purge(cutoff)
+	if err != nil {
+		return items, &OpError{Op: OpTrash, Err: err}
+	}
+	if err := e.pruneObjects(ctx); err != nil {
+		return items, &OpError{Op: OpTrash, Err: err}
+	}
+	return items, nil
+}
+
+// pruneObjects removes the objects that no trash item, history entry or
+// base in the snapshot refers to.
+func (e *Engine) pruneObjects(ctx context.Context) error {
+	if e.objects == nil {
+		return nil
+	}
+	keep := make(map[string]bool)
+	items, err := e.trash.List()
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		keep[item.Hash] = true
+	}
+	versions, err := e.history.Entries("")
+	if err != nil {
+		return err
+	}
+	for _, version := range versions {
+		keep[version.Hash] = true
+	}
+	snapshot, err := e.store.Load(ctx)
+	if err != nil {
+		return err
+	}
+	for _, record := range snapshot.Files {
+		keep[record.Hash] = true
+	}
+
+	return e.objects.Prune(keep)
+}
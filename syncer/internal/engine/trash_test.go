@@ -0,0 +1,65 @@
+package engine
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nir414/pc-setup/syncer/internal/config"
+	"github.com/nir414/pc-setup/syncer/internal/objects"
+	"github.com/nir414/pc-setup/syncer/internal/state"
+	"github.com/nir414/pc-setup/syncer/internal/trash"
+)
+
+func TestPurgeTrashFreesContent(t *testing.T) {
+	root := t.TempDir()
+	system := filepath.Join(root, "sys")
+	t.Setenv("APPDATA", system)
+	repo := filepath.Join(root, "SyncData", "APPDATA")
+	writeFile(t, filepath.Join(system, "app", "keep.ini"), "keep\n")
+	writeFile(t, filepath.Join(system, "app", "gone.ini"), "gone\n")
+
+	store := objects.NewStore(filepath.Join(root, ".syncer", "objects"))
+	e := New(Options{
+		Root:          root,
+		Config:        &config.Config{SyncData: map[string]config.Section{"APPDATA": {Folders: []string{"app"}}}},
+		SnapshotStore: state.NewFileStore(filepath.Join(root, ".syncer", "state.json")),
+		Objects:       store,
+		Trash:         trash.NewBin(filepath.Join(root, ".syncer", "trash"), store),
+	})
+	ctx := context.Background()
+	if _, err := e.Backup(ctx, RunOptions{}); err != nil {
+		t.Fatalf("Backup: %v", err)
+	}
+	keep, err := hashFile(filepath.Join(system, "app", "keep.ini"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gone, err := hashFile(filepath.Join(system, "app", "gone.ini"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Remove(filepath.Join(repo, "app", "gone.ini")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Sync(ctx, RunOptions{AllowDeletes: true}); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if !store.Has(gone) {
+		t.Fatal("trashed content not in the object store")
+	}
+
+	purged, err := e.PurgeTrash(ctx, time.Now().Add(time.Minute))
+	if err != nil || len(purged) != 1 {
+		t.Fatalf("PurgeTrash = %+v, %v; want one item", purged, err)
+	}
+	if store.Has(gone) {
+		t.Fatal("content of the purged file is still stored")
+	}
+	if !store.Has(keep) {
+		t.Fatal("base content of keep.ini was pruned")
+	}
+}
@@ -0,0 +1,105 @@
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/nir414/pc-setup/syncer/internal/objects"
+)
+
+// Entry records a version of a file that was overwritten or removed. The
+// content itself lives in the object store under Hash.
+type Entry struct {
+	Key       string    `json:"key"`
+	Hash      string    `json:"hash"`
+	Size      int64     `json:"size"`
+	Time      time.Time `json:"time"`
+	Host      string    `json:"host"`
+	Direction string    `json:"direction"`
+	Side      string    `json:"side"`
+	Reason    string    `json:"reason"`
+}
+
+// Log is an append-only index of preserved versions, stored as one JSON
+// record per line.
+type Log struct {
+	path string
+}
+
+// NewLog returns a log stored at path. The file is created lazily.
+func NewLog(path string) *Log {
+	return &Log{path: path}
+}
+
+// Append adds entries to the end of the log.
+func (l *Log) Append(entries ...Entry) error {
+	if l == nil || l.path == "" {
+		return errors.New("no history log configured")
+	}
+	if err := os.MkdirAll(filepath.Dir(l.path), 0o755); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(file)
+	for _, entry := range entries {
+		if err := enc.Encode(entry); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Entries returns the entries recorded for key in the order they were
+// appended. An empty key returns every entry. A line without a key or a
+// valid object hash is an error.
+func (l *Log) Entries(key string) ([]Entry, error) {
+	if l == nil || l.path == "" {
+		return nil, nil
+	}
+
+	file, err := os.Open(l.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", l.path, line, err)
+		}
+		if entry.Key == "" || !objects.ValidHash(entry.Hash) {
+			return nil, fmt.Errorf("%s:%d: malformed entry: key %q, hash %q", l.path, line, entry.Key, entry.Hash)
+		}
+		if key == "" || entry.Key == key {
+			entries = append(entries, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
@@ -0,0 +1,26 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEntriesRejectsMalformed(t *testing.T) {
+	hash := strings.Repeat("ab", 32)
+	for _, line := range []string{
+		`{"key":"S/a.ini","hash":""}`,
+		`{"key":"S/a.ini","hash":"abc"}`,
+		`{"key":"","hash":"` + hash + `"}`,
+	} {
+		path := filepath.Join(t.TempDir(), "history.jsonl")
+		content := `{"key":"S/a.ini","hash":"` + hash + `"}` + "\n" + line + "\n"
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewLog(path).Entries(""); err == nil || !strings.Contains(err.Error(), ":2:") {
+			t.Errorf("Entries with %s = %v; want an error for line 2", line, err)
+		}
+	}
+}
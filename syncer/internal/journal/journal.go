@@ -0,0 +1,207 @@
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/nir414/pc-setup/syncer/internal/objects"
+)
+
+const (
+	logFileName    = "journal.jsonl"
+	objectsDirName = "objects"
+)
+
+// Record kinds.
+const (
+	KindBegin  = "begin"
+	KindIntent = "intent"
+	KindDone   = "done"
+)
+
+// ErrActive is returned by Begin while the journal of another run exists.
+var ErrActive = errors.New("journal of an unfinished run exists")
+
+// ErrCorrupt is returned by Load for a journal without a complete begin
+// record.
+var ErrCorrupt = errors.New("journal is corrupt")
+
+// File is a file an action is about to write or remove. Hash is the hash of
+// its original content, empty when the file did not exist.
+type File struct {
+	Key  string `json:"key"`
+	Side string `json:"side"`
+	Path string `json:"path"`
+	Hash string `json:"hash,omitempty"`
+}
+
+// Record is one line of the journal. A run starts with a begin record that
+// carries the plan, followed by an intent record before each action touches
+// any file and a done record once it has finished.
+type Record struct {
+	Kind  string          `json:"kind"`
+	Time  time.Time       `json:"time"`
+	Host  string          `json:"host,omitempty"`
+	Plan  json.RawMessage `json:"plan,omitempty"`
+	Index int             `json:"index"`
+	Files []File          `json:"files,omitempty"`
+}
+
+// Intent is an action that was started, with the files it was about to touch.
+type Intent struct {
+	Index int
+	Files []File
+	Done  bool
+}
+
+// Run is the journaled state of a run that did not finish.
+type Run struct {
+	Host      string
+	StartedAt time.Time
+	Plan      json.RawMessage
+	Intents   []Intent
+}
+
+// Journal is a write-ahead log of a single backup or sync run, stored in dir
+// together with the original content of every file the run touches. It is
+// removed when the run finishes, so its presence means a run was
+// interrupted.
+type Journal struct {
+	dir     string
+	objects *objects.Store
+}
+
+// New returns a journal stored in dir. The directory is created lazily.
+func New(dir string) *Journal {
+	return &Journal{dir: dir, objects: objects.NewStore(filepath.Join(dir, objectsDirName))}
+}
+
+// Begin starts journaling a run of plan. It fails with ErrActive if the
+// journal of an earlier run still exists.
+func (j *Journal) Begin(host string, plan any) error {
+	data, err := json.Marshal(plan)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(j.dir, 0o755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(j.logPath(), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return ErrActive
+		}
+		return err
+	}
+	file.Close()
+	return j.append(Record{Kind: KindBegin, Time: time.Now().UTC(), Host: host, Plan: data})
+}
+
+// Intend records that action index is about to touch files. The current
+// content of every existing file is saved first so it can be put back.
+func (j *Journal) Intend(index int, files []File) error {
+	for i := range files {
+		files[i].Hash = ""
+		if _, err := os.Stat(files[i].Path); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		hash, err := j.objects.PutFile(files[i].Path)
+		if err != nil {
+			return err
+		}
+		files[i].Hash = hash
+	}
+	return j.append(Record{Kind: KindIntent, Time: time.Now().UTC(), Index: index, Files: files})
+}
+
+// Done records that action index finished.
+func (j *Journal) Done(index int) error {
+	return j.append(Record{Kind: KindDone, Time: time.Now().UTC(), Index: index})
+}
+
+// Finish removes the journal once its run is complete or recovered.
+func (j *Journal) Finish() error {
+	return os.RemoveAll(j.dir)
+}
+
+// Original returns the saved content of a journaled file.
+func (j *Journal) Original(hash string) ([]byte, error) {
+	return j.objects.Get(hash)
+}
+
+// Load returns the run recorded in the journal, or nil when there is none.
+func (j *Journal) Load() (*Run, error) {
+	file, err := os.Open(j.logPath())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	var run *Run
+	intents := make(map[int]int)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			// A torn final line is expected after a crash.
+			break
+		}
+		if run == nil {
+			if record.Kind != KindBegin {
+				return nil, fmt.Errorf("%s:%d: %w: it does not start with a begin record", j.logPath(), line, ErrCorrupt)
+			}
+			run = &Run{Host: record.Host, StartedAt: record.Time, Plan: record.Plan}
+			continue
+		}
+		switch record.Kind {
+		case KindIntent:
+			intents[record.Index] = len(run.Intents)
+			run.Intents = append(run.Intents, Intent{Index: record.Index, Files: record.Files})
+		case KindDone:
+			if i, ok := intents[record.Index]; ok {
+				run.Intents[i].Done = true
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if run == nil {
+		// Begin was cut short, before the run touched any file.
+		return nil, fmt.Errorf("%s: %w: its begin record is incomplete; the run changed no files, so %s can be removed",
+			j.logPath(), ErrCorrupt, j.dir)
+	}
+	return run, nil
+}
+
+func (j *Journal) logPath() string {
+	return filepath.Join(j.dir, logFileName)
+}
+
+func (j *Journal) append(record Record) error {
+	file, err := os.OpenFile(j.logPath(), os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(file).Encode(record); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,68 @@
+package journal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJournalRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	j := New(filepath.Join(dir, "journal"))
+
+	existing := filepath.Join(dir, "app.ini")
+	if err := os.WriteFile(existing, []byte("k=v\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "new.ini")
+
+	if err := j.Begin("host", map[string]string{"direction": "sync"}); err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+	if err := j.Begin("host", nil); !errors.Is(err, ErrActive) {
+		t.Fatalf("second Begin = %v, want ErrActive", err)
+	}
+	if err := j.Intend(0, []File{{Key: "APPDATA/app.ini", Side: "system", Path: existing}}); err != nil {
+		t.Fatalf("Intend: %v", err)
+	}
+	if err := j.Done(0); err != nil {
+		t.Fatalf("Done: %v", err)
+	}
+	if err := j.Intend(1, []File{{Key: "APPDATA/new.ini", Side: "system", Path: missing}}); err != nil {
+		t.Fatalf("Intend: %v", err)
+	}
+
+	// Simulate a write torn by a crash.
+	f, err := os.OpenFile(filepath.Join(dir, "journal", logFileName), os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"kind":"done","ind`)
+	f.Close()
+
+	run, err := j.Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if run == nil || run.Host != "host" || string(run.Plan) != `{"direction":"sync"}` || len(run.Intents) != 2 {
+		t.Fatalf("Load = %+v; want the begin record and two intents", run)
+	}
+	if !run.Intents[0].Done || run.Intents[1].Done {
+		t.Fatalf("intents = %+v; want only the first done", run.Intents)
+	}
+	if run.Intents[1].Files[0].Hash != "" {
+		t.Fatalf("missing file recorded with hash %q", run.Intents[1].Files[0].Hash)
+	}
+	content, err := j.Original(run.Intents[0].Files[0].Hash)
+	if err != nil || string(content) != "k=v\n" {
+		t.Fatalf("Original = %q, %v", content, err)
+	}
+
+	if err := j.Finish(); err != nil {
+		t.Fatalf("Finish: %v", err)
+	}
+	if run, err := j.Load(); run != nil || err != nil {
+		t.Fatalf("Load after Finish = %+v, %v; want nothing", run, err)
+	}
+}
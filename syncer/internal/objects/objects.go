@@ -0,0 +1,213 @@
+package objects
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Store keeps file contents addressed by their SHA-256 hash, using the same
+// hex encoding as the engine's file hashes.
+type Store struct {
+	dir string
+}
+
+// NewStore returns a store rooted at dir. The directory is created lazily.
+func NewStore(dir string) *Store {
+	return &Store{dir: dir}
+}
+
+// Has reports whether content with the given hash is stored.
+func (s *Store) Has(hash string) bool {
+	if s == nil || !ValidHash(hash) {
+		return false
+	}
+	_, err := os.Stat(s.path(hash))
+	return err == nil
+}
+
+// Get returns the content stored under hash.
+func (s *Store) Get(hash string) ([]byte, error) {
+	if s == nil {
+		return nil, errors.New("no object store configured")
+	}
+	if !ValidHash(hash) {
+		return nil, fmt.Errorf("invalid object hash %q", hash)
+	}
+	return os.ReadFile(s.path(hash))
+}
+
+// Open returns a reader for the content stored under hash.
+func (s *Store) Open(hash string) (*os.File, error) {
+	if s == nil {
+		return nil, errors.New("no object store configured")
+	}
+	if !ValidHash(hash) {
+		return nil, fmt.Errorf("invalid object hash %q", hash)
+	}
+	return os.Open(s.path(hash))
+}
+
+// Put stores content and returns its hash.
+func (s *Store) Put(content []byte) (string, error) {
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+	if s.Has(hash) {
+		return hash, nil
+	}
+	return hash, s.write(hash, func(w io.Writer) error {
+		_, err := w.Write(content)
+		return err
+	})
+}
+
+// PutFile stores the content of the file at path and returns its hash.
+func (s *Store) PutFile(path string) (string, error) {
+	if s == nil {
+		return "", errors.New("no object store configured")
+	}
+	src, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(s.dir, "incoming-*")
+	if err != nil {
+		return "", err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	hasher := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, hasher), src); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+
+	hash := hex.EncodeToString(hasher.Sum(nil))
+	if s.Has(hash) {
+		return hash, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path(hash)), 0o755); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmpPath, s.path(hash)); err != nil {
+		return "", err
+	}
+	return hash, nil
+}
+
+func (s *Store) write(hash string, fill func(io.Writer) error) error {
+	if s == nil {
+		return errors.New("no object store configured")
+	}
+	target := s.path(hash)
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(target), "incoming-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	if err := fill(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, target)
+}
+
+// Prune removes every object whose hash is not in keep.
+func (s *Store) Prune(keep map[string]bool) error {
+	if s == nil {
+		return nil
+	}
+	prefixes, err := os.ReadDir(s.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, prefix := range prefixes {
+		if !prefix.IsDir() {
+			continue
+		}
+		dir := filepath.Join(s.dir, prefix.Name())
+		files, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			hash := prefix.Name() + file.Name()
+			if !ValidHash(hash) || keep[hash] {
+				continue
+			}
+			if err := os.Remove(filepath.Join(dir, file.Name())); err != nil {
+				return err
+			}
+		}
+		os.Remove(dir)
+	}
+	return nil
+}
+
+func (s *Store) path(hash string) string {
+	return filepath.Join(s.dir, hash[:2], hash[2:])
+}
+
+// ValidHash reports whether hash can name an object: a hex-encoded SHA-256
+// digest.
+func ValidHash(hash string) bool {
+	if len(hash) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(hash)
+	return err == nil
+}
+
+// HashFile returns the hash of the file at path, giving up between reads
+// once ctx is done so large files do not delay cancellation.
+func HashFile(ctx context.Context, path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, contextReader{ctx: ctx, r: f}); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
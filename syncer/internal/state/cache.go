@@ -0,0 +1,108 @@
+package state
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// HashCache remembers the hashes of files by key together with the size and
+// modification time they had when hashed, so unchanged files need not be
+// read again. Each Save keeps only the entries used since the cache was
+// loaded, which drops files that no longer exist. It is safe for concurrent
+// use.
+type HashCache struct {
+	mu     sync.Mutex
+	path   string
+	loaded bool
+	files  map[string]FileRecord
+	used   map[string]FileRecord
+}
+
+// NewHashCache returns a cache stored at path. The file is read on first use
+// and created by Save.
+func NewHashCache(path string) *HashCache {
+	return &HashCache{path: path}
+}
+
+// Lookup returns the cached record of key if it has the given size and
+// modification time.
+func (c *HashCache) Lookup(key string, size int64, modTime time.Time) (FileRecord, bool) {
+	if c == nil {
+		return FileRecord{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.load()
+	record, ok := c.files[key]
+	if !ok || record.Size != size || !record.ModTime.Equal(modTime) {
+		return FileRecord{}, false
+	}
+	c.used[key] = record
+	return record, true
+}
+
+// Record stores the hash of key.
+func (c *HashCache) Record(key string, record FileRecord) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.load()
+	c.files[key] = record
+	c.used[key] = record
+}
+
+// Save writes the entries used since the cache was loaded.
+func (c *HashCache) Save() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.path == "" || !c.loaded {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(c.used)
+	if err != nil {
+		return err
+	}
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, c.path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	c.files = c.used
+	c.used = make(map[string]FileRecord)
+	return nil
+}
+
+// load reads the cache file once. A missing or unreadable cache is treated
+// as empty; it only costs a rescan.
+func (c *HashCache) load() {
+	if c.loaded {
+		return
+	}
+	c.loaded = true
+	c.files = make(map[string]FileRecord)
+	c.used = make(map[string]FileRecord)
+	if c.path == "" {
+		return
+	}
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		return
+	}
+	if err := json.Unmarshal(data, &c.files); err != nil || c.files == nil {
+		c.files = make(map[string]FileRecord)
+	}
+}
@@ -0,0 +1,42 @@
+package state
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHashCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hashes.json")
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	cache := NewHashCache(path)
+	cache.Record("S/a.ini", FileRecord{Hash: "aaa", Size: 10, ModTime: modTime})
+	cache.Record("S/b.ini", FileRecord{Hash: "bbb", Size: 20, ModTime: modTime})
+	if err := cache.Save(); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	cache = NewHashCache(path)
+	if record, ok := cache.Lookup("S/a.ini", 10, modTime); !ok || record.Hash != "aaa" {
+		t.Fatalf("Lookup = %+v, %v; want the recorded hash", record, ok)
+	}
+	if _, ok := cache.Lookup("S/a.ini", 11, modTime); ok {
+		t.Fatal("Lookup ignored a changed size")
+	}
+	if _, ok := cache.Lookup("S/a.ini", 10, modTime.Add(time.Second)); ok {
+		t.Fatal("Lookup ignored a changed modification time")
+	}
+	// b.ini was not used since loading, so Save drops it.
+	if err := cache.Save(); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	cache = NewHashCache(path)
+	if _, ok := cache.Lookup("S/a.ini", 10, modTime); !ok {
+		t.Fatal("Save dropped a used key")
+	}
+	if _, ok := cache.Lookup("S/b.ini", 20, modTime); ok {
+		t.Fatal("Save kept a key that was not used")
+	}
+}
@@ -0,0 +1,161 @@
+package state
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MachineInfo describes the snapshot a machine keeps in a shared repository.
+type MachineInfo struct {
+	ID       string
+	Host     string
+	LastSync time.Time
+	Files    int
+}
+
+// MachineID returns the ID stored in the file at path and whether it was
+// stored, or a new ID derived from host without the file.
+func MachineID(path, host string) (string, bool, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		id := strings.TrimSpace(string(data))
+		if !ValidMachineID(id) {
+			return "", false, fmt.Errorf("invalid machine id %q in %s", id, path)
+		}
+		return id, true, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return "", false, err
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", false, err
+	}
+	return HostMachineID(host) + "-" + hex.EncodeToString(suffix), false, nil
+}
+
+// HostMachineID returns an ID derived from host alone, for machines that
+// have nowhere to keep a machine-id file.
+func HostMachineID(host string) string {
+	return machineIDPrefix(host)
+}
+
+// saveMachineID writes id to the file at path and returns it. If another
+// process wrote the file first, it returns the ID recorded there instead.
+func saveMachineID(path, id string) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if errors.Is(err, os.ErrExist) {
+		stored, _, err := MachineID(path, "")
+		return stored, err
+	}
+	if err != nil {
+		return "", err
+	}
+	if _, err := file.WriteString(id + "\n"); err != nil {
+		file.Close()
+		return "", err
+	}
+	return id, file.Close()
+}
+
+// ValidMachineID reports whether id can name a snapshot file.
+func ValidMachineID(id string) bool {
+	if id == "" || id[0] == '.' {
+		return false
+	}
+	for _, r := range id {
+		if !isIDRune(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func machineIDPrefix(host string) string {
+	prefix := strings.Map(func(r rune) rune {
+		if r >= 'A' && r <= 'Z' {
+			return r + 'a' - 'A'
+		}
+		if isIDRune(r) && r != '.' {
+			return r
+		}
+		return -1
+	}, host)
+	if prefix == "" {
+		return "machine"
+	}
+	return prefix
+}
+
+func isIDRune(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.'
+}
+
+// NewMachineStore returns the store of the snapshot machine id keeps in dir.
+// Until that snapshot is first saved, the single snapshot at legacy, used
+// before snapshots were kept per machine, is loaded in its place; saving
+// removes it, so only the first machine to run adopts the old base. When
+// idFile is set, saving also records id there, or switches to the ID another
+// process recorded first.
+func NewMachineStore(dir, id, host, legacy, idFile string) *FileStore {
+	return &FileStore{
+		path:    filepath.Join(dir, id+".json"),
+		legacy:  legacy,
+		idFile:  idFile,
+		machine: id,
+		host:    host,
+	}
+}
+
+// ListMachines returns the machines with a snapshot in dir, most recently
+// synced first.
+func ListMachines(dir string) ([]MachineInfo, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var machines []MachineInfo
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || filepath.Ext(name) != ".json" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		var snapshot Snapshot
+		if err := json.Unmarshal(data, &snapshot); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		machines = append(machines, MachineInfo{
+			ID:       strings.TrimSuffix(name, ".json"),
+			Host:     snapshot.Host,
+			LastSync: snapshot.GeneratedAt,
+			Files:    len(snapshot.Files),
+		})
+	}
+	sort.Slice(machines, func(i, j int) bool {
+		if !machines[i].LastSync.Equal(machines[j].LastSync) {
+			return machines[i].LastSync.After(machines[j].LastSync)
+		}
+		return machines[i].ID < machines[j].ID
+	})
+	return machines, nil
+}
@@ -0,0 +1,121 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMachineStoreMigratesLegacySnapshot(t *testing.T) {
+	root := t.TempDir()
+	legacy := filepath.Join(root, "state.json")
+	dir := filepath.Join(root, "state")
+	ctx := context.Background()
+
+	old := NewSnapshot()
+	old.Files["APPDATA/a.txt"] = FileRecord{Hash: "abc", Size: 3}
+	if err := NewFileStore(legacy).Save(ctx, old); err != nil {
+		t.Fatal(err)
+	}
+
+	first := NewMachineStore(dir, "desk-0001", "DESK", legacy, "")
+	snapshot, err := first.Load(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Files["APPDATA/a.txt"].Hash != "abc" {
+		t.Fatalf("legacy snapshot not loaded: %+v", snapshot.Files)
+	}
+	if err := first.Save(ctx, snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(legacy); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("legacy snapshot still present: %v", err)
+	}
+
+	second := NewMachineStore(dir, "laptop-0002", "LAPTOP", legacy, "")
+	snapshot, err = second.Load(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshot.Files) != 0 {
+		t.Fatalf("second machine adopted the migrated base: %+v", snapshot.Files)
+	}
+	if err := second.Save(ctx, snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	// Two saves can share a clock tick, so order the machines explicitly.
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	setGeneratedAt(t, filepath.Join(dir, "desk-0001.json"), base)
+	setGeneratedAt(t, filepath.Join(dir, "laptop-0002.json"), base.Add(time.Hour))
+
+	machines, err := ListMachines(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(machines) != 2 || machines[0].ID != "laptop-0002" || machines[1].Host != "DESK" || machines[1].Files != 1 {
+		t.Fatalf("ListMachines = %+v", machines)
+	}
+}
+
+func TestMachineID(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "syncer", "machine-id")
+	id, stored, err := MachineID(path, "Work PC.local")
+	if err != nil || stored {
+		t.Fatalf("MachineID = %q, stored %v, %v; want a new id", id, stored, err)
+	}
+	if !ValidMachineID(id) || !strings.HasPrefix(id, "workpclocal-") {
+		t.Fatalf("MachineID = %q", id)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("MachineID wrote the id file: %v", err)
+	}
+
+	store := NewMachineStore(filepath.Join(dir, "state"), id, "Work PC.local", "", path)
+	if err := store.Save(context.Background(), NewSnapshot()); err != nil {
+		t.Fatal(err)
+	}
+	again, stored, err := MachineID(path, "renamed")
+	if err != nil || !stored || again != id {
+		t.Fatalf("MachineID after save = %q, stored %v, %v; want stored %q", again, stored, err, id)
+	}
+
+	// A store that lost the race to record its ID saves under the one
+	// recorded first.
+	racing := NewMachineStore(filepath.Join(dir, "state"), "workpclocal-ffff", "Work PC.local", "", path)
+	snapshot := NewSnapshot()
+	snapshot.Files["S/a.ini"] = FileRecord{Hash: "aaa"}
+	if err := racing.Save(context.Background(), snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "state", "workpclocal-ffff.json")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("saved under the random id: %v", err)
+	}
+	loaded, err := racing.Load(context.Background())
+	if err != nil || loaded.Machine != id || len(loaded.Files) != 1 {
+		t.Fatalf("Load = %+v, %v; want the snapshot of %q", loaded, err, id)
+	}
+}
+
+func setGeneratedAt(t *testing.T, path string, at time.Time) {
+	t.Helper()
+	snapshot, err := readSnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot.GeneratedAt = at
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
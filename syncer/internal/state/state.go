@@ -13,11 +13,16 @@ type FileRecord struct {
 	Hash    string    `json:"hash"`
 	Size    int64     `json:"size"`
 	ModTime time.Time `json:"mod_time"`
+	// Binary records whether the file looked binary; nil if never checked.
+	Binary *bool `json:"binary,omitempty"`
 }
 
 type Snapshot struct {
-	GeneratedAt time.Time             `json:"generated_at"`
-	Files       map[string]FileRecord `json:"files"`
+	GeneratedAt time.Time `json:"generated_at"`
+	// Machine and Host identify the machine a per-machine snapshot belongs to.
+	Machine string                `json:"machine,omitempty"`
+	Host    string                `json:"host,omitempty"`
+	Files   map[string]FileRecord `json:"files"`
 }
 
 type Store interface {
@@ -26,7 +31,11 @@ type Store interface {
 }
 
 type FileStore struct {
-	path string
+	path    string
+	legacy  string
+	idFile  string
+	machine string
+	host    string
 }
 
 func NewFileStore(path string) *FileStore {
@@ -46,11 +55,19 @@ func (s *FileStore) Load(ctx context.Context) (*Snapshot, error) {
 		return NewSnapshot(), nil
 	}
 
-	data, err := os.ReadFile(s.path)
+	snapshot, err := readSnapshot(s.path)
+	if errors.Is(err, os.ErrNotExist) && s.legacy != "" {
+		snapshot, err = readSnapshot(s.legacy)
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return NewSnapshot(), nil
+	}
+	return snapshot, err
+}
+
+func readSnapshot(path string) (*Snapshot, error) {
+	data, err := os.ReadFile(path)
 	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return NewSnapshot(), nil
-		}
 		return nil, err
 	}
 
@@ -76,7 +93,20 @@ func (s *FileStore) Save(ctx context.Context, snapshot *Snapshot) error {
 		snapshot = NewSnapshot()
 	}
 
+	if s.idFile != "" {
+		id, err := saveMachineID(s.idFile, s.machine)
+		if err != nil {
+			return err
+		}
+		s.path = filepath.Join(filepath.Dir(s.path), id+".json")
+		s.machine = id
+		s.idFile = ""
+	}
 	snapshot.GeneratedAt = time.Now().UTC()
+	if s.machine != "" {
+		snapshot.Machine = s.machine
+		snapshot.Host = s.host
+	}
 
 	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
 		return err
@@ -97,5 +127,10 @@ func (s *FileStore) Save(ctx context.Context, snapshot *Snapshot) error {
 		return err
 	}
 
+	if s.legacy != "" {
+		if err := os.Remove(s.legacy); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
 	return nil
 }
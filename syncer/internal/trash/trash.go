@@ -0,0 +1,277 @@
+package trash
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nir414/pc-setup/syncer/internal/objects"
+)
+
+const (
+	metaFileName    = "meta.json"
+	dateLayout      = "2006-01-02"
+	entryTimeLayout = "150405"
+)
+
+// ErrNotFound is returned for trash IDs that do not exist.
+var ErrNotFound = errors.New("trash item not found")
+
+// Item describes a file moved to the trash. ID is derived from its location
+// and is not stored in the metadata file.
+type Item struct {
+	ID        string    `json:"-"`
+	Key       string    `json:"key"`
+	Original  string    `json:"original"`
+	Side      string    `json:"side"`
+	Direction string    `json:"direction"`
+	Reason    string    `json:"reason"`
+	Host      string    `json:"host"`
+	DeletedAt time.Time `json:"deleted_at"`
+	Hash      string    `json:"hash"`
+	Size      int64     `json:"size"`
+	ModTime   time.Time `json:"mod_time"`
+}
+
+// Bin keeps removed files under dir, grouped by the day they were removed:
+// <dir>/<date>/<time>-<hash>/meta.json describes a file whose content is kept
+// in the object store.
+type Bin struct {
+	dir     string
+	objects *objects.Store
+}
+
+// NewBin returns a trash bin rooted at dir that keeps contents in store. The
+// directory is created lazily.
+func NewBin(dir string, store *objects.Store) *Bin {
+	return &Bin{dir: dir, objects: store}
+}
+
+// Put moves the file at path into the trash and records item as its
+// metadata. DeletedAt, Hash, Size, ModTime and Original are filled in by
+// Put.
+func (b *Bin) Put(path string, item Item) (Item, error) {
+	if b == nil || b.dir == "" {
+		return Item{}, errors.New("no trash directory configured")
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return Item{}, err
+	}
+	hash, err := b.objects.PutFile(path)
+	if err != nil {
+		return Item{}, err
+	}
+
+	item.Original = path
+	item.Size = info.Size()
+	item.ModTime = info.ModTime().UTC()
+	item.Hash = hash
+	if item.DeletedAt.IsZero() {
+		item.DeletedAt = time.Now().UTC()
+	}
+
+	local := item.DeletedAt.Local()
+	base := filepath.Join(local.Format(dateLayout), local.Format(entryTimeLayout)+"-"+hash[:8])
+	id := base
+	for n := 2; ; n++ {
+		if _, err := os.Stat(filepath.Join(b.dir, id)); errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		id = base + "-" + strconv.Itoa(n)
+	}
+	item.ID = filepath.ToSlash(id)
+
+	entryDir := filepath.Join(b.dir, id)
+	if err := os.MkdirAll(entryDir, 0o755); err != nil {
+		return Item{}, err
+	}
+	if err := writeMeta(entryDir, item); err != nil {
+		os.RemoveAll(entryDir)
+		return Item{}, err
+	}
+	if err := os.Remove(path); err != nil {
+		os.RemoveAll(entryDir)
+		return Item{}, err
+	}
+	return item, nil
+}
+
+// List returns all items in the trash, oldest first.
+func (b *Bin) List() ([]Item, error) {
+	if b == nil || b.dir == "" {
+		return nil, nil
+	}
+
+	days, err := os.ReadDir(b.dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var items []Item
+	for _, day := range days {
+		if !day.IsDir() {
+			continue
+		}
+		entries, err := os.ReadDir(filepath.Join(b.dir, day.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				continue
+			}
+			item, err := b.Get(day.Name() + "/" + entry.Name())
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].DeletedAt.Before(items[j].DeletedAt)
+	})
+	return items, nil
+}
+
+// Get returns the item stored under id.
+func (b *Bin) Get(id string) (Item, error) {
+	entryDir, err := b.entryDir(id)
+	if err != nil {
+		return Item{}, err
+	}
+	data, err := os.ReadFile(filepath.Join(entryDir, metaFileName))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return Item{}, fmt.Errorf("%w: %s", ErrNotFound, id)
+		}
+		return Item{}, err
+	}
+	var item Item
+	if err := json.Unmarshal(data, &item); err != nil {
+		return Item{}, fmt.Errorf("%s: %w", id, err)
+	}
+	item.ID = filepath.ToSlash(id)
+	return item, nil
+}
+
+// Restore moves the content of item back to dst and removes the item.
+func (b *Bin) Restore(item Item, dst string) error {
+	entryDir, err := b.entryDir(item.ID)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	if err := b.copyObject(item, dst); err != nil {
+		return err
+	}
+	return b.remove(entryDir)
+}
+
+// copyObject writes the stored content of item to dst with its original
+// modification time.
+func (b *Bin) copyObject(item Item, dst string) error {
+	src, err := b.objects.Open(item.Hash)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp := dst + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, src)
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && !item.ModTime.IsZero() {
+		err = os.Chtimes(tmp, time.Now(), item.ModTime)
+	}
+	if err == nil {
+		err = os.Rename(tmp, dst)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// Purge deletes every item removed before cutoff and returns them. Their
+// content is left in the object store for the caller to prune.
+func (b *Bin) Purge(cutoff time.Time) ([]Item, error) {
+	items, err := b.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var purged []Item
+	for _, item := range items {
+		if !item.DeletedAt.Before(cutoff) {
+			continue
+		}
+		entryDir, err := b.entryDir(item.ID)
+		if err != nil {
+			return purged, err
+		}
+		if err := b.remove(entryDir); err != nil {
+			return purged, err
+		}
+		purged = append(purged, item)
+	}
+	return purged, nil
+}
+
+// entryDir maps id to its directory, rejecting IDs that point outside the
+// trash.
+func (b *Bin) entryDir(id string) (string, error) {
+	if b == nil || b.dir == "" {
+		return "", errors.New("no trash directory configured")
+	}
+	parts := strings.Split(filepath.ToSlash(id), "/")
+	if len(parts) != 2 || !validName(parts[0]) || !validName(parts[1]) {
+		return "", fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	return filepath.Join(b.dir, parts[0], parts[1]), nil
+}
+
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `:\`)
+}
+
+// remove deletes an entry directory and its day directory once empty.
+func (b *Bin) remove(entryDir string) error {
+	if err := os.RemoveAll(entryDir); err != nil {
+		return err
+	}
+	dayDir := filepath.Dir(entryDir)
+	if entries, err := os.ReadDir(dayDir); err == nil && len(entries) == 0 {
+		os.Remove(dayDir)
+	}
+	return nil
+}
+
+func writeMeta(entryDir string, item Item) error {
+	data, err := json.MarshalIndent(item, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(entryDir, metaFileName), data, 0o644)
+}
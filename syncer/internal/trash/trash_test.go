@@ -0,0 +1,69 @@
+package trash
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nir414/pc-setup/syncer/internal/objects"
+)
+
+func TestBinRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	store := objects.NewStore(filepath.Join(dir, "objects"))
+	bin := NewBin(filepath.Join(dir, "trash"), store)
+
+	original := filepath.Join(dir, "sys", "app.ini")
+	if err := os.MkdirAll(filepath.Dir(original), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(original, []byte("k=v\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	item, err := bin.Put(original, Item{Key: "APPDATA/app.ini", Side: "system", Reason: "deleted from repository"})
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if _, err := os.Stat(original); !os.IsNotExist(err) {
+		t.Fatalf("original still exists after Put: %v", err)
+	}
+	if !store.Has(item.Hash) {
+		t.Fatalf("content %s not in the object store", item.Hash)
+	}
+	if entries, err := os.ReadDir(filepath.Join(dir, "trash", item.ID)); err != nil || len(entries) != 1 {
+		t.Fatalf("trash entry holds %d files, %v; want only its metadata", len(entries), err)
+	}
+
+	items, err := bin.List()
+	if err != nil || len(items) != 1 || items[0].ID != item.ID || items[0].Key != "APPDATA/app.ini" {
+		t.Fatalf("List = %+v, %v; want the trashed item", items, err)
+	}
+
+	if err := bin.Restore(items[0], original); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if data, err := os.ReadFile(original); err != nil || string(data) != "k=v\n" {
+		t.Fatalf("restored content = %q, %v", data, err)
+	}
+	if items, _ := bin.List(); len(items) != 0 {
+		t.Fatalf("List after Restore = %+v, want empty", items)
+	}
+
+	if _, err := bin.Put(original, Item{Key: "APPDATA/app.ini"}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if purged, err := bin.Purge(time.Now().Add(-time.Hour)); err != nil || len(purged) != 0 {
+		t.Fatalf("Purge of recent items = %+v, %v; want none", purged, err)
+	}
+	if purged, err := bin.Purge(time.Now().Add(time.Minute)); err != nil || len(purged) != 1 {
+		t.Fatalf("Purge = %+v, %v; want one item", purged, err)
+	}
+
+	for _, id := range []string{"../x", "a/b/c", "a/..", ""} {
+		if _, err := bin.Get(id); err == nil {
+			t.Fatalf("Get(%q) succeeded, want error", id)
+		}
+	}
+}